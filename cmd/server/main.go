@@ -1,131 +1,70 @@
 package main
 
 import (
-	"compress/flate"
+	"context"
 	"fmt"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/handlers"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/middlefunc"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/migrations"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/server"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
-// можно не оборачивать в retry
-func catchTermination() {
-	shutdownChan := make(chan os.Signal, 1)
-	signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM)
-	repo := handlers.GetStore()
+func main() {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
-	<-shutdownChan
-	err := repo.StoreMetrics()
+	cfg, err := flags.ParseFlags()
 	if err != nil {
-		log.Fatal().Err(err).Msg("catchTermination")
+		log.Fatal().Err(err).Msg("parse flags")
 	}
 
-	os.Exit(0)
-}
-
-func initStoreTimer() {
-	repo := handlers.GetStore()
+	log.Info().
+		Bool("UseHashKey", cfg.UseHashKey()).
+		Bool("UseTokens", cfg.Tokens != "").
+		Msg("server config")
 
-	if flags.FlagStoreInterval > 0 {
-		for range time.Tick(time.Second * time.Duration(flags.FlagStoreInterval)) {
-			err := repo.StoreMetrics()
-			if err != nil {
-				log.Info().Err(err).Msg("initStoreTimer StoreMetrics")
-			}
-		}
+	repo, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatal().Err(err).Msg("storage init error")
 	}
-}
 
-func restoreMetrics() error {
-	repo := handlers.GetStore()
-
-	if flags.FlagRestore {
-		err := repo.RestoreMetrics()
-		if err != nil {
-			return fmt.Errorf("repo.RestoreMetrics: %w", err)
-		}
+	// log.Fatal не выполняет defer, поэтому хранилища закрываются в run
+	if err := run(cfg, repo); err != nil {
+		log.Fatal().Err(err).Msg("run server")
 	}
-
-	return nil
-}
-
-func run() error {
-
-	mux := chi.NewRouter()
-	mux.Use(middleware.RequestID)
-	mux.Use(middleware.Logger)
-	mux.Use(middleware.Recoverer)
-	mux.Use(middlefunc.GzipDecompression)
-	mux.Use(middleware.Compress(flate.DefaultCompression, "application/json", "text/html"))
-
-	// return all metrics on WEB page
-	mux.Get("/", handlers.RootHandler)
-
-	// ping DB
-	mux.Get("/ping", handlers.PingHandler)
-
-	// get metrics in array
-	//mux.Post("/updates/", handlers.UpdatesHandler)
-	mux.Route("/updates", func(r chi.Router) {
-		r.Use(middlefunc.CheckReqBodySign)
-		r.Post("/", handlers.UpdatesHandler)
-	})
-
-	mux.Post("/update/{metricType}/{metricName}/{metricVal}", handlers.UpdateHandlerLong)
-	//mux.Post("/update/", handlers.UpdateHandler)
-	mux.Route("/update", func(r chi.Router) {
-		r.Use(middlefunc.CheckReqBodySign)
-		r.Post("/", handlers.UpdateHandler)
-	})
-
-	mux.Get("/value/{metricType}/{metricName}", handlers.ValueHandlerLong)
-	//mux.Post("/value/", handlers.ValueHandler)
-	mux.Route("/value", func(r chi.Router) {
-		r.Use(middlefunc.CheckReqBodySign)
-		r.Post("/", handlers.ValueHandler)
-	})
-
-	log.Info().Str("Running on", flags.FlagRunAddr).Msg("Server started")
-	defer log.Info().Msg("Server stopped")
-
-	return http.ListenAndServe(flags.FlagRunAddr, mux)
 }
 
-func main() {
-	var err error
-
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	flags.ParseFlags()
-
-	if flags.StorePoint.DataBase {
-		err = storage.InitConnDB()
-		if err != nil {
-			log.Fatal().Err(err).Msg("DB conn error")
+// run запускает сервер до SIGINT/SIGTERM и закрывает хранилище на любом пути выхода
+func run(cfg *flags.Config, repo storage.Storer) error {
+	defer func() {
+		if err := repo.Close(); err != nil {
+			log.Info().Err(err).Msg("storage close")
 		}
-		defer storage.DBstorage.DBconn.Close()
+	}()
 
-		err = migrations.ApplyMigrations()
+	var opts []server.Option
+	if cfg.Tokens != "" {
+		tokenStore, err := tokens.New(cfg.Tokens)
 		if err != nil {
-			log.Fatal().Err(err).Msg("migration error")
+			return fmt.Errorf("tokens storage init error: %w", err)
 		}
+		defer tokenStore.Close()
+		opts = append(opts, server.WithTokens(tokenStore))
 	}
 
-	go catchTermination()
-	restoreMetrics()
-	go initStoreTimer()
+	srv := server.New(cfg, repo, log.Logger, opts...)
 
-	if err := run(); err != nil {
-		log.Fatal().Err(err).Msg("run mux")
+	if err := srv.RestoreMetrics(); err != nil {
+		log.Info().Err(err).Msg("restore metrics")
 	}
+
+	// SIGINT/SIGTERM отменяют ctx, Run дожидается запросов и сохраняет метрики
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return srv.Run(ctx)
 }
@@ -0,0 +1,294 @@
+// Package agent цикл работы агента: опрос метрик, очереди отправки, воркеры и завершение
+package agent
+
+import (
+	"context"
+	"errors"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/collector"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/metrics"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/queue"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/spool"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultQueueSize емкость очередей, если не задана флагом
+const defaultQueueSize = 1000
+
+// ErrFlushTimeout очереди не успели отправить за flags.ShutdownTimeout
+var ErrFlushTimeout = errors.New("agent: flush deadline exceeded, unsent metrics dropped or spooled")
+
+type Agent struct {
+	httpClient http.Client
+	sp         *spool.Spool // nil - без дисковой очереди
+
+	collectors []collector.Collector
+	cache      *cache
+
+	metricsQueue *queue.Queue
+	batchQueue   *queue.Queue
+}
+
+func New(httpClient http.Client, sp *spool.Spool, collectors []collector.Collector) (*Agent, error) {
+	if len(collectors) == 0 {
+		return nil, errors.New("agent: no collectors")
+	}
+
+	a := &Agent{
+		httpClient: httpClient,
+		sp:         sp,
+		collectors: collectors,
+		cache:      newCache(),
+	}
+
+	queueSize := flags.FlagQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	// очередь для отправки по одной и очередь для батчей: одинаковая политика переполнения,
+	// отброшенные из батчей метрики при наличии spool откладываются на диск
+	a.metricsQueue = queue.New(queue.Config{Capacity: queueSize, Policy: flags.QueuePolicy})
+	batchQueueConfig := queue.Config{Capacity: queueSize, Policy: flags.QueuePolicy}
+	if sp != nil {
+		batchQueueConfig.OnDrop = func(m models.Metric) {
+			if err := metrics.SpoolMetrics(sp, []models.Metric{m}); err != nil {
+				log.Info().Err(err).Msg("SpoolMetrics")
+			}
+		}
+	}
+	a.batchQueue = queue.New(batchQueueConfig)
+
+	return a, nil
+}
+
+// Run работает до отмены ctx. После отмены опрос останавливается, метрики собираются последний раз
+// (в том числе накопленные counter), и очереди отправляются не дольше flags.ShutdownTimeout.
+// Неотправленные батчи при этом уходят в spool
+func (a *Agent) Run(ctx context.Context) error {
+	// отправка прерывается не сигналом, а по истечении времени на завершение
+	sendCtx, cancelSend := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelSend()
+
+	var pollers, senders sync.WaitGroup
+	chCashMetricsErrors := make(chan error, flags.FlagWorkers)
+
+	// создаем пул воркеров
+	for i := 0; i < flags.FlagWorkers; i++ {
+		workerID := i
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			metrics.SendMetricWorker(sendCtx, workerID, a.metricsQueue, chCashMetricsErrors, a.httpClient, flags.FlagRunAddr)
+		}()
+	}
+
+	// горутина принимает ошибки от SendMetricWorker, пока воркеры работают
+	errorsDone := make(chan struct{})
+	go func() {
+		defer close(errorsDone)
+		for err := range chCashMetricsErrors {
+			log.Info().Err(err).Msg("SendMetricWorker error")
+		}
+	}()
+
+	// горутина для отправки метрик батчем
+	senders.Add(1)
+	go func() {
+		defer senders.Done()
+		a.sendBatches(ctx, sendCtx)
+	}()
+
+	// горутины опроса коллекторов, у каждого свой интервал
+	for _, c := range a.collectors {
+		c := c
+		log.Info().Str("collector", c.Name()).Dur("interval", c.Interval()).Msg("collector started")
+
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			a.every(ctx, c.Interval(), func() { a.poll(ctx, c) })
+		}()
+	}
+
+	// горутина: передача накопленного в очереди раз в ReportInterval
+	pollers.Add(1)
+	go func() {
+		defer pollers.Done()
+		a.every(ctx, flags.ReportInterval, func() { a.collect(ctx) })
+	}()
+
+	<-ctx.Done()
+	log.Info().Dur("timeout", flags.ShutdownTimeout).Msg("agent shutting down")
+	pollers.Wait()
+
+	deadline := time.AfterFunc(flags.ShutdownTimeout, cancelSend)
+
+	// последний опрос всех коллекторов: изменения после их последнего тика тоже отправляются
+	var final sync.WaitGroup
+	for _, c := range a.collectors {
+		c := c
+		final.Add(1)
+		go func() {
+			defer final.Done()
+			a.poll(sendCtx, c)
+		}()
+	}
+	final.Wait()
+
+	// последний сбор: все, что успели опросить, отправляется перед выходом
+	a.collect(sendCtx)
+	a.metricsQueue.Close()
+	a.batchQueue.Close()
+
+	senders.Wait()
+	close(chCashMetricsErrors)
+	<-errorsDone
+
+	// таймер уже сработал - отправка была прервана
+	if !deadline.Stop() {
+		metricsStats, batchStats := a.metricsQueue.Stats(), a.batchQueue.Stats()
+		log.Info().Int("metricsQueueLen", metricsStats.Len).Int("batchQueueLen", batchStats.Len).
+			Msg("agent flush timeout")
+		return ErrFlushTimeout
+	}
+
+	log.Info().Msg("agent stopped, queues flushed")
+	return nil
+}
+
+// every вызывает fn раз в interval, пока ctx не отменен
+func (a *Agent) every(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+// poll опрос одного коллектора, результат копится в кеше до отправки
+func (a *Agent) poll(ctx context.Context, c collector.Collector) {
+	list, err := c.Collect(ctx)
+	if err != nil {
+		log.Info().Err(err).Str("collector", c.Name()).Msg("Collect error")
+	}
+	a.cache.add(list)
+}
+
+// collect передача накопленного кеша в очереди metricsQueue и batchQueue.
+// При заполненных очередях действует политика flags.QueuePolicy
+func (a *Agent) collect(ctx context.Context) {
+	list := a.cache.snapshot()
+	log.Info().Int("metrics", len(list)).Msg("CollectMetrics done")
+
+	for _, v := range list {
+		if err := a.metricsQueue.Put(ctx, v); err != nil {
+			log.Info().Err(err).Str("CashMetric", v.String()).Msg("metricsQueue.Put")
+		}
+		if err := a.batchQueue.Put(ctx, v); err != nil {
+			log.Info().Err(err).Str("CashMetric", v.String()).Msg("batchQueue.Put")
+		}
+	}
+}
+
+// sendBatches раз в ReportInterval забирает все из batchQueue и отправляет батчем,
+// неотправленные батчи уходят в spool. После отмены ctx отправляет остаток, пока очередь не закрыта
+func (a *Agent) sendBatches(ctx, sendCtx context.Context) {
+	ticker := time.NewTicker(flags.ReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				batch := a.batchQueue.Drain(sendCtx, 0)
+				if len(batch) == 0 {
+					return
+				}
+				a.sendBatch(sendCtx, batch)
+			}
+		case <-ticker.C:
+			if a.batchQueue.Len() == 0 {
+				continue
+			}
+			a.sendBatch(sendCtx, a.batchQueue.Drain(sendCtx, 0))
+		}
+	}
+}
+
+func (a *Agent) sendBatch(ctx context.Context, batch []models.Metric) {
+	err := metrics.SendMetricBatchSpooled(ctx, metrics.CashMetrics{CashMetrics: batch}, a.sp, a.httpClient, flags.FlagRunAddr)
+	if err != nil {
+		log.Info().Err(err).Msg("SendMetricBatch send error")
+	}
+
+	metricsStats, batchStats := a.metricsQueue.Stats(), a.batchQueue.Stats()
+	log.Info().
+		Int("metricsQueueLen", metricsStats.Len).
+		Int64("metricsDropped", metricsStats.Dropped).
+		Int64("metricsCoalesced", metricsStats.Coalesced).
+		Int64("batchDropped", batchStats.Dropped).
+		Int64("batchCoalesced", batchStats.Coalesced).
+		Msg("send queues")
+}
+
+// cache результаты опросов между отправками: gauge - последнее значение, counter - сумма приращений
+type cache struct {
+	mu      sync.Mutex
+	order   []string
+	metrics map[string]models.Metric
+}
+
+func newCache() *cache {
+	return &cache{metrics: make(map[string]models.Metric)}
+}
+
+func (c *cache) add(list []models.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range list {
+		key := m.MType + ":" + m.ID
+		prev, ok := c.metrics[key]
+		if !ok {
+			c.order = append(c.order, key)
+		}
+		if ok && m.MType == "counter" && prev.Delta != nil && m.Delta != nil {
+			sum := *prev.Delta + *m.Delta
+			m.Delta = &sum
+		}
+		c.metrics[key] = m
+	}
+}
+
+// snapshot метрики в порядке первого появления. Counter обнуляются: их приращение уже отдано,
+// gauge остаются и отправляются снова, пока не придет новое значение
+func (c *cache) snapshot() []models.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]models.Metric, 0, len(c.order))
+	order := c.order[:0]
+	for _, key := range c.order {
+		m := c.metrics[key]
+		result = append(result, m)
+		if m.MType == "counter" {
+			delete(c.metrics, key)
+			continue
+		}
+		order = append(order, key)
+	}
+	c.order = order
+
+	return result
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/collector"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/queue"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/spool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setFlags(t *testing.T, srv *httptest.Server, shutdownTimeout time.Duration) {
+	t.Helper()
+
+	flags.FlagRunAddr = strings.TrimPrefix(srv.URL, "http://")
+	flags.FlagWorkers = 2
+	flags.FlagQueueSize = 0
+	flags.QueuePolicy = queue.Coalesce
+	flags.PollInterval = 10 * time.Millisecond
+	// за время теста отчет по таймеру не отправляется: все приходит при остановке
+	flags.ReportInterval = time.Hour
+	flags.ShutdownTimeout = shutdownTimeout
+}
+
+// stageCollector отдает gauge Stage с текущим значением stage: по нему видно, когда был опрос
+type stageCollector struct {
+	stage atomic.Int64
+}
+
+func (c *stageCollector) Name() string { return "stage" }
+
+func (c *stageCollector) Interval() time.Duration { return time.Hour }
+
+func (c *stageCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	v := float64(c.stage.Load())
+	return []models.Metric{{ID: "Stage", MType: "gauge", Value: &v}}, nil
+}
+
+func TestRunFlushOnShutdown(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]models.Metric
+		singles int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// require в горутине обработчика не останавливает тест, поэтому assert и ответ с ошибкой
+		gz, err := gzip.NewReader(r.Body)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/updates/":
+			var batch []models.Metric
+			if !assert.NoError(t, json.NewDecoder(gz).Decode(&batch)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			batches = append(batches, batch)
+		case "/update/":
+			singles++
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+	setFlags(t, srv, 5*time.Second)
+
+	collectors, err := collector.Build([]string{"runtime"}, nil, flags.PollInterval)
+	require.NoError(t, err)
+	stage := &stageCollector{}
+	a, err := New(*srv.Client(), nil, append(collectors, stage))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	stage.stage.Store(1)
+	cancel()
+
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, batches, 1)
+	var pollCount *models.Metric
+	for i, m := range batches[0] {
+		if m.ID == "PollCount" {
+			pollCount = &batches[0][i]
+		}
+	}
+	// накопленный за время работы PollCount не потерян
+	require.NotNil(t, pollCount)
+	assert.Greater(t, *pollCount.Delta, int64(1))
+	assert.Equal(t, len(batches[0]), singles)
+
+	// коллектор с часовым интервалом опрошен после отмены
+	var stageValue *float64
+	for _, m := range batches[0] {
+		if m.ID == "Stage" {
+			stageValue = m.Value
+		}
+	}
+	require.NotNil(t, stageValue)
+	assert.Equal(t, 1.0, *stageValue)
+}
+
+func TestRunFlushDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	setFlags(t, srv, 200*time.Millisecond)
+
+	sp, err := spool.Open(t.TempDir(), spool.Config{})
+	require.NoError(t, err)
+	defer sp.Close()
+
+	collectors, err := collector.Build([]string{"runtime"}, nil, flags.PollInterval)
+	require.NoError(t, err)
+	a, err := New(*srv.Client(), sp, collectors)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	select {
+	case err = <-done:
+		assert.ErrorIs(t, err, ErrFlushTimeout)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run ignored shutdown deadline")
+	}
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// сервер не ответил - последний батч сохранен на диске
+	assert.False(t, sp.Empty())
+}
+
+func TestCache(t *testing.T) {
+	c := newCache()
+
+	one, two := int64(1), int64(2)
+	v1, v2 := 1.5, 2.5
+	c.add([]models.Metric{{ID: "g", MType: "gauge", Value: &v1}, {ID: "c", MType: "counter", Delta: &one}})
+	c.add([]models.Metric{{ID: "g", MType: "gauge", Value: &v2}, {ID: "c", MType: "counter", Delta: &two}})
+
+	got := c.snapshot()
+	require.Len(t, got, 2)
+	assert.Equal(t, 2.5, *got[0].Value)
+	assert.Equal(t, int64(3), *got[1].Delta)
+
+	// приращение counter отдано, gauge повторяется до нового значения
+	got = c.snapshot()
+	require.Len(t, got, 1)
+	assert.Equal(t, "g", got[0].ID)
+}
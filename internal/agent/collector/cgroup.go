@@ -0,0 +1,323 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("cgroup", NewCgroup)
+}
+
+const (
+	defaultCgroupRoot = "/sys/fs/cgroup"
+	procSelfCgroup    = "/proc/self/cgroup"
+	// cgroupV1Unlimited лимиты v1 от этого значения означают "без ограничения"
+	cgroupV1Unlimited = 1 << 62
+)
+
+// CgroupOptions настройки коллектора "cgroup"
+type CgroupOptions struct {
+	// Root точка монтирования cgroup, по умолчанию /sys/fs/cgroup
+	Root string `json:"root"`
+	// Path путь группы относительно Root, пусто - группа агента из /proc/self/cgroup
+	Path string `json:"path"`
+	// Version "v1", "v2", пусто или "auto" - по содержимому Root
+	Version string `json:"version"`
+}
+
+// CgroupCollector ресурсы cgroup, в которой работает агент (лимиты контейнера):
+// CgroupMemoryUsage, CgroupMemoryWorkingSet (без неактивного page cache), CgroupMemoryLimit,
+// CgroupCPUUsagePercent (100 - одно ядро), CgroupCPULimit (в ядрах), CgroupCPUThrottledPercent
+// (доля времени под ограничением), counter CgroupCPUThrottledPeriods, CgroupPids, CgroupPidsLimit.
+// Лимиты без ограничения и отсутствующие файлы (контроллер выключен) пропускаются
+type CgroupCollector struct {
+	base
+	version string
+	// dirs каталог группы для контроллера, в v2 у всех контроллеров один каталог
+	dirs   map[string]string
+	deltas *deltas
+}
+
+func NewCgroup(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts CgroupOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	return newCgroup(name, interval, opts, procSelfCgroup)
+}
+
+// newCgroup selfCgroup - файл с группами процесса, в тестах подменяется
+func newCgroup(name string, interval time.Duration, opts CgroupOptions, selfCgroup string) (*CgroupCollector, error) {
+	root := opts.Root
+	if root == "" {
+		root = defaultCgroupRoot
+	}
+
+	version := opts.Version
+	switch version {
+	case "", "auto":
+		var err error
+		if version, err = detectCgroupVersion(root); err != nil {
+			return nil, err
+		}
+	case "v1", "v2":
+	default:
+		return nil, fmt.Errorf("unknown cgroup version %q", opts.Version)
+	}
+
+	paths := map[string]string{}
+	if opts.Path == "" {
+		paths = readSelfCgroup(selfCgroup)
+	}
+
+	c := &CgroupCollector{
+		base:    base{name: name, interval: interval},
+		version: version,
+		dirs:    make(map[string]string),
+		deltas:  newDeltas(time.Now),
+	}
+
+	if version == "v2" {
+		path := opts.Path
+		if path == "" {
+			path = paths[""]
+		}
+		c.dirs[""] = groupDir(root, path)
+		return c, nil
+	}
+
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "pids"} {
+		path := opts.Path
+		if path == "" {
+			path = paths[controller]
+		}
+		c.dirs[controller] = groupDir(filepath.Join(root, controller), path)
+	}
+	return c, nil
+}
+
+// detectCgroupVersion v2 (unified) узнается по cgroup.controllers в корне, v1 - по каталогам контроллеров
+func detectCgroupVersion(root string) (string, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return "v2", nil
+	}
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "pids"} {
+		if info, err := os.Stat(filepath.Join(root, controller)); err == nil && info.IsDir() {
+			return "v1", nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v1 or v2 hierarchy found in %s", root)
+}
+
+// readSelfCgroup группы процесса по контроллерам из строк "id:controllers:path",
+// группа v2 - под пустым ключом. Ошибки чтения означают корень иерархии
+func readSelfCgroup(path string) map[string]string {
+	result := make(map[string]string)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return result
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			result[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			result[controller] = parts[2]
+		}
+	}
+	return result
+}
+
+// groupDir каталог группы. Внутри контейнера со своим cgroup namespace группа видна
+// как корень, а путь из /proc/self/cgroup может не существовать - тогда берется корень
+func groupDir(root, path string) string {
+	if path == "" || path == "/" {
+		return root
+	}
+	dir := filepath.Join(root, path)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return root
+}
+
+// readValue одно число из файла, "max" - без ограничения
+func readValue(path string) (uint64, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, true, nil
+}
+
+// readKeyed файл из строк "ключ значение" (memory.stat, cpu.stat)
+func readKeyed(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			result[fields[0]] = v
+		}
+	}
+	return result, nil
+}
+
+func (c *CgroupCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	var (
+		result []models.Metric
+		errs   []error
+	)
+	add := func(name string, v float64) {
+		result = append(result, gauge(name, v))
+	}
+	// отсутствующий файл - выключенный контроллер, это не ошибка
+	check := func(err error) bool {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		return err == nil
+	}
+	value := func(name, path string, limit bool) {
+		v, ok, err := readValue(path)
+		if !check(err) || !ok || (limit && c.version == "v1" && v >= cgroupV1Unlimited) {
+			return
+		}
+		add(name, float64(v))
+	}
+	memory := func(usagePath, statPath, inactiveKey string) {
+		if v, ok, err := workingSet(usagePath, statPath, inactiveKey); check(err) && ok {
+			add("CgroupMemoryWorkingSet", v)
+		}
+	}
+
+	c.deltas.begin()
+	if c.version == "v2" {
+		dir := c.dirs[""]
+		value("CgroupMemoryUsage", filepath.Join(dir, "memory.current"), false)
+		value("CgroupMemoryLimit", filepath.Join(dir, "memory.max"), true)
+		memory(filepath.Join(dir, "memory.current"), filepath.Join(dir, "memory.stat"), "inactive_file")
+		value("CgroupPids", filepath.Join(dir, "pids.current"), false)
+		value("CgroupPidsLimit", filepath.Join(dir, "pids.max"), true)
+
+		// cpu.stat в микросекундах
+		if stat, err := readKeyed(filepath.Join(dir, "cpu.stat")); check(err) {
+			result = append(result, c.cpu(stat, "usage_usec", "throttled_usec", 1e4)...)
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, "cpu.max")); check(err) {
+			// "квота период", квота "max" - без ограничения
+			fields := strings.Fields(string(data))
+			if len(fields) == 2 && fields[0] != "max" {
+				quota, err1 := strconv.ParseFloat(fields[0], 64)
+				period, err2 := strconv.ParseFloat(fields[1], 64)
+				if err1 == nil && err2 == nil && period > 0 {
+					add("CgroupCPULimit", quota/period)
+				}
+			}
+		}
+	} else {
+		memDir, cpu, cpuacct, pids := c.dirs["memory"], c.dirs["cpu"], c.dirs["cpuacct"], c.dirs["pids"]
+		value("CgroupMemoryUsage", filepath.Join(memDir, "memory.usage_in_bytes"), false)
+		value("CgroupMemoryLimit", filepath.Join(memDir, "memory.limit_in_bytes"), true)
+		memory(filepath.Join(memDir, "memory.usage_in_bytes"), filepath.Join(memDir, "memory.stat"), "total_inactive_file")
+		value("CgroupPids", filepath.Join(pids, "pids.current"), false)
+		value("CgroupPidsLimit", filepath.Join(pids, "pids.max"), true)
+
+		// cpuacct.usage и throttled_time в наносекундах
+		stat := make(map[string]uint64)
+		if v, ok, err := readValue(filepath.Join(cpuacct, "cpuacct.usage")); check(err) && ok {
+			stat["usage"] = v
+		}
+		if s, err := readKeyed(filepath.Join(cpu, "cpu.stat")); check(err) {
+			for k, v := range s {
+				stat[k] = v
+			}
+		}
+		result = append(result, c.cpu(stat, "usage", "throttled_time", 1e7)...)
+
+		// квота -1 - без ограничения, поэтому читается как знаковое
+		quota, err1 := os.ReadFile(filepath.Join(cpu, "cpu.cfs_quota_us"))
+		period, err2 := os.ReadFile(filepath.Join(cpu, "cpu.cfs_period_us"))
+		if check(err1) && check(err2) {
+			q, err1 := strconv.ParseFloat(strings.TrimSpace(string(quota)), 64)
+			p, err2 := strconv.ParseFloat(strings.TrimSpace(string(period)), 64)
+			if err1 == nil && err2 == nil && q > 0 && p > 0 {
+				add("CgroupCPULimit", q/p)
+			}
+		}
+	}
+
+	// ошибка одного файла не отменяет остальные метрики
+	c.deltas.end()
+
+	return result, errors.Join(errs...)
+}
+
+// workingSet использование памяти без неактивного page cache, который ядро вытеснит первым
+func workingSet(usagePath, statPath, inactiveKey string) (float64, bool, error) {
+	usage, ok, err := readValue(usagePath)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	stat, err := readKeyed(statPath)
+	if err != nil {
+		return 0, false, err
+	}
+	inactive := min(stat[inactiveKey], usage)
+	return float64(usage - inactive), true, nil
+}
+
+// cpu скорости из счетчиков процессорного времени, perPercent - единиц счетчика в секунду на 1%
+func (c *CgroupCollector) cpu(stat map[string]uint64, usageKey, throttledKey string, perPercent float64) []models.Metric {
+	var result []models.Metric
+	if v, ok := stat[usageKey]; ok {
+		if rate, ok := c.deltas.rate("usage", v); ok {
+			result = append(result, gauge("CgroupCPUUsagePercent", rate/perPercent))
+		}
+	}
+	if v, ok := stat[throttledKey]; ok {
+		if rate, ok := c.deltas.rate("throttled", v); ok {
+			result = append(result, gauge("CgroupCPUThrottledPercent", rate/perPercent))
+		}
+	}
+	if v, ok := stat["nr_throttled"]; ok {
+		if delta, ok := c.deltas.delta("nr_throttled", v); ok {
+			result = append(result, counter("CgroupCPUThrottledPeriods", int64(delta)))
+		}
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupV2(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	root := copyFixture(t, "testdata/cgroup/v2")
+
+	c, err := newCgroup("cgroup", time.Second, CgroupOptions{Root: root}, filepath.Join(root, "self"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", c.version)
+	assert.Equal(t, filepath.Join(root, "agent"), c.dirs[""])
+	c.deltas = newDeltas(clk.now)
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"CgroupMemoryUsage":      104857600,
+		"CgroupMemoryLimit":      268435456,
+		"CgroupMemoryWorkingSet": 104857600 - 20971520,
+		"CgroupPids":             12,
+		"CgroupCPULimit":         1.5,
+	}, gaugeValues(list), "pids.max is unlimited, rates start from the second poll")
+
+	// за 2 секунды: 1 секунда процессора, 0.2 секунды под ограничением в 4 периодах
+	clk.t = clk.t.Add(2 * time.Second)
+	stat := "usage_usec 6000000\nnr_periods 120\nnr_throttled 14\nthrottled_usec 400000\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "agent", "cpu.stat"), []byte(stat), 0o644))
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	values := gaugeValues(list)
+	assert.InDelta(t, 50.0, values["CgroupCPUUsagePercent"], 0.001)
+	assert.InDelta(t, 10.0, values["CgroupCPUThrottledPercent"], 0.001)
+	periods, ok := find(list, "CgroupCPUThrottledPeriods")
+	require.True(t, ok)
+	assert.Equal(t, int64(4), *periods.Delta)
+}
+
+func TestCgroupV1(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	root := copyFixture(t, "testdata/cgroup/v1")
+
+	// путь /docker/... из /proc/self/cgroup в namespace контейнера не виден, берется корень
+	c, err := newCgroup("cgroup", time.Second, CgroupOptions{Root: root}, filepath.Join(root, "self"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", c.version)
+	assert.Equal(t, filepath.Join(root, "memory"), c.dirs["memory"])
+	c.deltas = newDeltas(clk.now)
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"CgroupMemoryUsage":      52428800,
+		"CgroupMemoryWorkingSet": 52428800 - 10485760,
+		"CgroupPids":             7,
+		"CgroupPidsLimit":        100,
+	}, gaugeValues(list), "memory and cpu limits are unlimited")
+
+	clk.t = clk.t.Add(time.Second)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpuacct", "cpuacct.usage"), []byte("5000000000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu", "cpu.stat"),
+		[]byte("nr_periods 60\nnr_throttled 6\nthrottled_time 150000000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"), []byte("50000\n"), 0o644))
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	values := gaugeValues(list)
+	assert.InDelta(t, 200.0, values["CgroupCPUUsagePercent"], 0.001)
+	assert.InDelta(t, 5.0, values["CgroupCPUThrottledPercent"], 0.001)
+	assert.Equal(t, 0.5, values["CgroupCPULimit"])
+	periods, ok := find(list, "CgroupCPUThrottledPeriods")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), *periods.Delta)
+}
+
+func TestCgroupOptions(t *testing.T) {
+	_, err := newCgroup("cgroup", time.Second, CgroupOptions{Root: t.TempDir()}, "")
+	assert.Error(t, err, "no hierarchy")
+
+	_, err = newCgroup("cgroup", time.Second, CgroupOptions{Root: "testdata/cgroup/v2", Version: "v3"}, "")
+	assert.Error(t, err)
+
+	// явный путь и версия
+	c, err := newCgroup("cgroup", time.Second, CgroupOptions{Root: "testdata/cgroup/v2", Path: "/agent", Version: "v2"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("testdata/cgroup/v2", "agent"), c.dirs[""])
+
+	// нечитаемое значение - ошибка опроса, остальные метрики отправляются
+	root := copyFixture(t, "testdata/cgroup/v2")
+	require.NoError(t, os.WriteFile(filepath.Join(root, "agent", "memory.current"), []byte("garbage"), 0o644))
+	c, err = newCgroup("cgroup", time.Second, CgroupOptions{Root: root}, filepath.Join(root, "self"))
+	require.NoError(t, err)
+	list, err := c.Collect(context.Background())
+	assert.ErrorContains(t, err, "memory.current")
+	assert.Equal(t, map[string]float64{
+		"CgroupMemoryLimit": 268435456,
+		"CgroupPids":        12,
+		"CgroupCPULimit":    1.5,
+	}, gaugeValues(list))
+}
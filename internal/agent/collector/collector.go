@@ -0,0 +1,323 @@
+// Package collector источники метрик агента. Каждый коллектор регистрируется под своим именем
+// в init файла с реализацией, включается и настраивается отдельно, а агент опрашивает его
+// со своим интервалом
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector источник метрик. Collect вызывается раз в Interval из одной горутины.
+// Counter возвращается приращением с прошлого вызова, gauge - текущим значением
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]models.Metric, error)
+	Interval() time.Duration
+}
+
+// Duration интервал в JSON строкой "2s", "1m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config настройки одного коллектора
+type Config struct {
+	// Enabled nil - включен, если указан в списке коллекторов
+	Enabled *bool `json:"enabled,omitempty"`
+	// Interval 0 - интервал опроса агента
+	Interval Duration `json:"interval,omitempty"`
+	// Options собственные настройки коллектора, разбираются фабрикой
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// Factory создает коллектор с уже определенным интервалом
+type Factory func(name string, interval time.Duration, options json.RawMessage) (Collector, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Factory)
+)
+
+// Register добавляет коллектор в реестр, вызывается из init
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("collector: duplicate registration " + name)
+	}
+	registry[name] = factory
+}
+
+// Names зарегистрированные коллекторы по алфавиту
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	result := make([]string, 0, len(registry))
+	for name := range registry {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ParseList список коллекторов через запятую
+func ParseList(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// LoadConfig читает настройки коллекторов из JSON файла {"<имя>": Config}
+func LoadConfig(path string) (map[string]Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("collector config: %w", err)
+	}
+
+	var result map[string]Config
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("collector config %s: %w", path, err)
+	}
+	return result, nil
+}
+
+// Build создает включенные коллекторы: из списка enabled и с "enabled": true в configs,
+// кроме выключенных в configs. Порядок - как в enabled, затем по алфавиту
+func Build(enabled []string, configs map[string]Config, defaultInterval time.Duration) ([]Collector, error) {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range enabled {
+		add(name)
+	}
+	extra := make([]string, 0, len(configs))
+	for name, cfg := range configs {
+		if cfg.Enabled != nil && *cfg.Enabled {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		add(name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for name := range configs {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("collector config: unknown collector %q", name)
+		}
+	}
+
+	var result []Collector
+	for _, name := range names {
+		cfg := configs[name]
+		if cfg.Enabled != nil && !*cfg.Enabled {
+			continue
+		}
+
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+
+		interval := time.Duration(cfg.Interval)
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+
+		c, err := factory(name, interval, cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		result = append(result, c)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no collectors enabled")
+	}
+	return result, nil
+}
+
+// base имя и интервал, встраивается в реализации
+type base struct {
+	name     string
+	interval time.Duration
+}
+
+func (b base) Name() string {
+	return b.name
+}
+
+func (b base) Interval() time.Duration {
+	return b.interval
+}
+
+// decodeOptions разбирает Options в v, пустые настройки допустимы
+func decodeOptions(options json.RawMessage, v any) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(options)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+	return nil
+}
+
+func gauge(id string, v float64) models.Metric {
+	return models.Metric{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) models.Metric {
+	return models.Metric{ID: id, MType: "counter", Delta: &d}
+}
+
+// labelled имя метрики с метками через ":", например DiskUsed:var_lib.
+// Недопустимые для сервера символы меток заменяются на "_", слишком длинное имя
+// укорачивается, а в конец добавляется хеш полного имени, чтобы имена не совпали
+func labelled(name string, labels ...string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, label := range labels {
+		sb.WriteByte(':')
+		sb.WriteString(sanitizeLabel(label))
+	}
+
+	result := sb.String()
+	if len(result) <= metricname.MaxLen {
+		return result
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(result))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+	return result[:metricname.MaxLen-len(suffix)] + suffix
+}
+
+// sanitizeName имя метрики из внешнего источника: недопустимые символы и ":" (разделитель меток)
+// заменяются на "_", имя с цифры начинается с "_"
+func sanitizeName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || !(b[0] >= 'a' && b[0] <= 'z' || b[0] >= 'A' && b[0] <= 'Z' || b[0] == '_') {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+func sanitizeLabel(label string) string {
+	b := []byte(label)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		default:
+			b[i] = '_'
+		}
+	}
+
+	result := strings.Trim(string(b), "_")
+	if result == "" {
+		return "_"
+	}
+	return result
+}
+
+// deltas прошлые значения накопительных счетчиков: из них между опросами считаются
+// скорости (в секунду) и приращения. Первый опрос только запоминает значения,
+// уменьшившийся счетчик (перезапуск, переполнение) пропускается один раз
+type deltas struct {
+	now      func() time.Time
+	prev     map[string]uint64
+	cur      map[string]uint64
+	prevTime time.Time
+	curTime  time.Time
+	elapsed  float64
+}
+
+func newDeltas(now func() time.Time) *deltas {
+	return &deltas{now: now, prev: make(map[string]uint64)}
+}
+
+// begin начинает опрос
+func (d *deltas) begin() {
+	d.curTime = d.now()
+	d.elapsed = 0
+	if !d.prevTime.IsZero() {
+		d.elapsed = d.curTime.Sub(d.prevTime).Seconds()
+	}
+	d.cur = make(map[string]uint64, len(d.prev))
+}
+
+// end завершает успешный опрос, счетчики, не встреченные в нем, забываются.
+// Без end (опрос не удался) следующий опрос считается от последнего успешного
+func (d *deltas) end() {
+	d.prev = d.cur
+	d.prevTime = d.curTime
+	d.cur = nil
+}
+
+// delta приращение счетчика key с прошлого опроса
+func (d *deltas) delta(key string, value uint64) (uint64, bool) {
+	d.cur[key] = value
+	prev, ok := d.prev[key]
+	if !ok || value < prev {
+		return 0, false
+	}
+	return value - prev, true
+}
+
+// rate скорость счетчика key в секунду с прошлого опроса
+func (d *deltas) rate(key string, value uint64) (float64, bool) {
+	delta, ok := d.delta(key, value)
+	if !ok || d.elapsed <= 0 {
+		return 0, false
+	}
+	return float64(delta) / d.elapsed, true
+}
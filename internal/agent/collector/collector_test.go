@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"encoding/json"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func names(list []Collector) []string {
+	result := make([]string, 0, len(list))
+	for _, c := range list {
+		result = append(result, c.Name())
+	}
+	return result
+}
+
+func find(list []models.Metric, id string) (models.Metric, bool) {
+	for _, m := range list {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return models.Metric{}, false
+}
+
+func TestBuild(t *testing.T) {
+	yes, no := true, false
+
+	list, err := Build(ParseList(" runtime, gopsutil ,"), nil, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"runtime", "gopsutil"}, names(list))
+	assert.Equal(t, 2*time.Second, list[0].Interval())
+
+	// выключен в настройках, хотя есть в списке; включен настройками без списка
+	list, err = Build([]string{"gopsutil"}, map[string]Config{
+		"gopsutil": {Enabled: &no},
+		"runtime":  {Enabled: &yes, Interval: Duration(time.Minute)},
+	}, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"runtime"}, names(list))
+	assert.Equal(t, time.Minute, list[0].Interval())
+
+	_, err = Build([]string{"missing"}, nil, time.Second)
+	assert.ErrorContains(t, err, `unknown collector "missing"`)
+
+	_, err = Build([]string{"runtime"}, map[string]Config{"missing": {}}, time.Second)
+	assert.ErrorContains(t, err, `unknown collector "missing"`)
+
+	_, err = Build([]string{"runtime"}, map[string]Config{"runtime": {Options: json.RawMessage(`{"x": 1}`)}}, time.Second)
+	assert.ErrorContains(t, err, "collector runtime: options")
+
+	_, err = Build(nil, map[string]Config{"runtime": {Enabled: &no}}, time.Second)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collectors.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"runtime": {"interval": "5s"},
+		"gopsutil": {"enabled": false, "options": {"cpu_interval": "50ms"}}
+	}`), 0o644))
+
+	configs, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, Duration(5*time.Second), configs["runtime"].Interval)
+	assert.Nil(t, configs["runtime"].Enabled)
+	require.NotNil(t, configs["gopsutil"].Enabled)
+	assert.False(t, *configs["gopsutil"].Enabled)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"runtime": {"interval": 5}}`), 0o644))
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
+
+// clock время для deltas, сдвигается тестом
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func TestLabelled(t *testing.T) {
+	assert.Equal(t, "DiskUsed:root", labelled("DiskUsed", "root"))
+	assert.Equal(t, "DiskUsed:var_lib_docker", labelled("DiskUsed", "/var/lib/docker/"))
+	assert.Equal(t, "Proc:nginx:1_2", labelled("Proc", "nginx", "1:2"))
+	assert.Equal(t, "X:_", labelled("X", "///"))
+
+	long := labelled("DiskUsedPercent", "/var/lib/kubelet/pods/very/long/path")
+	assert.Len(t, long, metricname.MaxLen)
+	assert.NotEqual(t, long, labelled("DiskUsedPercent", "/var/lib/kubelet/pods/very/long/path2"))
+}
+
+func TestDeltas(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	d := newDeltas(clk.now)
+
+	d.begin()
+	_, ok := d.rate("a", 100)
+	assert.False(t, ok, "first poll only remembers values")
+	d.end()
+
+	clk.t = clk.t.Add(2 * time.Second)
+	d.begin()
+	v, ok := d.rate("a", 300)
+	assert.True(t, ok)
+	assert.Equal(t, 100.0, v)
+	d.end()
+
+	// неудачный опрос без end не сдвигает базу
+	clk.t = clk.t.Add(2 * time.Second)
+	d.begin()
+	clk.t = clk.t.Add(2 * time.Second)
+	d.begin()
+	v, ok = d.rate("a", 500)
+	assert.True(t, ok)
+	assert.Equal(t, 50.0, v)
+	d.end()
+
+	clk.t = clk.t.Add(time.Second)
+	d.begin()
+	_, ok = d.rate("a", 10)
+	assert.False(t, ok, "counter reset")
+	delta, ok := d.delta("b", 5)
+	assert.False(t, ok)
+	assert.Zero(t, delta)
+	d.end()
+}
+
+// copyFixture копия каталога testdata, чтобы тест мог менять счетчики между опросами
+func copyFixture(t *testing.T, src string) string {
+	dst := t.TempDir()
+	err := filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0o644)
+	})
+	require.NoError(t, err)
+	return dst
+}
+
+func gaugeValues(list []models.Metric) map[string]float64 {
+	result := make(map[string]float64)
+	for _, m := range list {
+		if m.MType == "gauge" {
+			result[m.ID] = *m.Value
+		}
+	}
+	return result
+}
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/shirou/gopsutil/v3/disk"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("disk", NewDisk)
+}
+
+// DiskOptions настройки коллектора "disk"
+type DiskOptions struct {
+	// Mounts точки монтирования, пусто - все физические разделы
+	Mounts []string `json:"mounts"`
+	// Devices устройства для IO (sda, nvme0n1), пусто - все, кроме loop и ram
+	Devices []string `json:"devices"`
+	// AllPartitions учитывать и виртуальные файловые системы, если Mounts пуст
+	AllPartitions bool `json:"all_partitions"`
+}
+
+// DiskCollector место на разделах: DiskTotal, DiskUsed, DiskFree, DiskUsedPercent с меткой точки монтирования
+// ("/" - root), и IO устройств с меткой устройства: DiskReadBytesRate, DiskWriteBytesRate (байт в секунду),
+// DiskReadOpsRate, DiskWriteOpsRate (операций в секунду) и DiskBusyPercent
+type DiskCollector struct {
+	base
+	opts       DiskOptions
+	partitions func(ctx context.Context, all bool) ([]disk.PartitionStat, error)
+	usage      func(ctx context.Context, path string) (*disk.UsageStat, error)
+	ioCounters func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+	deltas     *deltas
+}
+
+func NewDisk(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts DiskOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	return &DiskCollector{
+		base:       base{name: name, interval: interval},
+		opts:       opts,
+		partitions: disk.PartitionsWithContext,
+		usage:      disk.UsageWithContext,
+		ioCounters: disk.IOCountersWithContext,
+		deltas:     newDeltas(time.Now),
+	}, nil
+}
+
+func mountLabel(mount string) string {
+	if mount == "/" {
+		return "root"
+	}
+	return mount
+}
+
+func (c *DiskCollector) mounts(ctx context.Context) ([]string, error) {
+	if len(c.opts.Mounts) > 0 {
+		return c.opts.Mounts, nil
+	}
+
+	partitions, err := c.partitions(ctx, c.opts.AllPartitions)
+	if err != nil {
+		return nil, fmt.Errorf("disk.Partitions: %w", err)
+	}
+
+	seen := make(map[string]bool, len(partitions))
+	result := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		if !seen[p.Mountpoint] {
+			seen[p.Mountpoint] = true
+			result = append(result, p.Mountpoint)
+		}
+	}
+	return result, nil
+}
+
+func (c *DiskCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	mounts, err := c.mounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []models.Metric
+	for _, mount := range mounts {
+		usage, err := c.usage(ctx, mount)
+		if err != nil {
+			// раздел мог быть отмонтирован между опросами
+			continue
+		}
+		label := mountLabel(mount)
+		result = append(result,
+			gauge(labelled("DiskTotal", label), float64(usage.Total)),
+			gauge(labelled("DiskUsed", label), float64(usage.Used)),
+			gauge(labelled("DiskFree", label), float64(usage.Free)),
+			gauge(labelled("DiskUsedPercent", label), usage.UsedPercent),
+		)
+	}
+
+	counters, err := c.ioCounters(ctx, c.opts.Devices...)
+	if err != nil {
+		// занятость разделов уже собрана и отправляется без скоростей устройств
+		return result, fmt.Errorf("disk.IOCounters: %w", err)
+	}
+
+	devices := make([]string, 0, len(counters))
+	for name := range counters {
+		if len(c.opts.Devices) == 0 && (strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram")) {
+			continue
+		}
+		devices = append(devices, name)
+	}
+	sort.Strings(devices)
+
+	c.deltas.begin()
+	for _, name := range devices {
+		io := counters[name]
+		if v, ok := c.deltas.rate(name+":rb", io.ReadBytes); ok {
+			result = append(result, gauge(labelled("DiskReadBytesRate", name), v))
+		}
+		if v, ok := c.deltas.rate(name+":wb", io.WriteBytes); ok {
+			result = append(result, gauge(labelled("DiskWriteBytesRate", name), v))
+		}
+		if v, ok := c.deltas.rate(name+":rc", io.ReadCount); ok {
+			result = append(result, gauge(labelled("DiskReadOpsRate", name), v))
+		}
+		if v, ok := c.deltas.rate(name+":wc", io.WriteCount); ok {
+			result = append(result, gauge(labelled("DiskWriteOpsRate", name), v))
+		}
+		// IoTime в миллисекундах: доля времени, когда устройство было занято
+		if v, ok := c.deltas.rate(name+":io", io.IoTime); ok {
+			result = append(result, gauge(labelled("DiskBusyPercent", name), v/10))
+		}
+	}
+	c.deltas.end()
+
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDiskCollector(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	var readBytes uint64 = 1000
+
+	c, err := NewDisk("disk", time.Second, nil)
+	require.NoError(t, err)
+	dc := c.(*DiskCollector)
+	dc.deltas = newDeltas(clk.now)
+	dc.partitions = func(ctx context.Context, all bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{{Mountpoint: "/"}, {Mountpoint: "/data"}, {Mountpoint: "/data"}}, nil
+	}
+	dc.usage = func(ctx context.Context, path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Path: path, Total: 100, Used: 40, Free: 60, UsedPercent: 40}, nil
+	}
+	dc.ioCounters = func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+		return map[string]disk.IOCountersStat{
+			"sda":   {Name: "sda", ReadBytes: readBytes, IoTime: readBytes},
+			"loop0": {Name: "loop0", ReadBytes: readBytes},
+		}, nil
+	}
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, list, 8)
+	used, ok := find(list, "DiskUsed:data")
+	require.True(t, ok)
+	assert.Equal(t, 40.0, *used.Value)
+	_, ok = find(list, "DiskReadBytesRate:sda")
+	assert.False(t, ok)
+
+	clk.t = clk.t.Add(10 * time.Second)
+	readBytes += 5000
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	rate, ok := find(list, "DiskReadBytesRate:sda")
+	require.True(t, ok)
+	assert.Equal(t, 500.0, *rate.Value)
+	busy, ok := find(list, "DiskBusyPercent:sda")
+	require.True(t, ok)
+	assert.Equal(t, 50.0, *busy.Value)
+	_, ok = find(list, "DiskReadBytesRate:loop0")
+	assert.False(t, ok)
+
+	// без счетчиков устройств занятость разделов не теряется
+	dc.ioCounters = func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+		return nil, errors.New("no diskstats")
+	}
+	list, err = c.Collect(context.Background())
+	assert.ErrorContains(t, err, "no diskstats")
+	assert.Len(t, list, 8)
+}
@@ -0,0 +1,279 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("exec", NewExec)
+}
+
+// Форматы вывода команд коллектора "exec"
+const (
+	// ExecFormatSimple строки "имя тип значение", тип gauge или counter (приращение)
+	ExecFormatSimple = "simple"
+	// ExecFormatPrometheus текстовый формат Prometheus
+	ExecFormatPrometheus = "prometheus"
+)
+
+const (
+	defaultExecTimeout    = 10 * time.Second
+	defaultExecMaxOutput  = 64 << 10
+	defaultExecMaxMetrics = 1000
+	// execMaxStderr сколько stderr попадает в ошибку
+	execMaxStderr = 1 << 10
+	// execWaitDelay сколько ждать закрытия вывода после завершения команды:
+	// потомки, унаследовавшие stdout, не должны держать опрос
+	execWaitDelay = time.Second
+)
+
+// ExecCommand команда коллектора "exec"
+type ExecCommand struct {
+	// Name имя команды в ошибках, уникальное
+	Name string `json:"name"`
+	// Command программа и аргументы, запускается без shell
+	Command []string `json:"command"`
+	// Format simple (по умолчанию) или prometheus
+	Format string `json:"format"`
+	// Timeout 0 - 10s, но не больше интервала опроса
+	Timeout Duration `json:"timeout"`
+	// Prefix добавляется к именам метрик
+	Prefix string `json:"prefix"`
+}
+
+// ExecOptions настройки коллектора "exec"
+type ExecOptions struct {
+	Commands []ExecCommand `json:"commands"`
+	// MaxOutput предел stdout в байтах, команда с большим выводом прерывается, 0 - 64KiB
+	MaxOutput int `json:"max_output"`
+	// MaxMetrics предел метрик от одной команды за запуск, лишние отбрасываются, 0 - 1000
+	MaxMetrics int `json:"max_metrics"`
+}
+
+// execRunner команда со своим состоянием: для формата prometheus counter переводятся в приращения
+type execRunner struct {
+	ExecCommand
+	timeout   time.Duration
+	converter *promConverter
+}
+
+// ExecCollector метрики из вывода внешних команд. Команды запускаются параллельно раз в интервал,
+// зависшая команда вместе с дочерними процессами убивается по таймауту, слишком большой вывод
+// прерывает команду. Ошибка одной команды не мешает остальным
+type ExecCollector struct {
+	base
+	runners    []*execRunner
+	maxOutput  int
+	maxMetrics int
+}
+
+func NewExec(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts ExecOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Commands) == 0 {
+		return nil, errors.New("no commands configured")
+	}
+
+	c := &ExecCollector{
+		base:       base{name: name, interval: interval},
+		maxOutput:  opts.MaxOutput,
+		maxMetrics: opts.MaxMetrics,
+	}
+	if c.maxOutput <= 0 {
+		c.maxOutput = defaultExecMaxOutput
+	}
+	if c.maxMetrics <= 0 {
+		c.maxMetrics = defaultExecMaxMetrics
+	}
+
+	seen := make(map[string]bool)
+	for i, cmd := range opts.Commands {
+		if cmd.Name == "" {
+			return nil, fmt.Errorf("command %d: empty name", i)
+		}
+		if seen[cmd.Name] {
+			return nil, fmt.Errorf("command %d: duplicate name %q", i, cmd.Name)
+		}
+		seen[cmd.Name] = true
+		if len(cmd.Command) == 0 {
+			return nil, fmt.Errorf("command %s: empty command", cmd.Name)
+		}
+
+		switch cmd.Format {
+		case "":
+			cmd.Format = ExecFormatSimple
+		case ExecFormatSimple, ExecFormatPrometheus:
+		default:
+			return nil, fmt.Errorf("command %s: unknown format %q", cmd.Name, cmd.Format)
+		}
+
+		timeout := time.Duration(cmd.Timeout)
+		if timeout <= 0 {
+			timeout = min(defaultExecTimeout, interval)
+		}
+
+		c.runners = append(c.runners, &execRunner{
+			ExecCommand: cmd,
+			timeout:     timeout,
+			converter:   newPromConverter(cmd.Prefix),
+		})
+	}
+
+	return c, nil
+}
+
+func (c *ExecCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	results := make([][]models.Metric, len(c.runners))
+	errs := make([]error, len(c.runners))
+
+	var wg sync.WaitGroup
+	for i, r := range c.runners {
+		wg.Add(1)
+		go func(i int, r *execRunner) {
+			defer wg.Done()
+			results[i], errs[i] = c.run(ctx, r)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("command %s: %w", r.Name, errs[i])
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	var result []models.Metric
+	for _, list := range results {
+		result = append(result, list...)
+	}
+	return result, errors.Join(errs...)
+}
+
+// run запуск одной команды и разбор ее вывода
+func (c *ExecCollector) run(ctx context.Context, r *execRunner) ([]models.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, r.Command[0], r.Command[1:]...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = execWaitDelay
+
+	stdout := &limitedBuffer{limit: c.maxOutput, onOverflow: cancel}
+	stderr := &limitedBuffer{limit: execMaxStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err := cmd.Run()
+	switch {
+	case stdout.overflow:
+		return nil, fmt.Errorf("output exceeds %d bytes", c.maxOutput)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("timed out after %s", r.timeout)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	if r.Format == ExecFormatPrometheus {
+		text, err := parsePromText(&stdout.buf, c.maxMetrics)
+		return r.converter.convert(text), err
+	}
+	return parseSimple(stdout.String(), r.Prefix, c.maxMetrics)
+}
+
+// parseSimple строки "имя тип значение", пустые и начинающиеся с # пропускаются
+func parseSimple(output, prefix string, maxMetrics int) ([]models.Metric, error) {
+	var (
+		result []models.Metric
+		errs   []error
+	)
+	for i, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(result) >= maxMetrics {
+			errs = append(errs, fmt.Errorf("more than %d metrics, rest dropped", maxMetrics))
+			break
+		}
+		m, err := parseSimpleLine(fields, prefix)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		result = append(result, m)
+	}
+	return result, errors.Join(errs...)
+}
+
+func parseSimpleLine(fields []string, prefix string) (models.Metric, error) {
+	if len(fields) != 3 {
+		return models.Metric{}, errors.New("expected \"name type value\"")
+	}
+	name := prefix + fields[0]
+	if err := metricname.Validate(name); err != nil {
+		return models.Metric{}, err
+	}
+
+	switch fields[1] {
+	case "gauge":
+		v, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return models.Metric{}, fmt.Errorf("invalid gauge value %q", fields[2])
+		}
+		return gauge(name, v), nil
+	case "counter":
+		d, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return models.Metric{}, fmt.Errorf("invalid counter value %q", fields[2])
+		}
+		return counter(name, d), nil
+	default:
+		return models.Metric{}, fmt.Errorf("unknown type %q", fields[1])
+	}
+}
+
+// limitedBuffer буфер вывода команды с пределом. При переполнении вызывает onOverflow
+// и возвращает ошибку записи, после чего команда получает SIGPIPE.
+// bytes.Buffer не встраивается: его ReadFrom обошел бы предел в io.Copy
+type limitedBuffer struct {
+	buf        bytes.Buffer
+	limit      int
+	overflow   bool
+	onOverflow func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow {
+		return 0, errOutputLimit
+	}
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		if b.onOverflow == nil {
+			// предел только обрезает вывод, команда продолжает работать
+			return len(p), nil
+		}
+		b.overflow = true
+		b.onOverflow()
+		return 0, errOutputLimit
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+var errOutputLimit = errors.New("output limit exceeded")
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package collector
+
+import "os/exec"
+
+// setProcessGroup без групп процессов по таймауту убивается только сама команда
+func setProcessGroup(cmd *exec.Cmd) {}
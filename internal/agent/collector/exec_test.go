@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestExec(t *testing.T, options string) *ExecCollector {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	c, err := NewExec("exec", time.Second, json.RawMessage(options))
+	require.NoError(t, err)
+	return c.(*ExecCollector)
+}
+
+func TestExecCollector(t *testing.T) {
+	dir := t.TempDir()
+	counterFile := filepath.Join(dir, "jobs")
+	require.NoError(t, os.WriteFile(counterFile, []byte("# TYPE jobs_total counter\njobs_total 10\n"), 0o644))
+
+	c := newTestExec(t, `{"commands": [
+		{"name": "queue", "command": ["sh", "-c", "echo '# spool'; echo 'QueueFiles gauge 3'; echo 'QueueErrors counter 2'; echo 'bad line'"]},
+		{"name": "jobs", "command": ["cat", "`+counterFile+`"], "format": "prometheus", "prefix": "app_"}
+	]}`)
+
+	list, err := c.Collect(context.Background())
+	assert.ErrorContains(t, err, "command queue: line 4")
+	files, ok := find(list, "QueueFiles")
+	require.True(t, ok)
+	assert.Equal(t, 3.0, *files.Value)
+	errs, ok := find(list, "QueueErrors")
+	require.True(t, ok)
+	assert.Equal(t, int64(2), *errs.Delta)
+	_, ok = find(list, "app_jobs_total")
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(counterFile, []byte("# TYPE jobs_total counter\njobs_total 14\n"), 0o644))
+	list, _ = c.Collect(context.Background())
+	jobs, ok := find(list, "app_jobs_total")
+	require.True(t, ok)
+	assert.Equal(t, int64(4), *jobs.Delta)
+}
+
+func TestExecGuards(t *testing.T) {
+	// зависшая команда и ее потомок, держащий stdout, убиваются по таймауту
+	c := newTestExec(t, `{"commands": [
+		{"name": "hung", "command": ["sh", "-c", "sleep 30 & sleep 30"], "timeout": "200ms"},
+		{"name": "fine", "command": ["echo", "Up gauge 1"]}
+	]}`)
+	start := time.Now()
+	list, err := c.Collect(context.Background())
+	assert.Less(t, time.Since(start), 900*time.Millisecond)
+	assert.ErrorContains(t, err, "command hung: timed out")
+	assert.Len(t, list, 1)
+
+	c = newTestExec(t, `{"commands": [{"name": "noisy", "command": ["yes", "Noise gauge 1"]}], "max_output": 1024}`)
+	start = time.Now()
+	list, err = c.Collect(context.Background())
+	assert.Less(t, time.Since(start), 900*time.Millisecond)
+	assert.ErrorContains(t, err, "output exceeds 1024 bytes")
+	assert.Empty(t, list)
+
+	c = newTestExec(t, `{"commands": [{"name": "many", "command": ["sh", "-c", "for i in 1 2 3 4 5; do echo M$i gauge $i; done"]}], "max_metrics": 2}`)
+	list, err = c.Collect(context.Background())
+	assert.ErrorContains(t, err, "more than 2 metrics")
+	assert.Len(t, list, 2)
+
+	c = newTestExec(t, `{"commands": [{"name": "fail", "command": ["sh", "-c", "echo boom >&2; exit 3"]}]}`)
+	_, err = c.Collect(context.Background())
+	assert.ErrorContains(t, err, "exit status 3: boom")
+}
+
+func TestExecOptions(t *testing.T) {
+	for name, options := range map[string]string{
+		"empty":     `{}`,
+		"no name":   `{"commands": [{"command": ["true"]}]}`,
+		"duplicate": `{"commands": [{"name": "a", "command": ["true"]}, {"name": "a", "command": ["true"]}]}`,
+		"command":   `{"commands": [{"name": "a", "command": []}]}`,
+		"format":    `{"commands": [{"name": "a", "command": ["true"], "format": "json"}]}`,
+	} {
+		_, err := NewExec("exec", time.Second, json.RawMessage(options))
+		assert.Error(t, err, name)
+	}
+
+	_, err := parseSimple("1bad gauge 1\nok gauge x\nok counter 1.5\nok histogram 1\nok gauge 1 2\n", "", 100)
+	for _, line := range []string{"line 1", "line 2", "line 3", "line 4", "line 5"} {
+		assert.ErrorContains(t, err, line)
+	}
+}
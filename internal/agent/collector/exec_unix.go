@@ -0,0 +1,17 @@
+//go:build unix
+
+package collector
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup запускает команду в своей группе процессов, чтобы по таймауту
+// убить и ее потомков: sh -c "sleep 100" иначе оставит sleep работать
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
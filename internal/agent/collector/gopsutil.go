@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+	"strconv"
+	"time"
+)
+
+func init() {
+	Register("gopsutil", NewGopsutil)
+}
+
+// GopsutilOptions настройки коллектора "gopsutil"
+type GopsutilOptions struct {
+	// CPUInterval за какое время измерять загрузку процессоров, по умолчанию 10ms
+	CPUInterval Duration `json:"cpu_interval"`
+}
+
+type GopsutilMetrics struct {
+	base
+	cpuInterval    time.Duration
+	TotalMemory    float64
+	FreeMemory     float64
+	CPUutilization []float64
+}
+
+func NewGopsutil(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	opts := GopsutilOptions{CPUInterval: Duration(10 * time.Millisecond)}
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	return &GopsutilMetrics{
+		base:        base{name: name, interval: interval},
+		cpuInterval: time.Duration(opts.CPUInterval),
+	}, nil
+}
+
+func (el *GopsutilMetrics) UpdateMetrics(ctx context.Context) error {
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("mem.VirtualMemory: %w", err)
+	}
+
+	el.TotalMemory = float64(v.Total)
+	el.FreeMemory = float64(v.Free)
+	el.CPUutilization, err = cpu.PercentWithContext(ctx, el.cpuInterval, true)
+	if err != nil {
+		return fmt.Errorf("cpu.Percent: %w", err)
+	}
+
+	return nil
+}
+
+// Collect TotalMemory, FreeMemory и CPUutilization<N> по каждому ядру
+func (el *GopsutilMetrics) Collect(ctx context.Context) ([]models.Metric, error) {
+	if err := el.UpdateMetrics(ctx); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.Metric, 0, len(el.CPUutilization)+2)
+	result = append(result, gauge("TotalMemory", el.TotalMemory), gauge("FreeMemory", el.FreeMemory))
+	for k, v := range el.CPUutilization {
+		result = append(result, gauge("CPUutilization"+strconv.Itoa(k), v))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,24 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestGopsutilCollector(t *testing.T) {
+	c, err := NewGopsutil("gopsutil", time.Second, json.RawMessage(`{"cpu_interval": "1ms"}`))
+	require.NoError(t, err)
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	total, ok := find(list, "TotalMemory")
+	require.True(t, ok)
+	assert.Greater(t, *total.Value, 0.0)
+	_, ok = find(list, "CPUutilization0")
+	assert.True(t, ok)
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+	"time"
+)
+
+func init() {
+	Register("load", NewLoad)
+	Register("swap", NewSwap)
+	Register("procs", NewProcs)
+}
+
+// LoadCollector средняя загрузка за 1, 5 и 15 минут: Load1, Load5, Load15
+type LoadCollector struct {
+	base
+	avg func(ctx context.Context) (*load.AvgStat, error)
+}
+
+func NewLoad(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	if err := decodeOptions(options, &struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return &LoadCollector{base: base{name: name, interval: interval}, avg: load.AvgWithContext}, nil
+}
+
+func (c *LoadCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	avg, err := c.avg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load.Avg: %w", err)
+	}
+
+	return []models.Metric{gauge("Load1", avg.Load1), gauge("Load5", avg.Load5), gauge("Load15", avg.Load15)}, nil
+}
+
+// SwapCollector использование swap: SwapTotal, SwapUsed, SwapFree, SwapUsedPercent
+// и скорости SwapInBytesRate, SwapOutBytesRate в байтах в секунду
+type SwapCollector struct {
+	base
+	swap   func(ctx context.Context) (*mem.SwapMemoryStat, error)
+	deltas *deltas
+}
+
+func NewSwap(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	if err := decodeOptions(options, &struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return &SwapCollector{
+		base:   base{name: name, interval: interval},
+		swap:   mem.SwapMemoryWithContext,
+		deltas: newDeltas(time.Now),
+	}, nil
+}
+
+func (c *SwapCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	swap, err := c.swap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("mem.SwapMemory: %w", err)
+	}
+
+	result := []models.Metric{
+		gauge("SwapTotal", float64(swap.Total)),
+		gauge("SwapUsed", float64(swap.Used)),
+		gauge("SwapFree", float64(swap.Free)),
+		gauge("SwapUsedPercent", swap.UsedPercent),
+	}
+
+	c.deltas.begin()
+	if v, ok := c.deltas.rate("sin", swap.Sin); ok {
+		result = append(result, gauge("SwapInBytesRate", v))
+	}
+	if v, ok := c.deltas.rate("sout", swap.Sout); ok {
+		result = append(result, gauge("SwapOutBytesRate", v))
+	}
+	c.deltas.end()
+
+	return result, nil
+}
+
+// ProcsCollector процессы и потоки системы: Processes, Threads (на Linux - все задачи планировщика),
+// ProcsRunning, ProcsBlocked и скорости ProcsCreatedRate, ContextSwitchesRate в секунду
+type ProcsCollector struct {
+	base
+	pids   func(ctx context.Context) ([]int32, error)
+	misc   func(ctx context.Context) (*load.MiscStat, error)
+	deltas *deltas
+}
+
+func NewProcs(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	if err := decodeOptions(options, &struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return &ProcsCollector{
+		base:   base{name: name, interval: interval},
+		pids:   process.PidsWithContext,
+		misc:   load.MiscWithContext,
+		deltas: newDeltas(time.Now),
+	}, nil
+}
+
+func (c *ProcsCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	pids, err := c.pids(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("process.Pids: %w", err)
+	}
+	misc, err := c.misc(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load.Misc: %w", err)
+	}
+
+	result := []models.Metric{
+		gauge("Processes", float64(len(pids))),
+		gauge("Threads", float64(misc.ProcsTotal)),
+		gauge("ProcsRunning", float64(misc.ProcsRunning)),
+		gauge("ProcsBlocked", float64(misc.ProcsBlocked)),
+	}
+
+	c.deltas.begin()
+	if v, ok := c.deltas.rate("created", uint64(misc.ProcsCreated)); ok {
+		result = append(result, gauge("ProcsCreatedRate", v))
+	}
+	if v, ok := c.deltas.rate("ctxt", uint64(misc.Ctxt)); ok {
+		result = append(result, gauge("ContextSwitchesRate", v))
+	}
+	c.deltas.end()
+
+	return result, nil
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestHostCollectors(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	ctx := context.Background()
+
+	c, err := NewLoad("load", time.Second, nil)
+	require.NoError(t, err)
+	c.(*LoadCollector).avg = func(ctx context.Context) (*load.AvgStat, error) {
+		return &load.AvgStat{Load1: 1, Load5: 0.5, Load15: 0.25}, nil
+	}
+	list, err := c.Collect(ctx)
+	require.NoError(t, err)
+	load15, ok := find(list, "Load15")
+	require.True(t, ok)
+	assert.Equal(t, 0.25, *load15.Value)
+
+	swap := &mem.SwapMemoryStat{Total: 100, Used: 25, Free: 75, UsedPercent: 25, Sin: 0, Sout: 0}
+	c, err = NewSwap("swap", time.Second, nil)
+	require.NoError(t, err)
+	sc := c.(*SwapCollector)
+	sc.deltas = newDeltas(clk.now)
+	sc.swap = func(ctx context.Context) (*mem.SwapMemoryStat, error) { return swap, nil }
+	list, err = c.Collect(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 4)
+	clk.t = clk.t.Add(4 * time.Second)
+	swap.Sout = 4096
+	list, err = c.Collect(ctx)
+	require.NoError(t, err)
+	out, ok := find(list, "SwapOutBytesRate")
+	require.True(t, ok)
+	assert.Equal(t, 1024.0, *out.Value)
+
+	misc := &load.MiscStat{ProcsTotal: 120, ProcsRunning: 2, ProcsCreated: 1000, Ctxt: 5000}
+	c, err = NewProcs("procs", time.Second, nil)
+	require.NoError(t, err)
+	pc := c.(*ProcsCollector)
+	pc.deltas = newDeltas(clk.now)
+	pc.pids = func(ctx context.Context) ([]int32, error) { return []int32{1, 2, 3}, nil }
+	pc.misc = func(ctx context.Context) (*load.MiscStat, error) { return misc, nil }
+	_, err = c.Collect(ctx)
+	require.NoError(t, err)
+	clk.t = clk.t.Add(time.Second)
+	misc.ProcsCreated += 7
+	misc.Ctxt += 300
+	list, err = c.Collect(ctx)
+	require.NoError(t, err)
+
+	processes, _ := find(list, "Processes")
+	threads, _ := find(list, "Threads")
+	created, _ := find(list, "ProcsCreatedRate")
+	ctxt, _ := find(list, "ContextSwitchesRate")
+	assert.Equal(t, 3.0, *processes.Value)
+	assert.Equal(t, 120.0, *threads.Value)
+	assert.Equal(t, 7.0, *created.Value)
+	assert.Equal(t, 300.0, *ctxt.Value)
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/shirou/gopsutil/v3/net"
+	"time"
+)
+
+func init() {
+	Register("net", NewNet)
+}
+
+// NetOptions настройки коллектора "net"
+type NetOptions struct {
+	// Interfaces интерфейсы, пусто - все, кроме lo
+	Interfaces []string `json:"interfaces"`
+	// Loopback учитывать lo, если Interfaces пуст
+	Loopback bool `json:"loopback"`
+}
+
+// NetCollector трафик интерфейсов с меткой интерфейса: NetRecvBytesRate, NetSentBytesRate (байт в секунду),
+// NetRecvPacketsRate, NetSentPacketsRate (пакетов в секунду) и counter NetErrIn, NetErrOut, NetDropIn, NetDropOut
+// - ошибки и отброшенные пакеты с прошлого опроса
+type NetCollector struct {
+	base
+	opts       NetOptions
+	ioCounters func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error)
+	deltas     *deltas
+}
+
+func NewNet(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts NetOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	return &NetCollector{
+		base:       base{name: name, interval: interval},
+		opts:       opts,
+		ioCounters: net.IOCountersWithContext,
+		deltas:     newDeltas(time.Now),
+	}, nil
+}
+
+func (c *NetCollector) selected(name string) bool {
+	if len(c.opts.Interfaces) == 0 {
+		return name != "lo" || c.opts.Loopback
+	}
+	for _, v := range c.opts.Interfaces {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *NetCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	counters, err := c.ioCounters(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("net.IOCounters: %w", err)
+	}
+
+	var result []models.Metric
+	rate := func(name, key string, value uint64, iface string) {
+		if v, ok := c.deltas.rate(iface+":"+key, value); ok {
+			result = append(result, gauge(labelled(name, iface), v))
+		}
+	}
+	delta := func(name, key string, value uint64, iface string) {
+		if v, ok := c.deltas.delta(iface+":"+key, value); ok {
+			result = append(result, counter(labelled(name, iface), int64(v)))
+		}
+	}
+
+	c.deltas.begin()
+	for _, io := range counters {
+		if !c.selected(io.Name) {
+			continue
+		}
+		rate("NetRecvBytesRate", "br", io.BytesRecv, io.Name)
+		rate("NetSentBytesRate", "bs", io.BytesSent, io.Name)
+		rate("NetRecvPacketsRate", "pr", io.PacketsRecv, io.Name)
+		rate("NetSentPacketsRate", "ps", io.PacketsSent, io.Name)
+		delta("NetErrIn", "ei", io.Errin, io.Name)
+		delta("NetErrOut", "eo", io.Errout, io.Name)
+		delta("NetDropIn", "di", io.Dropin, io.Name)
+		delta("NetDropOut", "do", io.Dropout, io.Name)
+	}
+	c.deltas.end()
+
+	return result, nil
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"context"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNetCollector(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	stats := []net.IOCountersStat{
+		{Name: "lo", BytesRecv: 10},
+		{Name: "eth0", BytesRecv: 1000, PacketsSent: 10, Errin: 1},
+	}
+
+	c, err := NewNet("net", time.Second, nil)
+	require.NoError(t, err)
+	nc := c.(*NetCollector)
+	nc.deltas = newDeltas(clk.now)
+	nc.ioCounters = func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error) {
+		return stats, nil
+	}
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	clk.t = clk.t.Add(2 * time.Second)
+	stats[0].BytesRecv = 1000
+	stats[1].BytesRecv = 3000
+	stats[1].PacketsSent = 30
+	stats[1].Errin = 4
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, list, 8)
+
+	recv, ok := find(list, "NetRecvBytesRate:eth0")
+	require.True(t, ok)
+	assert.Equal(t, 1000.0, *recv.Value)
+	sent, ok := find(list, "NetSentPacketsRate:eth0")
+	require.True(t, ok)
+	assert.Equal(t, 10.0, *sent.Value)
+	errIn, ok := find(list, "NetErrIn:eth0")
+	require.True(t, ok)
+	assert.Equal(t, "counter", errIn.MType)
+	assert.Equal(t, int64(3), *errIn.Delta)
+	_, ok = find(list, "NetRecvBytesRate:lo")
+	assert.False(t, ok)
+}
@@ -0,0 +1,307 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/shirou/gopsutil/v3/process"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("process", NewProcess)
+}
+
+// ProcessSelector какие процессы отслеживать. Задается ровно один из PidFile, Name, Cmdline
+type ProcessSelector struct {
+	// Label метка в именах метрик
+	Label string `json:"label"`
+	// PidFile файл с pid процесса, перечитывается каждый опрос
+	PidFile string `json:"pid_file,omitempty"`
+	// Name точное имя процесса
+	Name string `json:"name,omitempty"`
+	// Cmdline регулярное выражение для командной строки
+	Cmdline string `json:"cmdline,omitempty"`
+	// PerPid дополнительно отправлять метрики каждого процесса с pid в имени.
+	// Каждый перезапуск создает на сервере новые метрики, поэтому выключено по умолчанию
+	PerPid bool `json:"per_pid,omitempty"`
+
+	cmdline *regexp.Regexp
+}
+
+// ProcessOptions настройки коллектора "process"
+type ProcessOptions struct {
+	Processes []ProcessSelector `json:"processes"`
+}
+
+// procStat снимок процесса. Недоступные значения (нет прав) - -1
+type procStat struct {
+	createTime int64 // мс с начала эпохи, вместе с pid отличает перезапущенный процесс
+	cpuTime    float64
+	rss        int64
+	fds        int64
+	threads    int64
+	readBytes  int64
+	writeBytes int64
+}
+
+// procSource доступ к процессам системы, в тестах подменяется
+type procSource interface {
+	Pids(ctx context.Context) ([]int32, error)
+	Name(ctx context.Context, pid int32) (string, error)
+	Cmdline(ctx context.Context, pid int32) (string, error)
+	Stat(ctx context.Context, pid int32) (procStat, error)
+}
+
+// procMetrics метрики процесса в порядке отправки
+var procMetrics = []string{"ProcCPUPercent", "ProcRSS", "ProcFDs", "ProcThreads", "ProcReadBytesRate", "ProcWriteBytesRate"}
+
+// ProcessCollector метрики выбранных процессов с меткой селектора, суммы по всем его процессам:
+// ProcCPUPercent, ProcRSS, ProcFDs, ProcThreads, ProcReadBytesRate, ProcWriteBytesRate, а также
+// ProcCount - сколько процессов нашел селектор (0 - процесс не запущен) и counter ProcRestarts.
+// Перезапущенный процесс отслеживается под новым pid, скорости для него считаются со следующего опроса
+type ProcessCollector struct {
+	base
+	selectors []ProcessSelector
+	source    procSource
+	deltas    *deltas
+	seen      map[string]map[string]bool // метка селектора -> pid:createTime прошлого опроса
+}
+
+func NewProcess(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts ProcessOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Processes) == 0 {
+		return nil, errors.New("no processes configured")
+	}
+
+	seen := make(map[string]bool)
+	for i := range opts.Processes {
+		sel := &opts.Processes[i]
+		if sel.Label == "" {
+			return nil, fmt.Errorf("process %d: empty label", i)
+		}
+		if seen[sel.Label] {
+			return nil, fmt.Errorf("process %d: duplicate label %q", i, sel.Label)
+		}
+		seen[sel.Label] = true
+
+		set := 0
+		for _, v := range []string{sel.PidFile, sel.Name, sel.Cmdline} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("process %s: exactly one of pid_file, name, cmdline required", sel.Label)
+		}
+
+		if sel.Cmdline != "" {
+			re, err := regexp.Compile(sel.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("process %s: cmdline: %w", sel.Label, err)
+			}
+			sel.cmdline = re
+		}
+	}
+
+	return &ProcessCollector{
+		base:      base{name: name, interval: interval},
+		selectors: opts.Processes,
+		source:    gopsutilProcSource{},
+		deltas:    newDeltas(time.Now),
+		seen:      make(map[string]map[string]bool),
+	}, nil
+}
+
+// match pid процессов селектора
+func (c *ProcessCollector) match(ctx context.Context, sel ProcessSelector, pids []int32) []int32 {
+	if sel.PidFile != "" {
+		data, err := os.ReadFile(sel.PidFile)
+		if err != nil {
+			return nil
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+		if err != nil {
+			return nil
+		}
+		for _, v := range pids {
+			if v == int32(pid) {
+				return []int32{v}
+			}
+		}
+		return nil
+	}
+
+	var result []int32
+	for _, pid := range pids {
+		var ok bool
+		if sel.Name != "" {
+			name, err := c.source.Name(ctx, pid)
+			ok = err == nil && name == sel.Name
+		} else {
+			cmdline, err := c.source.Cmdline(ctx, pid)
+			ok = err == nil && sel.cmdline.MatchString(cmdline)
+		}
+		if ok {
+			result = append(result, pid)
+		}
+	}
+	return result
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	pids, err := c.source.Pids(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("process.Pids: %w", err)
+	}
+
+	var result []models.Metric
+	c.deltas.begin()
+	for _, sel := range c.selectors {
+		current := make(map[string]bool)
+		total := make(map[string]float64)
+		for _, pid := range c.match(ctx, sel, pids) {
+			stat, err := c.source.Stat(ctx, pid)
+			if err != nil {
+				// процесс завершился между поиском и опросом
+				continue
+			}
+			pidLabel := strconv.Itoa(int(pid))
+			id := pidLabel + ":" + strconv.FormatInt(stat.createTime, 10)
+			current[id] = true
+
+			values := c.values(id+":", stat)
+			for name, v := range values {
+				total[name] += v
+			}
+			if sel.PerPid {
+				for _, name := range procMetrics {
+					if v, ok := values[name]; ok {
+						result = append(result, gauge(labelled(name, sel.Label, pidLabel), v))
+					}
+				}
+			}
+		}
+		for _, name := range procMetrics {
+			if v, ok := total[name]; ok {
+				result = append(result, gauge(labelled(name, sel.Label), v))
+			}
+		}
+		result = append(result, gauge(labelled("ProcCount", sel.Label), float64(len(current))))
+
+		// перезапуск: прежний процесс пропал, а на его месте появился новый
+		if prev, ok := c.seen[sel.Label]; ok {
+			var gone, started int64
+			for id := range prev {
+				if !current[id] {
+					gone++
+				}
+			}
+			for id := range current {
+				if !prev[id] {
+					started++
+				}
+			}
+			result = append(result, counter(labelled("ProcRestarts", sel.Label), min(gone, started)))
+		}
+		c.seen[sel.Label] = current
+	}
+	c.deltas.end()
+
+	return result, nil
+}
+
+// values метрики одного процесса, недоступные значения пропускаются.
+// key отличает процесс в deltas: pid и время запуска
+func (c *ProcessCollector) values(key string, stat procStat) map[string]float64 {
+	result := make(map[string]float64, len(procMetrics))
+	rate := func(k string, v int64) (float64, bool) {
+		if v < 0 {
+			return 0, false
+		}
+		return c.deltas.rate(key+k, uint64(v))
+	}
+
+	// процессорное время в мс за секунду: 1000 - одно ядро целиком
+	if v, ok := rate("cpu", int64(stat.cpuTime*1000)); ok {
+		result["ProcCPUPercent"] = v / 10
+	}
+	if stat.rss >= 0 {
+		result["ProcRSS"] = float64(stat.rss)
+	}
+	if stat.fds >= 0 {
+		result["ProcFDs"] = float64(stat.fds)
+	}
+	if stat.threads >= 0 {
+		result["ProcThreads"] = float64(stat.threads)
+	}
+	if v, ok := rate("rb", stat.readBytes); ok {
+		result["ProcReadBytesRate"] = v
+	}
+	if v, ok := rate("wb", stat.writeBytes); ok {
+		result["ProcWriteBytesRate"] = v
+	}
+	return result
+}
+
+// gopsutilProcSource процессы через gopsutil
+type gopsutilProcSource struct{}
+
+func (gopsutilProcSource) Pids(ctx context.Context) ([]int32, error) {
+	return process.PidsWithContext(ctx)
+}
+
+func (gopsutilProcSource) Name(ctx context.Context, pid int32) (string, error) {
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return "", err
+	}
+	return p.NameWithContext(ctx)
+}
+
+func (gopsutilProcSource) Cmdline(ctx context.Context, pid int32) (string, error) {
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return "", err
+	}
+	return p.CmdlineWithContext(ctx)
+}
+
+func (gopsutilProcSource) Stat(ctx context.Context, pid int32) (procStat, error) {
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return procStat{}, err
+	}
+
+	stat := procStat{cpuTime: -1, rss: -1, fds: -1, threads: -1, readBytes: -1, writeBytes: -1}
+	if stat.createTime, err = p.CreateTimeWithContext(ctx); err != nil {
+		return procStat{}, err
+	}
+	if times, err := p.TimesWithContext(ctx); err == nil {
+		stat.cpuTime = times.User + times.System
+	}
+	if mem, err := p.MemoryInfoWithContext(ctx); err == nil {
+		stat.rss = int64(mem.RSS)
+	}
+	if fds, err := p.NumFDsWithContext(ctx); err == nil {
+		stat.fds = int64(fds)
+	}
+	if threads, err := p.NumThreadsWithContext(ctx); err == nil {
+		stat.threads = int64(threads)
+	}
+	if io, err := p.IOCountersWithContext(ctx); err == nil {
+		stat.readBytes = int64(io.ReadBytes)
+		stat.writeBytes = int64(io.WriteBytes)
+	}
+
+	return stat, nil
+}
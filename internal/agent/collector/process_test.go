@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeProcs процессы для ProcessCollector
+type fakeProcs struct {
+	names    map[int32]string
+	cmdlines map[int32]string
+	stats    map[int32]procStat
+}
+
+func (f *fakeProcs) Pids(ctx context.Context) ([]int32, error) {
+	result := make([]int32, 0, len(f.stats))
+	for pid := range f.stats {
+		result = append(result, pid)
+	}
+	return result, nil
+}
+
+func (f *fakeProcs) Name(ctx context.Context, pid int32) (string, error) {
+	return f.names[pid], nil
+}
+
+func (f *fakeProcs) Cmdline(ctx context.Context, pid int32) (string, error) {
+	return f.cmdlines[pid], nil
+}
+
+func (f *fakeProcs) Stat(ctx context.Context, pid int32) (procStat, error) {
+	stat, ok := f.stats[pid]
+	if !ok {
+		return procStat{}, os.ErrNotExist
+	}
+	return stat, nil
+}
+
+func TestProcessCollector(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte("10\n"), 0o644))
+
+	procs := &fakeProcs{
+		names:    map[int32]string{10: "app", 20: "nginx", 21: "nginx"},
+		cmdlines: map[int32]string{10: "/usr/bin/app -c app.yml", 20: "nginx: master", 21: "nginx: worker"},
+		stats: map[int32]procStat{
+			10: {createTime: 1, cpuTime: 1, rss: 100, fds: 5, threads: 3, readBytes: 0, writeBytes: -1},
+			20: {createTime: 2, cpuTime: 0, rss: 10, fds: 1, threads: 1, readBytes: 0, writeBytes: 0},
+			21: {createTime: 3, cpuTime: 0, rss: 10, fds: 1, threads: 1, readBytes: 0, writeBytes: 0},
+		},
+	}
+
+	options := json.RawMessage(`{"processes": [
+		{"label": "app", "pid_file": "` + pidFile + `"},
+		{"label": "nginx", "name": "nginx"},
+		{"label": "worker", "cmdline": "worker$", "per_pid": true}
+	]}`)
+	c, err := NewProcess("process", time.Second, options)
+	require.NoError(t, err)
+	pc := c.(*ProcessCollector)
+	pc.source = procs
+	pc.deltas = newDeltas(clk.now)
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	rss, ok := find(list, "ProcRSS:app")
+	require.True(t, ok)
+	assert.Equal(t, 100.0, *rss.Value)
+	_, ok = find(list, "ProcCPUPercent:app")
+	assert.False(t, ok, "rates start from the second poll")
+	// метрики селектора - суммы по его процессам, pid в имени только с per_pid
+	for id, want := range map[string]float64{
+		"ProcCount:app": 1, "ProcCount:nginx": 2, "ProcCount:worker": 1,
+		"ProcRSS:nginx": 20, "ProcFDs:nginx": 2, "ProcRSS:worker": 10, "ProcRSS:worker:21": 10,
+	} {
+		m, ok := find(list, id)
+		require.True(t, ok, id)
+		assert.Equal(t, want, *m.Value, id)
+	}
+	_, ok = find(list, "ProcRSS:nginx:20")
+	assert.False(t, ok, "no pid series without per_pid")
+	_, ok = find(list, "ProcRestarts:app")
+	assert.False(t, ok)
+
+	// половина ядра за 2 секунды, app перезапущен под новым pid
+	clk.t = clk.t.Add(2 * time.Second)
+	procs.stats[10] = procStat{createTime: 1, cpuTime: 2, rss: 200, fds: 6, threads: 3, readBytes: 4096, writeBytes: -1}
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	cpu, ok := find(list, "ProcCPUPercent:app")
+	require.True(t, ok)
+	assert.InDelta(t, 50.0, *cpu.Value, 0.001)
+	read, ok := find(list, "ProcReadBytesRate:app")
+	require.True(t, ok)
+	assert.Equal(t, 2048.0, *read.Value)
+	_, ok = find(list, "ProcWriteBytesRate:app")
+	assert.False(t, ok, "unavailable counters are skipped")
+	restarts, ok := find(list, "ProcRestarts:app")
+	require.True(t, ok)
+	assert.Equal(t, int64(0), *restarts.Delta)
+
+	clk.t = clk.t.Add(2 * time.Second)
+	delete(procs.stats, 10)
+	procs.names[30] = "app"
+	procs.stats[30] = procStat{createTime: 5, cpuTime: 0.1, rss: 50, fds: 4, threads: 2, readBytes: 0, writeBytes: 0}
+	require.NoError(t, os.WriteFile(pidFile, []byte("30\n"), 0o644))
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	rss, ok = find(list, "ProcRSS:app")
+	require.True(t, ok)
+	assert.Equal(t, 50.0, *rss.Value)
+	_, ok = find(list, "ProcCPUPercent:app")
+	assert.False(t, ok, "restarted process starts a new baseline")
+	restarts, ok = find(list, "ProcRestarts:app")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), *restarts.Delta)
+
+	// процесс не запущен
+	require.NoError(t, os.Remove(pidFile))
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	count, ok := find(list, "ProcCount:app")
+	require.True(t, ok)
+	assert.Equal(t, 0.0, *count.Value)
+}
+
+func TestProcessOptions(t *testing.T) {
+	for name, options := range map[string]string{
+		"empty":     `{}`,
+		"no label":  `{"processes": [{"name": "app"}]}`,
+		"duplicate": `{"processes": [{"label": "a", "name": "app"}, {"label": "a", "name": "db"}]}`,
+		"none":      `{"processes": [{"label": "a"}]}`,
+		"two":       `{"processes": [{"label": "a", "name": "app", "cmdline": "app"}]}`,
+		"regexp":    `{"processes": [{"label": "a", "cmdline": "("}]}`,
+	} {
+		_, err := NewProcess("process", time.Second, json.RawMessage(options))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestProcessCollectorSelf(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "self.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o644))
+
+	c, err := NewProcess("process", time.Second, json.RawMessage(`{"processes": [{"label": "self", "pid_file": "`+pidFile+`"}]}`))
+	require.NoError(t, err)
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	rss, ok := find(list, labelled("ProcRSS", "self"))
+	require.True(t, ok)
+	assert.Greater(t, *rss.Value, 0.0)
+}
@@ -0,0 +1,395 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promLabel метка сэмпла Prometheus
+type promLabel struct {
+	name, value string
+}
+
+// promSample строка с значением из текстового формата Prometheus
+type promSample struct {
+	name   string
+	labels []promLabel // по имени метки
+	value  float64
+}
+
+// promText разобранный текстовый формат: типы из # TYPE и сэмплы в порядке появления
+type promText struct {
+	types   map[string]string
+	samples []promSample
+}
+
+// parsePromText разбирает текстовый формат Prometheus 0.0.4. Строка с ошибкой пропускается,
+// ошибки возвращаются вместе с разобранным. Больше maxSamples сэмплов - ошибка, лишние отбрасываются
+func parsePromText(r io.Reader, maxSamples int) (promText, error) {
+	result := promText{types: make(map[string]string)}
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			// # TYPE имя тип, остальные комментарии и HELP не нужны
+			if fields := strings.Fields(text); len(fields) == 4 && fields[1] == "TYPE" {
+				result.types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		if len(result.samples) >= maxSamples {
+			errs = append(errs, fmt.Errorf("more than %d samples, rest dropped", maxSamples))
+			break
+		}
+		s, err := parsePromSample(text)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		result.samples = append(result.samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// parsePromSample строка вида name{label="value",...} value [timestamp]
+func parsePromSample(text string) (promSample, error) {
+	var s promSample
+
+	end := strings.IndexAny(text, "{ \t")
+	if end <= 0 {
+		return s, errors.New("no value")
+	}
+	s.name, text = text[:end], text[end:]
+
+	if text[0] == '{' {
+		var err error
+		if s.labels, text, err = parsePromLabels(text[1:]); err != nil {
+			return s, err
+		}
+	}
+
+	// временная метка не нужна: значение относится к моменту опроса
+	fields := strings.Fields(text)
+	if len(fields) < 1 || len(fields) > 2 {
+		return s, errors.New("expected value and optional timestamp")
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("value: %w", err)
+	}
+	s.value = v
+
+	sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+	return s, nil
+}
+
+// parsePromLabels метки до закрывающей "}" и остаток строки
+func parsePromLabels(text string) ([]promLabel, string, error) {
+	var labels []promLabel
+	for {
+		text = strings.TrimLeft(text, " \t")
+		if strings.HasPrefix(text, "}") {
+			return labels, text[1:], nil
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq <= 0 || len(text) < eq+2 || text[eq+1] != '"' {
+			return nil, "", errors.New("bad label")
+		}
+		name := strings.TrimSpace(text[:eq])
+		text = text[eq+2:]
+
+		// значение в кавычках с экранированием \\, \", \n
+		var sb strings.Builder
+		closed := false
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c == '"' {
+				text, closed = text[i+1:], true
+				break
+			}
+			if c == '\\' && i+1 < len(text) {
+				i++
+				switch text[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = text[i]
+				}
+			}
+			sb.WriteByte(c)
+		}
+		if !closed {
+			return nil, "", errors.New("unterminated label value")
+		}
+		labels = append(labels, promLabel{name: name, value: sb.String()})
+
+		text = strings.TrimLeft(text, " \t")
+		if strings.HasPrefix(text, ",") {
+			text = text[1:]
+		}
+	}
+}
+
+// promSeries ключ серии: имя и метки
+func promSeries(name string, labels []promLabel) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, l := range labels {
+		sb.WriteByte(0)
+		sb.WriteString(l.name)
+		sb.WriteByte(0)
+		sb.WriteString(l.value)
+	}
+	return sb.String()
+}
+
+// promHistogram серия гистограммы или summary, собранная из _bucket, _sum и _count
+type promHistogram struct {
+	name    string
+	labels  []promLabel
+	buckets map[float64]float64 // le -> накопленное число событий
+	sum     float64
+	count   float64
+}
+
+// promConverter переводит сэмплы Prometheus в метрики агента. Имя - prefix и имя метрики,
+// метки - пары имя.значение по алфавиту имен: http_requests_total{code="200",method="GET"} ->
+// http_requests_total:code.200:method.GET (длинные имена сокращаются, см. labelled). gauge и untyped отправляются как есть, накопительный counter -
+// приращением с прошлого опроса (сброс счетчика - со значения после сброса), дробная часть
+// переносится на следующие опросы. Гистограмма и summary - counter <имя>:count и gauge <имя>:avg
+// по событиям с прошлого опроса, у гистограммы еще :p50, :p90, :p99 по корзинам, у summary -
+// gauge квантилей. Первый опрос серии только запоминает счетчики
+type promConverter struct {
+	prefix string
+	prev   map[string]float64
+	cur    map[string]float64
+}
+
+func newPromConverter(prefix string) *promConverter {
+	return &promConverter{prefix: prefix, prev: make(map[string]float64)}
+}
+
+// id имя метрики агента: метки парами имя.значение по алфавиту имен. Если пару пришлось исправить
+// под правила имен сервера, добавляется хеш исходных меток, чтобы разные серии не слились в одну
+func (c *promConverter) id(name string, labels []promLabel, suffix ...string) string {
+	parts := make([]string, 0, len(labels)+len(suffix)+1)
+	lossy := false
+	for _, l := range labels {
+		pair := l.name + "." + l.value
+		if sanitizeLabel(pair) != pair {
+			lossy = true
+		}
+		parts = append(parts, pair)
+	}
+	if lossy {
+		h := fnv.New32a()
+		h.Write([]byte(promSeries("", labels)))
+		parts = append(parts, fmt.Sprintf("%08x", h.Sum32()))
+	}
+	return labelled(sanitizeName(c.prefix+name), append(parts, suffix...)...)
+}
+
+// delta приращение накопительного значения key, ok = false на первом опросе серии
+func (c *promConverter) delta(key string, value float64) (float64, bool) {
+	prev, ok := c.prev[key]
+	if !ok {
+		c.cur[key] = value
+		return 0, false
+	}
+	if value < prev {
+		// счетчик сброшен перезапуском приложения
+		prev = 0
+	}
+	c.cur[key] = value
+	return value - prev, true
+}
+
+// counter целое приращение, дробный остаток остается в запомненном значении
+func (c *promConverter) counter(key string, value float64) (int64, bool) {
+	prev, ok := c.prev[key]
+	if !ok {
+		c.cur[key] = value
+		return 0, false
+	}
+	if value < prev {
+		prev = 0
+	}
+	d := math.Floor(value - prev)
+	c.cur[key] = prev + d
+	return int64(d), true
+}
+
+// family тип семейства сэмпла и суффикс (_bucket, _sum, _count) для гистограмм и summary
+func (t promText) family(name string) (base, typ, suffix string) {
+	if typ, ok := t.types[name]; ok {
+		return name, typ, ""
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		if base == name {
+			continue
+		}
+		if typ := t.types[base]; typ == "histogram" || typ == "summary" {
+			return base, typ, suffix
+		}
+	}
+	// OpenMetrics: # TYPE foo counter и сэмпл foo_total
+	if base := strings.TrimSuffix(name, "_total"); base != name && t.types[base] == "counter" {
+		return name, "counter", ""
+	}
+	return name, "untyped", ""
+}
+
+// convert метрики агента из разобранного текста. Серии, пропавшие из вывода, забываются
+func (c *promConverter) convert(text promText) []models.Metric {
+	c.cur = make(map[string]float64, len(c.prev))
+
+	var (
+		result     []models.Metric
+		histograms []*promHistogram
+	)
+	byKey := make(map[string]*promHistogram)
+	group := func(name string, labels []promLabel) *promHistogram {
+		key := promSeries(name, labels)
+		h, ok := byKey[key]
+		if !ok {
+			h = &promHistogram{name: name, labels: labels, buckets: make(map[float64]float64), sum: math.NaN(), count: math.NaN()}
+			byKey[key] = h
+			histograms = append(histograms, h)
+		}
+		return h
+	}
+
+	for _, s := range text.samples {
+		// JSON не передает NaN и бесконечности
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		base, typ, suffix := text.family(s.name)
+
+		switch {
+		case typ == "counter":
+			if v, ok := c.counter(promSeries(s.name, s.labels), s.value); ok {
+				result = append(result, counter(c.id(s.name, s.labels), v))
+			}
+		case typ == "histogram" && suffix == "_bucket":
+			labels, le, ok := withoutLabel(s.labels, "le")
+			if !ok {
+				continue
+			}
+			bound, err := strconv.ParseFloat(le, 64)
+			if err != nil {
+				continue
+			}
+			group(base, labels).buckets[bound] = s.value
+		case (typ == "histogram" || typ == "summary") && suffix == "_sum":
+			group(base, s.labels).sum = s.value
+		case (typ == "histogram" || typ == "summary") && suffix == "_count":
+			group(base, s.labels).count = s.value
+		case typ == "histogram":
+			// сэмпл без суффикса у гистограммы не бывает
+		default:
+			// gauge, untyped и квантили summary
+			result = append(result, gauge(c.id(s.name, s.labels), s.value))
+		}
+	}
+
+	for _, h := range histograms {
+		result = append(result, c.histogram(h)...)
+	}
+
+	c.prev = c.cur
+	c.cur = nil
+	return result
+}
+
+// histogram метрики одной серии гистограммы или summary
+func (c *promConverter) histogram(h *promHistogram) []models.Metric {
+	if math.IsNaN(h.count) {
+		return nil
+	}
+	key := promSeries(h.name, h.labels)
+
+	count, ok := c.counter(key+"\x00count", h.count)
+	sum, sumOK := 0.0, false
+	if !math.IsNaN(h.sum) {
+		sum, sumOK = c.delta(key+"\x00sum", h.sum)
+	}
+
+	bounds := make([]float64, 0, len(h.buckets))
+	for le := range h.buckets {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+
+	// корзина i - события в (bounds[i-1], bounds[i]], в тексте корзины накопительные
+	counts := make([]uint64, len(bounds))
+	var total, prevCum uint64
+	bucketsOK := len(bounds) > 0
+	for i, le := range bounds {
+		d, ok := c.delta(key+"\x00le"+strconv.FormatFloat(le, 'g', -1, 64), h.buckets[le])
+		if !ok {
+			bucketsOK = false
+		}
+		cum := uint64(math.Max(d, 0))
+		if cum >= prevCum {
+			counts[i] = cum - prevCum
+			total += counts[i]
+		}
+		prevCum = cum
+	}
+
+	if !ok {
+		return nil
+	}
+	result := []models.Metric{counter(c.id(h.name, h.labels, "count"), count)}
+	if sumOK && count > 0 {
+		result = append(result, gauge(c.id(h.name, h.labels, "avg"), sum/float64(count)))
+	}
+	if !bucketsOK || total == 0 {
+		return result
+	}
+
+	buckets := append([]float64{math.Inf(-1)}, bounds...)
+	for _, q := range histogramQuantiles {
+		if v := histogramQuantile(counts, buckets, total, q.q); !math.IsInf(v, 0) {
+			result = append(result, gauge(c.id(h.name, h.labels, q.label), v))
+		}
+	}
+	return result
+}
+
+// withoutLabel метки без name и значение name
+func withoutLabel(labels []promLabel, name string) ([]promLabel, string, bool) {
+	result := make([]promLabel, 0, len(labels))
+	var value string
+	found := false
+	for _, l := range labels {
+		if l.name == name {
+			value, found = l.value, true
+			continue
+		}
+		result = append(result, l)
+	}
+	return result, value, found
+}
@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestPromConverter(t *testing.T) {
+	parse := func(text string) promText {
+		t.Helper()
+		result, err := parsePromText(strings.NewReader(text), 1000)
+		require.NoError(t, err)
+		return result
+	}
+
+	first := `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",code="200"} 10
+http_requests_total{method="POST",code="500"} 1 1700000000000
+# TYPE cpu_seconds counter
+cpu_seconds 1.5
+# TYPE temperature gauge
+temperature{sensor="a b\"c"} 21.5
+temperature{sensor="nan"} NaN
+untyped_value 7
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 10
+latency_seconds_bucket{le="0.5"} 20
+latency_seconds_bucket{le="+Inf"} 20
+latency_seconds_sum 3
+latency_seconds_count 20
+# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.2
+rpc_seconds_sum 10
+rpc_seconds_count 50
+`
+	c := newPromConverter("app_")
+	list := c.convert(parse(first))
+	assert.Equal(t, map[string]float64{
+		c.id("temperature", []promLabel{{"sensor", "a b\"c"}}): 21.5,
+		"app_untyped_value":            7,
+		"app_rpc_seconds:quantile.0.5": 0.2,
+	}, gaugeValues(list), "counters and histograms start from the second poll")
+	assert.Len(t, list, 3)
+
+	// 100 запросов: 80 до 0.1s, 15 до 0.5s, 5 дольше
+	second := strings.NewReplacer(
+		`code="200"} 10`, `code="200"} 15`,
+		`code="500"} 1`, `code="500"} 0`,
+		"cpu_seconds 1.5", "cpu_seconds 2.7",
+		`le="0.1"} 10`, `le="0.1"} 90`,
+		`le="0.5"} 20`, `le="0.5"} 115`,
+		`le="+Inf"} 20`, `le="+Inf"} 120`,
+		"latency_seconds_sum 3", "latency_seconds_sum 13",
+		"latency_seconds_count 20", "latency_seconds_count 120",
+		"rpc_seconds_sum 10", "rpc_seconds_sum 20",
+		"rpc_seconds_count 50", "rpc_seconds_count 100",
+	).Replace(first)
+	list = c.convert(parse(second))
+
+	deltas := make(map[string]int64)
+	for _, m := range list {
+		if m.MType == "counter" {
+			deltas[m.ID] = *m.Delta
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		labelled("app_http_requests_total", "code.200", "method.GET"):  5,
+		labelled("app_http_requests_total", "code.500", "method.POST"): 0, // сброс до 0
+		"app_cpu_seconds":           1, // 0.2 переносится
+		"app_latency_seconds:count": 100,
+		"app_rpc_seconds:count":     50,
+	}, deltas)
+
+	values := gaugeValues(list)
+	assert.Equal(t, 0.1, values["app_latency_seconds:p50"])
+	assert.Equal(t, 0.5, values["app_latency_seconds:p90"])
+	// в корзине +Inf берется ее нижняя граница
+	assert.Equal(t, 0.5, values["app_latency_seconds:p99"])
+	assert.Equal(t, 0.1, values["app_latency_seconds:avg"])
+	assert.Equal(t, 0.2, values["app_rpc_seconds:avg"])
+
+	third := strings.Replace(second, "cpu_seconds 2.7", "cpu_seconds 3.5", 1)
+	list = c.convert(parse(third))
+	cpu, ok := find(list, "app_cpu_seconds")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), *cpu.Delta, "carried fraction completes a whole unit")
+}
+
+func TestPromConverterID(t *testing.T) {
+	c := newPromConverter("")
+
+	// значения одинаковые, имена меток разные
+	assert.Equal(t, "m:a.x", c.id("m", []promLabel{{"a", "x"}}))
+	assert.NotEqual(t, c.id("m", []promLabel{{"a", "x"}}), c.id("m", []promLabel{{"b", "x"}}))
+
+	// значения, совпадающие после замены недопустимых символов, различаются хешем
+	slash, underscore := c.id("m", []promLabel{{"path", "/a/b"}}), c.id("m", []promLabel{{"path", "a_b"}})
+	assert.Equal(t, "m:path.a_b", underscore)
+	assert.NotEqual(t, slash, underscore)
+	assert.Regexp(t, `^m:path\._a_b:[0-9a-f]{8}:count$`, c.id("m", []promLabel{{"path", "/a/b"}}, "count"))
+	assert.NotEqual(t, c.id("m", []promLabel{{"v", "a:b"}}), c.id("m", []promLabel{{"v", "a_b"}}))
+}
+
+func TestParsePromText(t *testing.T) {
+	text, err := parsePromText(strings.NewReader("ok 1\nbad{x=1} 2\nno_value\nfine{a=\"1\", b=\"2\",} 3\n"), 1000)
+	assert.Error(t, err)
+	require.Len(t, text.samples, 2)
+	assert.Equal(t, []promLabel{{"a", "1"}, {"b", "2"}}, text.samples[1].labels)
+
+	text, err = parsePromText(strings.NewReader("a 1\nb 2\nc 3\n"), 2)
+	assert.Error(t, err)
+	assert.Len(t, text.samples, 2)
+}
@@ -0,0 +1,273 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("runtime", NewRuntime)
+}
+
+// Режимы коллектора "runtime"
+const (
+	// RuntimeModeCompat прежние имена полей runtime.MemStats и RandomValue
+	RuntimeModeCompat = "compat"
+	// RuntimeModeFull все метрики runtime/metrics под именами go_<путь>_<единица>
+	RuntimeModeFull = "full"
+	// RuntimeModeBoth оба набора
+	RuntimeModeBoth = "both"
+)
+
+// RuntimeOptions настройки коллектора "runtime"
+type RuntimeOptions struct {
+	// Mode compat, full или both (по умолчанию)
+	Mode string `json:"mode"`
+}
+
+// histogramQuantiles квантили гистограмм, считаются по событиям с прошлого опроса
+var histogramQuantiles = []struct {
+	label string
+	q     float64
+}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}}
+
+// RuntimeMetrics метрики рантайма Go из runtime/metrics, без остановки мира, как у runtime.ReadMemStats.
+// В режиме full каждая поддерживаемая метрика, найденная при старте, отправляется так:
+// накопительная целая - counter приращением с прошлого опроса, остальные - gauge текущим значением,
+// гистограмма - counter <имя>:count и gauge <имя>:p50, :p90, :p99, :max по событиям с прошлого опроса.
+// PollCount - число опросов, отправляется всегда
+type RuntimeMetrics struct {
+	base
+	compat  bool
+	full    bool
+	samples []metrics.Sample
+	index   map[string]int // имя runtime/metrics -> позиция в samples
+	descs   map[string]metrics.Description
+	ids     map[string]string // имя runtime/metrics -> имя метрики агента
+	// прошлые значения накопительных счетчиков и гистограмм, до первого опроса - нули
+	prev     map[string]uint64
+	prevHist map[string][]uint64
+	gcStats  debug.GCStats
+}
+
+// NewRuntime коллектор "runtime"
+func NewRuntime(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts RuntimeOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	c := &RuntimeMetrics{
+		base:     base{name: name, interval: interval},
+		index:    make(map[string]int),
+		descs:    make(map[string]metrics.Description),
+		ids:      make(map[string]string),
+		prev:     make(map[string]uint64),
+		prevHist: make(map[string][]uint64),
+	}
+	switch opts.Mode {
+	case RuntimeModeCompat:
+		c.compat = true
+	case RuntimeModeFull:
+		c.full = true
+	case "", RuntimeModeBoth:
+		c.compat, c.full = true, true
+	default:
+		return nil, fmt.Errorf("unknown runtime mode %q", opts.Mode)
+	}
+
+	for _, d := range metrics.All() {
+		if d.Kind == metrics.KindBad {
+			continue
+		}
+		c.index[d.Name] = len(c.samples)
+		c.descs[d.Name] = d
+		c.ids[d.Name] = runtimeMetricID(d.Name)
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+	}
+
+	return c, nil
+}
+
+// runtimeMetricID имя для сервера: /gc/heap/allocs:bytes -> go_gc_heap_allocs_bytes
+func runtimeMetricID(name string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimPrefix(name, "/"))
+	return labelled("go_" + id)
+}
+
+// Collect опрос: метрики выбранного режима и PollCount +1 за каждый опрос
+func (c *RuntimeMetrics) Collect(ctx context.Context) ([]models.Metric, error) {
+	metrics.Read(c.samples)
+
+	var result []models.Metric
+	if c.full {
+		for _, s := range c.samples {
+			result = append(result, c.convert(s)...)
+		}
+	}
+	if c.compat {
+		result = append(result, c.memStats()...)
+	}
+	result = append(result, counter("PollCount", 1))
+
+	return result, nil
+}
+
+// convert метрики агента из одного значения runtime/metrics
+func (c *RuntimeMetrics) convert(s metrics.Sample) []models.Metric {
+	id := c.ids[s.Name]
+
+	switch s.Value.Kind() {
+	case metrics.KindUint64:
+		v := s.Value.Uint64()
+		if !c.descs[s.Name].Cumulative {
+			return []models.Metric{gauge(id, float64(v))}
+		}
+		prev := c.prev[s.Name]
+		c.prev[s.Name] = v
+		if v < prev {
+			return nil
+		}
+		return []models.Metric{counter(id, int64(v-prev))}
+	case metrics.KindFloat64:
+		return []models.Metric{gauge(id, s.Value.Float64())}
+	case metrics.KindFloat64Histogram:
+		return c.histogram(id, s.Name, s.Value.Float64Histogram())
+	}
+	return nil
+}
+
+// histogram число событий с прошлого опроса, квантили и максимум по ним. Без новых событий
+// отправляется только нулевой count: квантили прошлого интервала не повторяются
+func (c *RuntimeMetrics) histogram(id, name string, h *metrics.Float64Histogram) []models.Metric {
+	prev := c.prevHist[name]
+	if len(prev) != len(h.Counts) {
+		prev = make([]uint64, len(h.Counts))
+	}
+
+	counts := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, v := range h.Counts {
+		if v >= prev[i] {
+			counts[i] = v - prev[i]
+		}
+		total += counts[i]
+	}
+	c.prevHist[name] = append(prev[:0], h.Counts...)
+
+	result := []models.Metric{counter(labelled(id, "count"), int64(total))}
+	if total == 0 {
+		return result
+	}
+
+	for _, q := range histogramQuantiles {
+		result = append(result, gauge(labelled(id, q.label), histogramQuantile(counts, h.Buckets, total, q.q)))
+	}
+	return append(result, gauge(labelled(id, "max"), histogramQuantile(counts, h.Buckets, total, 1)))
+}
+
+// histogramQuantile верхняя граница корзины, в которую попадает квантиль q.
+// У крайних корзин с бесконечной границей берется конечная
+func histogramQuantile(counts []uint64, buckets []float64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, v := range counts {
+		seen += v
+		if seen < rank {
+			continue
+		}
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		return buckets[i]
+	}
+	return buckets[len(buckets)-1]
+}
+
+// value целое значение по имени runtime/metrics, 0 - если метрики нет в этой версии Go
+func (c *RuntimeMetrics) value(name string) float64 {
+	i, ok := c.index[name]
+	if !ok {
+		return 0
+	}
+	switch s := c.samples[i]; s.Value.Kind() {
+	case metrics.KindUint64:
+		return float64(s.Value.Uint64())
+	case metrics.KindFloat64:
+		return s.Value.Float64()
+	}
+	return 0
+}
+
+// memStats поля runtime.MemStats, выраженные через runtime/metrics так же, как их считает рантайм.
+// LastGC и PauseTotalNs есть только в debug.ReadGCStats, который тоже не останавливает мир
+func (c *RuntimeMetrics) memStats() []models.Metric {
+	v := c.value
+	debug.ReadGCStats(&c.gcStats)
+
+	heapObjects := v("/memory/classes/heap/objects:bytes")
+	heapUnused := v("/memory/classes/heap/unused:bytes")
+	heapFree := v("/memory/classes/heap/free:bytes")
+	heapReleased := v("/memory/classes/heap/released:bytes")
+	tiny := v("/gc/heap/tiny/allocs:objects")
+
+	var gcCPUFraction float64
+	if total := v("/cpu/classes/total:cpu-seconds"); total > 0 {
+		gcCPUFraction = v("/cpu/classes/gc/total:cpu-seconds") / total
+	}
+	var lastGC float64
+	if !c.gcStats.LastGC.IsZero() {
+		lastGC = float64(c.gcStats.LastGC.UnixNano())
+	}
+
+	return []models.Metric{
+		gauge("Alloc", heapObjects),
+		gauge("BuckHashSys", v("/memory/classes/profiling/buckets:bytes")),
+		gauge("Frees", v("/gc/heap/frees:objects")+tiny),
+		gauge("GCCPUFraction", gcCPUFraction),
+		gauge("GCSys", v("/memory/classes/metadata/other:bytes")),
+		gauge("HeapAlloc", heapObjects),
+		gauge("HeapIdle", heapFree+heapReleased),
+		gauge("HeapInuse", heapObjects+heapUnused),
+		gauge("HeapObjects", v("/gc/heap/objects:objects")),
+		gauge("HeapReleased", heapReleased),
+		gauge("HeapSys", heapObjects+heapUnused+heapFree+heapReleased),
+		gauge("LastGC", lastGC),
+		// в современных версиях Go всегда 0
+		gauge("Lookups", 0),
+		gauge("MCacheInuse", v("/memory/classes/metadata/mcache/inuse:bytes")),
+		gauge("MCacheSys", v("/memory/classes/metadata/mcache/inuse:bytes")+v("/memory/classes/metadata/mcache/free:bytes")),
+		gauge("MSpanInuse", v("/memory/classes/metadata/mspan/inuse:bytes")),
+		gauge("MSpanSys", v("/memory/classes/metadata/mspan/inuse:bytes")+v("/memory/classes/metadata/mspan/free:bytes")),
+		gauge("Mallocs", v("/gc/heap/allocs:objects")+tiny),
+		gauge("NextGC", v("/gc/heap/goal:bytes")),
+		gauge("NumForcedGC", v("/gc/cycles/forced:gc-cycles")),
+		gauge("NumGC", v("/gc/cycles/total:gc-cycles")),
+		gauge("OtherSys", v("/memory/classes/other:bytes")),
+		gauge("PauseTotalNs", float64(c.gcStats.PauseTotal.Nanoseconds())),
+		gauge("StackInuse", v("/memory/classes/heap/stacks:bytes")),
+		gauge("StackSys", v("/memory/classes/heap/stacks:bytes")+v("/memory/classes/os-stacks:bytes")),
+		gauge("Sys", v("/memory/classes/total:bytes")),
+		gauge("TotalAlloc", v("/gc/heap/allocs:bytes")),
+		gauge("RandomValue", rand.Float64()*math.Pow(10, 6)),
+	}
+}
@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	c, err := NewRuntime("runtime", time.Second, nil)
+	require.NoError(t, err)
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	alloc, ok := find(list, "Alloc")
+	require.True(t, ok)
+	assert.Equal(t, "gauge", alloc.MType)
+	assert.Greater(t, *alloc.Value, 0.0)
+
+	pollCount, ok := find(list, "PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), *pollCount.Delta)
+}
+
+// sink не дает компилятору убрать выделение памяти в тесте
+var sink []byte
+
+func TestRuntimeModes(t *testing.T) {
+	memStats := []string{"Alloc", "BuckHashSys", "Frees", "GCCPUFraction", "GCSys", "HeapAlloc", "HeapIdle", "HeapInuse",
+		"HeapObjects", "HeapReleased", "HeapSys", "LastGC", "Lookups", "MCacheInuse", "MCacheSys", "MSpanInuse", "MSpanSys",
+		"Mallocs", "NextGC", "NumForcedGC", "NumGC", "OtherSys", "PauseTotalNs", "StackInuse", "StackSys", "Sys", "TotalAlloc",
+		"RandomValue"}
+
+	c, err := NewRuntime("runtime", time.Second, json.RawMessage(`{"mode": "compat"}`))
+	require.NoError(t, err)
+	runtime.GC()
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, list, len(memStats)+1)
+	for _, name := range memStats {
+		_, ok := find(list, name)
+		assert.True(t, ok, name)
+	}
+	numGC, _ := find(list, "NumGC")
+	assert.GreaterOrEqual(t, *numGC.Value, 1.0)
+	lastGC, _ := find(list, "LastGC")
+	assert.Greater(t, *lastGC.Value, 0.0)
+	sys, _ := find(list, "Sys")
+	heapSys, _ := find(list, "HeapSys")
+	assert.Greater(t, *sys.Value, *heapSys.Value)
+
+	c, err = NewRuntime("runtime", time.Second, json.RawMessage(`{"mode": "full"}`))
+	require.NoError(t, err)
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	_, ok := find(list, "Alloc")
+	assert.False(t, ok)
+	goroutines, ok := find(list, "go_sched_goroutines_goroutines")
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, *goroutines.Value, 1.0)
+	allocs, ok := find(list, "go_gc_heap_allocs_bytes")
+	require.True(t, ok)
+	assert.Equal(t, "counter", allocs.MType)
+	_, ok = find(list, "go_sched_latencies_seconds:count")
+	assert.True(t, ok)
+
+	seen := make(map[string]bool)
+	for _, m := range list {
+		assert.NoError(t, metricname.Validate(m.ID))
+		assert.False(t, seen[m.ID], "duplicate %s", m.ID)
+		seen[m.ID] = true
+	}
+
+	// второй опрос: накопительные счетчики - приращения
+	sink = make([]byte, 1<<20)
+	list, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	next, ok := find(list, "go_gc_heap_allocs_bytes")
+	require.True(t, ok)
+	total := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(total)
+	assert.GreaterOrEqual(t, *next.Delta, int64(len(sink)))
+	assert.Less(t, uint64(*next.Delta), total[0].Value.Uint64())
+
+	_, err = NewRuntime("runtime", time.Second, json.RawMessage(`{"mode": "memstats"}`))
+	assert.Error(t, err)
+}
+
+func TestRuntimeHistogram(t *testing.T) {
+	c, err := NewRuntime("runtime", time.Second, json.RawMessage(`{"mode": "full"}`))
+	require.NoError(t, err)
+	rc := c.(*RuntimeMetrics)
+
+	h := &metrics.Float64Histogram{
+		Buckets: []float64{math.Inf(-1), 1, 2, 4, 8, math.Inf(1)},
+		Counts:  []uint64{0, 50, 40, 9, 1},
+	}
+	list := rc.histogram("h", "/h:seconds", h)
+	assert.Equal(t, map[string]float64{"h:p50": 2, "h:p90": 4, "h:p99": 8, "h:max": 8}, gaugeValues(list))
+	count, ok := find(list, "h:count")
+	require.True(t, ok)
+	assert.Equal(t, int64(100), *count.Delta)
+
+	// новые события только в корзине с бесконечной границей
+	h.Counts = []uint64{0, 50, 40, 9, 3}
+	list = rc.histogram("h", "/h:seconds", h)
+	assert.Equal(t, map[string]float64{"h:p50": 8, "h:p90": 8, "h:p99": 8, "h:max": 8}, gaugeValues(list))
+	count, _ = find(list, "h:count")
+	assert.Equal(t, int64(2), *count.Delta)
+
+	// без событий - только нулевой count
+	list = rc.histogram("h", "/h:seconds", h)
+	require.Len(t, list, 1)
+	assert.Equal(t, int64(0), *list[0].Delta)
+}
@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("scrape", NewScrape)
+}
+
+const (
+	defaultScrapeTimeout    = 10 * time.Second
+	defaultScrapeMaxBody    = 4 << 20
+	defaultScrapeMaxMetrics = 1000
+)
+
+// ScrapeTarget страница /metrics приложения
+type ScrapeTarget struct {
+	// Name имя цели в ошибках и метке ScrapeUp, уникальное
+	Name string `json:"name"`
+	// URL адрес страницы, http или https
+	URL string `json:"url"`
+	// Prefix добавляется к именам метрик
+	Prefix string `json:"prefix"`
+	// Timeout 0 - 10s, но не больше интервала опроса
+	Timeout Duration `json:"timeout"`
+	// Headers дополнительные заголовки запроса, например Authorization
+	Headers map[string]string `json:"headers"`
+}
+
+// ScrapeOptions настройки коллектора "scrape"
+type ScrapeOptions struct {
+	Targets []ScrapeTarget `json:"targets"`
+	// MaxBody предел ответа в байтах, 0 - 4MiB
+	MaxBody int64 `json:"max_body"`
+	// MaxMetrics предел сэмплов от одной цели, лишние отбрасываются, 0 - 1000
+	MaxMetrics int `json:"max_metrics"`
+}
+
+// scrapeTarget цель со своим состоянием счетчиков
+type scrapeTarget struct {
+	ScrapeTarget
+	timeout   time.Duration
+	converter *promConverter
+}
+
+// ScrapeCollector метрики из текстового формата Prometheus на страницах приложений.
+// Метрики переводятся как у команд exec в формате prometheus: counter - приращениями с прошлого
+// опроса, гистограммы - числом событий, средним и квантилями (см. promConverter).
+// Для каждой цели отправляется ScrapeUp:<имя> - 1, если страница получена и разобрана
+type ScrapeCollector struct {
+	base
+	client     *http.Client
+	targets    []*scrapeTarget
+	maxBody    int64
+	maxMetrics int
+}
+
+func NewScrape(name string, interval time.Duration, options json.RawMessage) (Collector, error) {
+	var opts ScrapeOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Targets) == 0 {
+		return nil, errors.New("no targets configured")
+	}
+
+	c := &ScrapeCollector{
+		base:       base{name: name, interval: interval},
+		client:     &http.Client{},
+		maxBody:    opts.MaxBody,
+		maxMetrics: opts.MaxMetrics,
+	}
+	if c.maxBody <= 0 {
+		c.maxBody = defaultScrapeMaxBody
+	}
+	if c.maxMetrics <= 0 {
+		c.maxMetrics = defaultScrapeMaxMetrics
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range opts.Targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("target %d: empty name", i)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("target %d: duplicate name %q", i, t.Name)
+		}
+		seen[t.Name] = true
+
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("target %s: url must be http(s)://host/path", t.Name)
+		}
+
+		timeout := time.Duration(t.Timeout)
+		if timeout <= 0 {
+			timeout = min(defaultScrapeTimeout, interval)
+		}
+
+		c.targets = append(c.targets, &scrapeTarget{
+			ScrapeTarget: t,
+			timeout:      timeout,
+			converter:    newPromConverter(t.Prefix),
+		})
+	}
+
+	return c, nil
+}
+
+func (c *ScrapeCollector) Collect(ctx context.Context) ([]models.Metric, error) {
+	results := make([][]models.Metric, len(c.targets))
+	errs := make([]error, len(c.targets))
+
+	var wg sync.WaitGroup
+	for i, t := range c.targets {
+		wg.Add(1)
+		go func(i int, t *scrapeTarget) {
+			defer wg.Done()
+
+			list, err := c.scrape(ctx, t)
+			up := 1.0
+			if err != nil {
+				errs[i] = fmt.Errorf("target %s: %w", t.Name, err)
+				up = 0
+			}
+			results[i] = append(list, gauge(labelled("ScrapeUp", t.Name), up))
+		}(i, t)
+	}
+	wg.Wait()
+
+	var result []models.Metric
+	for _, list := range results {
+		result = append(result, list...)
+	}
+	return result, errors.Join(errs...)
+}
+
+// scrape загрузка и разбор одной страницы. Если страница не получена, счетчики цели
+// не меняются: следующий успешный опрос отправит приращение за весь перерыв
+func (c *ScrapeCollector) scrape(ctx context.Context, t *scrapeTarget) ([]models.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.maxBody {
+		return nil, fmt.Errorf("response exceeds %d bytes", c.maxBody)
+	}
+
+	text, err := parsePromText(bytes.NewReader(body), c.maxMetrics)
+	return t.converter.convert(text), err
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScrapeCollector(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int64
+		status   = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		requests += 10
+		fmt.Fprintf(w, "# TYPE requests_total counter\nrequests_total{path=\"/api\"} %d\n", requests)
+		fmt.Fprintf(w, "# TYPE queue_length gauge\nqueue_length 3\n")
+		fmt.Fprintf(w, "# TYPE latency_seconds histogram\nlatency_seconds_bucket{le=\"1\"} %d\n", requests)
+		fmt.Fprintf(w, "latency_seconds_bucket{le=\"+Inf\"} %d\nlatency_seconds_sum %d\nlatency_seconds_count %d\n",
+			requests, requests/2, requests)
+	}))
+	defer srv.Close()
+
+	c, err := NewScrape("scrape", time.Second, json.RawMessage(`{"targets": [
+		{"name": "app", "url": "`+srv.URL+`/metrics", "prefix": "app_", "headers": {"Authorization": "secret"}},
+		{"name": "down", "url": "http://127.0.0.1:1/metrics", "timeout": "200ms"}
+	]}`))
+	require.NoError(t, err)
+
+	list, err := c.Collect(context.Background())
+	assert.ErrorContains(t, err, "target down")
+	assert.Equal(t, map[string]float64{"app_queue_length": 3, "ScrapeUp:app": 1, "ScrapeUp:down": 0}, gaugeValues(list))
+
+	// "/api" не проходит в имя как есть, поэтому к метке добавлен хеш
+	requestsID := c.(*ScrapeCollector).targets[0].converter.id("requests_total", []promLabel{{"path", "/api"}})
+	assert.Regexp(t, `^app_requests_total:path\._api:[0-9a-f]{8}$`, requestsID)
+
+	list, _ = c.Collect(context.Background())
+	reqs, ok := find(list, requestsID)
+	require.True(t, ok)
+	assert.Equal(t, int64(10), *reqs.Delta)
+	count, ok := find(list, "app_latency_seconds:count")
+	require.True(t, ok)
+	assert.Equal(t, int64(10), *count.Delta)
+	values := gaugeValues(list)
+	assert.Equal(t, 1.0, values["app_latency_seconds:p50"])
+	assert.Equal(t, 0.5, values["app_latency_seconds:avg"])
+
+	// пропущенный опрос: приращение за весь перерыв
+	mu.Lock()
+	status = http.StatusInternalServerError
+	mu.Unlock()
+	list, err = c.Collect(context.Background())
+	assert.ErrorContains(t, err, "target app: unexpected status 500")
+	up, _ := find(list, "ScrapeUp:app")
+	assert.Equal(t, 0.0, *up.Value)
+
+	mu.Lock()
+	status = http.StatusOK
+	requests += 20
+	mu.Unlock()
+	list, _ = c.Collect(context.Background())
+	reqs, ok = find(list, requestsID)
+	require.True(t, ok)
+	assert.Equal(t, int64(30), *reqs.Delta)
+}
+
+func TestScrapeLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(w, "metric_%d %d\n", i, i)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewScrape("scrape", time.Second, json.RawMessage(`{"targets": [{"name": "app", "url": "`+srv.URL+`"}], "max_body": 100}`))
+	require.NoError(t, err)
+	list, err := c.Collect(context.Background())
+	assert.ErrorContains(t, err, "response exceeds 100 bytes")
+	assert.Len(t, list, 1)
+
+	c, err = NewScrape("scrape", time.Second, json.RawMessage(`{"targets": [{"name": "app", "url": "`+srv.URL+`"}], "max_metrics": 10}`))
+	require.NoError(t, err)
+	list, err = c.Collect(context.Background())
+	assert.ErrorContains(t, err, "more than 10 samples")
+	assert.Len(t, list, 11)
+
+	for name, options := range map[string]string{
+		"empty":     `{}`,
+		"no name":   `{"targets": [{"url": "http://localhost/metrics"}]}`,
+		"duplicate": `{"targets": [{"name": "a", "url": "http://a/"}, {"name": "a", "url": "http://b/"}]}`,
+		"scheme":    `{"targets": [{"name": "a", "url": "file:///etc/passwd"}]}`,
+		"host":      `{"targets": [{"name": "a", "url": "/metrics"}]}`,
+	} {
+		_, err := NewScrape("scrape", time.Second, json.RawMessage(options))
+		assert.Error(t, err, name)
+	}
+}
@@ -1,34 +1,54 @@
 package flags
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"flag"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/collector"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/queue"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/encryption"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/tlsconfig"
 	"github.com/rs/zerolog/log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	FlagRunAddr        string
-	FlagReportInterval int
-	FlagPollInterval   int
-	FlagWorkers        int
-	FlagHashKey        string
-	UseHashKey         bool
-	PollInterval       time.Duration
-	ReportInterval     time.Duration
+	FlagRunAddr          string
+	FlagReportInterval   int
+	FlagPollInterval     int
+	FlagWorkers          int
+	FlagHashKey          string
+	UseHashKey           bool
+	FlagCryptoKey        string
+	PublicKey            *rsa.PublicKey // публичный ключ сервера, nil - без шифрования
+	FlagKeyID            string         // идентификатор ключа -k в наборе ключей сервера
+	FlagToken            string         // bearer токен агента, пустой - без Authorization
+	FlagTLS              bool
+	FlagTLSCA            string
+	FlagTLSCert          string
+	FlagTLSKey           string
+	TLSConfig            *tls.Config // nil - без TLS
+	FlagSpoolDir         string      // каталог дисковой очереди неотправленных батчей, пустой - без очереди
+	FlagSpoolMaxSize     int64
+	FlagSpoolMaxAge      int
+	SpoolMaxAge          time.Duration
+	FlagQueuePolicy      string
+	FlagQueueSize        int          // емкость очередей отправки, 0 - 1000
+	QueuePolicy          queue.Policy // что делать с метриками, когда отправка не успевает
+	FlagShutdownTimeout  int
+	FlagCollectors       string
+	Collectors           []string // включенные коллекторы
+	FlagCollectorsConfig string
+	CollectorsConfig     map[string]collector.Config // настройки коллекторов по имени
+	ShutdownTimeout      time.Duration               // сколько ждать отправки очередей при остановке
+	Scheme               = "http"
+	PollInterval         time.Duration
+	ReportInterval       time.Duration
 )
 
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
-		}
-	})
-	return found
-}
-
 func ParseFlags() {
 
 	//defaultHashKey := "0123456789ABCDEF"
@@ -41,6 +61,23 @@ func ParseFlags() {
 	flag.IntVar(&FlagPollInterval, "p", 2, "pollInterval")
 	flag.StringVar(&FlagHashKey, "k", defaultHashKey, "hashKey")
 	flag.IntVar(&FlagWorkers, "l", defaultWorkers, "pool worker count")
+	flag.StringVar(&FlagCryptoKey, "crypto-key", "", "server public key PEM file to encrypt payloads")
+	flag.StringVar(&FlagKeyID, "key-id", "", "signing key id (server keys file)")
+	flag.StringVar(&FlagToken, "token", "", "bearer token for server API")
+	flag.BoolVar(&FlagTLS, "tls", false, "use https (implied by -tls-ca, -tls-cert, -tls-key)")
+	flag.StringVar(&FlagTLSCA, "tls-ca", "", "CA PEM file to verify server certificate")
+	flag.StringVar(&FlagTLSCert, "tls-cert", "", "agent certificate PEM file (mTLS)")
+	flag.StringVar(&FlagTLSKey, "tls-key", "", "agent private key PEM file (mTLS)")
+	flag.StringVar(&FlagSpoolDir, "spool-dir", "", "dir to keep unsent batches while server is unreachable")
+	flag.Int64Var(&FlagSpoolMaxSize, "spool-max-size", 64<<20, "spool size limit in bytes, oldest batches are dropped")
+	flag.IntVar(&FlagSpoolMaxAge, "spool-max-age", 86400, "spool batch max age in seconds, 0 - unlimited")
+	flag.StringVar(&FlagQueuePolicy, "queue-policy", string(queue.Coalesce),
+		"full send queue policy: block, drop-oldest, drop-newest, coalesce")
+	flag.IntVar(&FlagQueueSize, "queue-size", 0, "send queue capacity, 0 - 1000")
+	flag.IntVar(&FlagShutdownTimeout, "shutdown-timeout", 10, "seconds to flush queued metrics on SIGTERM/SIGINT")
+	flag.StringVar(&FlagCollectors, "collectors", "runtime,gopsutil", "comma separated collectors to enable: "+strings.Join(collector.Names(), ", "))
+	flag.StringVar(&FlagCollectorsConfig, "collectors-config", "",
+		`collectors JSON config: {"<name>": {"enabled": bool, "interval": "2s", "options": {...}}}`)
 	flag.Parse()
 
 	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
@@ -61,11 +98,7 @@ func ParseFlags() {
 		FlagHashKey = envHashKey
 	}
 
-	if !isFlagPassed(FlagHashKey) && os.Getenv("KEY") == "" {
-		UseHashKey = false
-	} else {
-		UseHashKey = true
-	}
+	UseHashKey = FlagHashKey != ""
 	//UseHashKey = true
 
 	log.Info().
@@ -77,4 +110,96 @@ func ParseFlags() {
 		FlagWorkers, _ = strconv.Atoi(envFlagWorkers)
 	}
 
+	if envCryptoKey := os.Getenv("CRYPTO_KEY"); envCryptoKey != "" {
+		FlagCryptoKey = envCryptoKey
+	}
+
+	if FlagCryptoKey != "" {
+		var err error
+		PublicKey, err = encryption.LoadPublicKey(FlagCryptoKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("FlagCryptoKey")
+		}
+	}
+
+	if envKeyID := os.Getenv("KEY_ID"); envKeyID != "" {
+		FlagKeyID = envKeyID
+	}
+
+	if envToken := os.Getenv("TOKEN"); envToken != "" {
+		FlagToken = envToken
+	}
+
+	if envTLS := os.Getenv("TLS"); envTLS != "" {
+		FlagTLS, _ = strconv.ParseBool(envTLS)
+	}
+
+	if envTLSCA := os.Getenv("TLS_CA"); envTLSCA != "" {
+		FlagTLSCA = envTLSCA
+	}
+
+	if envTLSCert := os.Getenv("TLS_CERT"); envTLSCert != "" {
+		FlagTLSCert = envTLSCert
+	}
+
+	if envTLSKey := os.Getenv("TLS_KEY"); envTLSKey != "" {
+		FlagTLSKey = envTLSKey
+	}
+
+	if FlagTLS || FlagTLSCA != "" || FlagTLSCert != "" || FlagTLSKey != "" {
+		var err error
+		TLSConfig, err = tlsconfig.ClientConfig(FlagTLSCA, FlagTLSCert, FlagTLSKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("TLSConfig")
+		}
+		Scheme = "https"
+	}
+
+	if envSpoolDir := os.Getenv("SPOOL_DIR"); envSpoolDir != "" {
+		FlagSpoolDir = envSpoolDir
+	}
+
+	if envSpoolMaxSize := os.Getenv("SPOOL_MAX_SIZE"); envSpoolMaxSize != "" {
+		FlagSpoolMaxSize, _ = strconv.ParseInt(envSpoolMaxSize, 10, 64)
+	}
+
+	if envSpoolMaxAge := os.Getenv("SPOOL_MAX_AGE"); envSpoolMaxAge != "" {
+		FlagSpoolMaxAge, _ = strconv.Atoi(envSpoolMaxAge)
+	}
+	SpoolMaxAge = time.Second * time.Duration(FlagSpoolMaxAge)
+
+	if envQueuePolicy := os.Getenv("QUEUE_POLICY"); envQueuePolicy != "" {
+		FlagQueuePolicy = envQueuePolicy
+	}
+
+	if envQueueSize := os.Getenv("QUEUE_SIZE"); envQueueSize != "" {
+		FlagQueueSize, _ = strconv.Atoi(envQueueSize)
+	}
+
+	if envShutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT"); envShutdownTimeout != "" {
+		FlagShutdownTimeout, _ = strconv.Atoi(envShutdownTimeout)
+	}
+	ShutdownTimeout = time.Second * time.Duration(FlagShutdownTimeout)
+
+	var err error
+	QueuePolicy, err = queue.ParsePolicy(FlagQueuePolicy)
+	if err != nil {
+		log.Fatal().Err(err).Msg("FlagQueuePolicy")
+	}
+
+	if envCollectors := os.Getenv("COLLECTORS"); envCollectors != "" {
+		FlagCollectors = envCollectors
+	}
+	Collectors = collector.ParseList(FlagCollectors)
+
+	if envCollectorsConfig := os.Getenv("COLLECTORS_CONFIG"); envCollectorsConfig != "" {
+		FlagCollectorsConfig = envCollectorsConfig
+	}
+
+	if FlagCollectorsConfig != "" {
+		CollectorsConfig, err = collector.LoadConfig(FlagCollectorsConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("FlagCollectorsConfig")
+		}
+	}
 }
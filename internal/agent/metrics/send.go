@@ -5,28 +5,118 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/flags"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/queue"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/spool"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/encryption"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/signing"
 	"github.com/rs/zerolog/log"
 	"github.com/sethvargo/go-retry"
+	"io"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type hashSHA256Struct struct {
-	data string
-	err  error
+// retryBackoff первая пауза между попытками отправки
+var retryBackoff = 1 * time.Second
+
+// ErrRejected сервер отклонил батч как некорректный (400, 413, 422): повтор не поможет,
+// такой батч не откладывается в spool, а пропускается
+var ErrRejected = errors.New("batch rejected by server")
+
+// ErrUndelivered батч не дошел до сервера или сервер временно не смог его принять
+// (ошибка сети, 429, 5xx): только такой батч можно отложить в spool и отправить еще раз.
+// Принятый сервером батч повторять нельзя - у spool нет ключа идемпотентности, counter удвоятся
+var ErrUndelivered = errors.New("batch not delivered")
+
+// rejectedBatches число пропущенных отклоненных батчей
+var rejectedBatches atomic.Int64
+
+// RejectedBatches сколько батчей сервер отклонил как некорректные
+func RejectedBatches() int64 {
+	return rejectedBatches.Load()
+}
+
+// unverifiedResponses число ответов 2xx на батч, не прошедших проверку подписи
+var unverifiedResponses atomic.Int64
+
+// UnverifiedResponses сколько принятых сервером батчей пришло с неверной или нечитаемой подписью ответа
+func UnverifiedResponses() int64 {
+	return unverifiedResponses.Load()
+}
+
+// maxRetryAfter дольше агент не ждет по Retry-After между попытками
+const maxRetryAfter = time.Minute
+
+// retryAfterBackoff пауза перед повтором не меньше Retry-After последнего ответа 429
+type retryAfterBackoff struct {
+	next retry.Backoff
+	hint time.Duration
+}
+
+func newRetryBackoff(base time.Duration) *retryAfterBackoff {
+	return &retryAfterBackoff{next: retry.WithMaxRetries(3, retry.NewFibonacci(base))}
+}
+
+func (b *retryAfterBackoff) Next() (time.Duration, bool) {
+	d, stop := b.next.Next()
+	if stop {
+		return 0, true
+	}
+	d = max(d, b.hint)
+	b.hint = 0
+	return d, false
 }
 
-var hashSHA256 = &hashSHA256Struct{}
+// rateLimited запоминает паузу из Retry-After (секунды или HTTP-дата) и возвращает повторяемую ошибку
+func (b *retryAfterBackoff) rateLimited(res *http.Response) error {
+	v := res.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		b.hint = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(v); err == nil {
+		b.hint = time.Until(at)
+	}
+	b.hint = min(max(b.hint, 0), maxRetryAfter)
+
+	return retry.RetryableError(fmt.Errorf("%w: rate limited: %s, retry after %s", ErrUndelivered, res.Status, b.hint))
+}
+
+// isRetryableNetErr сетевые ошибки, после которых запрос стоит повторить
+func isRetryableNetErr(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		strings.Contains(err.Error(), "EOF") ||
+		strings.Contains(err.Error(), "connection reset by peer")
+}
+
+// batchStatusError ошибка по коду ответа на батч: nil для 2xx, ErrRejected для 400, 413, 422,
+// повторяемая ErrUndelivered для 5xx, остальные (401, 403, ...) - ошибка без повтора, новый батч
+// с ней в spool не попадает. 429 обрабатывается до нее через retryAfterBackoff
+func batchStatusError(res *http.Response) error {
+	switch code := res.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusBadRequest, code == http.StatusRequestEntityTooLarge, code == http.StatusUnprocessableEntity:
+		return fmt.Errorf("%w: %s", ErrRejected, res.Status)
+	case code >= http.StatusInternalServerError:
+		return retry.RetryableError(fmt.Errorf("%w: server error: %s", ErrUndelivered, res.Status))
+	default:
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+}
 
 func signReqBody(body []byte) (string, error) {
 	h := hmac.New(sha256.New, []byte(flags.FlagHashKey))
@@ -36,126 +126,334 @@ func signReqBody(body []byte) (string, error) {
 	return hex.EncodeToString(dst), nil
 }
 
-func CollectMetrics(runtimeMetrics *RuntimeMetrics, gopsutilMetrics *GopsutilMetrics) (CashMetrics, error) {
-	var (
-		CashMetrics   CashMetrics
-		gaugeMetric   models.Metric
-		counterMetric models.Metric
-	)
+// signRequest подписывает тело запроса вместе со свежими timestamp и nonce
+func signRequest(req *http.Request, body []byte) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return fmt.Errorf("rand.Read: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(nonceBytes)
 
-	for _, mName := range runtimeMetrics.GetGaugeName() {
-		gaugeMetric.ID = mName
-		gaugeMetric.MType = "gauge"
+	hash, err := signReqBody(signing.Envelope(timestamp, nonce, body))
+	if err != nil {
+		return err
+	}
 
-		gaugeMetricTemp, err := runtimeMetrics.GetGaugeValue(mName)
-		if err != nil {
-			return CashMetrics, err
-		}
-		gaugeMetric.Value = &gaugeMetricTemp
+	req.Header.Set("HashSHA256", hash)
+	req.Header.Set(signing.HeaderTimestamp, timestamp)
+	req.Header.Set(signing.HeaderNonce, nonce)
+	if flags.FlagKeyID != "" {
+		req.Header.Set("HashSHA256-KeyID", flags.FlagKeyID)
+	}
 
-		CashMetrics.CashMetrics = append(CashMetrics.CashMetrics, gaugeMetric)
+	return nil
+}
+
+// encryptReqBody шифрует сжатое тело публичным ключом сервера,
+// возвращает тело и значение заголовка X-Encrypted-Key (пустое - без шифрования)
+func encryptReqBody(data []byte) (io.Reader, string, error) {
+	if flags.PublicKey == nil {
+		return bytes.NewReader(data), "", nil
 	}
 
-	gaugeMetric.ID = "TotalMemory"
-	gaugeMetric.MType = "gauge"
-	gaugeMetric.Value = &gopsutilMetrics.TotalMemory
-	CashMetrics.CashMetrics = append(CashMetrics.CashMetrics, gaugeMetric)
+	encKey, ciphertext, err := encryption.Encrypt(flags.PublicKey, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return bytes.NewReader(ciphertext), base64.StdEncoding.EncodeToString(encKey), nil
+}
 
-	gaugeMetric.ID = "FreeMemory"
-	gaugeMetric.MType = "gauge"
-	gaugeMetric.Value = &gopsutilMetrics.FreeMemory
-	CashMetrics.CashMetrics = append(CashMetrics.CashMetrics, gaugeMetric)
+// outboundIP адрес, с которого агент ходит к серверу addr (host:port).
+// UDP "соединение" пакетов не отправляет, только выбирает маршрут и локальный адрес
+func outboundIP(addr string) (string, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
 
-	for k, v := range gopsutilMetrics.CPUutilization {
-		gaugeMetric.ID = "CPUutilization" + strconv.Itoa(k)
-		gaugeMetric.MType = "gauge"
-		gaugeMetric.Value = &v
-		CashMetrics.CashMetrics = append(CashMetrics.CashMetrics, gaugeMetric)
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return "", err
 	}
 
-	counterMetric.ID = "PollCount"
-	counterMetric.MType = "counter"
-	counterMetricTemp := int64(runtimeMetrics.PollCount)
-	counterMetric.Delta = &counterMetricTemp
+	return host, nil
+}
 
-	CashMetrics.CashMetrics = append(CashMetrics.CashMetrics, counterMetric)
+// outboundTTL сколько помнить исходящий адрес: маршрут к серверу может смениться, но не на каждом запросе
+const outboundTTL = time.Minute
 
-	return CashMetrics, nil
+// outboundEntry исходящий адрес для сервера или ошибка его определения
+type outboundEntry struct {
+	ip      string
+	err     error
+	expires time.Time
 }
 
-func SendMetricBatch(CashMetrics CashMetrics, httpClient http.Client, reportRunAddr string) error {
-	var netErr net.Error
-	urlMetric := fmt.Sprintf("http://%s/updates/", reportRunAddr)
-	ctx := context.Background()
-	b := retry.NewFibonacci(1 * time.Second)
+// outboundCache исходящие адреса по адресу сервера, чтобы не открывать UDP сокет на каждый запрос
+type outboundCache struct {
+	mu      sync.Mutex
+	entries map[string]outboundEntry
+	lookup  func(addr string) (string, error)
+	now     func() time.Time
+}
 
-	type responseBody struct {
-		Description string `json:"description"` // имя метрики
+var outboundIPs = &outboundCache{entries: make(map[string]outboundEntry), lookup: outboundIP, now: time.Now}
+
+// get адрес из кеша или новый через lookup. Ошибка тоже запоминается на outboundTTL,
+// чтобы сбой маршрута не повторял попытку и запись в лог на каждом запросе
+func (c *outboundCache) get(addr string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if e, ok := c.entries[addr]; ok && now.Before(e.expires) {
+		return e.ip, e.err
 	}
 
-	resBody := responseBody{}
+	ip, err := c.lookup(addr)
+	if err != nil {
+		log.Info().Err(err).Msg("can not get outbound IP")
+	}
+	c.entries[addr] = outboundEntry{ip: ip, err: err, expires: now.Add(outboundTTL)}
+	return ip, err
+}
+
+// setRealIPHeader передает серверу реальный исходящий адрес агента в X-Real-IP
+func setRealIPHeader(req *http.Request, reportRunAddr string) {
+	ip, err := outboundIPs.get(reportRunAddr)
+	if err != nil {
+		return
+	}
+	req.Header.Set("X-Real-IP", ip)
+}
+
+// setAuthHeader добавляет bearer токен агента, если он задан
+func setAuthHeader(req *http.Request) {
+	if flags.FlagToken != "" {
+		req.Header.Set("Authorization", "Bearer "+flags.FlagToken)
+	}
+}
+
+// checkResSign сверяет подпись тела ответа из заголовка HashSHA256
+func checkResSign(res *http.Response, body []byte) error {
+	resHeaderHash := res.Header.Get("HashSHA256")
+	if resHeaderHash == "" {
+		return errors.New("response HashSHA256 header missing")
+	}
+
+	expected, err := signReqBody(body)
+	if err != nil {
+		return fmt.Errorf("signReqBody: %w", err)
+	}
 
+	if !hmac.Equal([]byte(resHeaderHash), []byte(expected)) {
+		return errors.New("response HashSHA256 signature mismatch")
+	}
+
+	return nil
+}
+
+// newRequest запрос /update/ или /updates/. Собирается заново на каждую попытку:
+// тело читается при отправке, а подпись с timestamp и nonce одноразовая
+func newRequest(ctx context.Context, urlMetric, reportRunAddr string, reqBody, body []byte, encKey string) *http.Request {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, urlMetric, bytes.NewReader(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Encoding", "gzip")
+	setAuthHeader(req)
+	setRealIPHeader(req, reportRunAddr)
+	if encKey != "" {
+		req.Header.Add(encryption.HeaderEncryptedKey, encKey)
+	}
+
+	if flags.UseHashKey {
+		if err := signRequest(req, reqBody); err != nil {
+			log.Info().Err(err).Msg("can not signRequest")
+		}
+	}
+
+	return req
+}
+
+func SendMetricBatch(ctx context.Context, CashMetrics CashMetrics, httpClient http.Client, reportRunAddr string) error {
 	reqBody, err := json.Marshal(CashMetrics.CashMetrics)
 	if err != nil {
 		return fmt.Errorf("marshal Batch error, %w", err)
 	}
 	log.Info().Str("reqBody", string(reqBody)).Msg("Marshal Batch result")
 
-	if flags.UseHashKey {
-		hashSHA256.data, hashSHA256.err = signReqBody(reqBody)
-		if hashSHA256.err != nil {
-			log.Info().Err(err).Msg("can not signReqBody")
-		}
+	return sendBatchBody(ctx, reqBody, httpClient, reportRunAddr)
+}
+
+// sendBatchBody отправляет JSON массив метрик. Ошибки сети, 429 и 5xx повторяются,
+// после последней попытки или отмены ctx возвращается ErrUndelivered - батч можно отложить в spool.
+// Отклоненный как некорректный батч учитывается в RejectedBatches, возвращается ErrRejected.
+// Ответ 2xx означает, что сервер применил батч: ошибка чтения ответа или его подписи
+// только записывается в лог и UnverifiedResponses, иначе батч попал бы в spool и counter удвоились
+func sendBatchBody(ctx context.Context, reqBody []byte, httpClient http.Client, reportRunAddr string) error {
+	urlMetric := fmt.Sprintf("%s://%s/updates/", flags.Scheme, reportRunAddr)
+	b := newRetryBackoff(retryBackoff)
+
+	type responseBody struct {
+		Description string `json:"description"` // имя метрики
 	}
 
+	resBody := responseBody{}
+
 	var buf bytes.Buffer
 	gzipWriter := gzip.NewWriter(&buf)
 	gzipWriter.Write(reqBody)
 	gzipWriter.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, urlMetric, &buf)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Content-Encoding", "gzip")
-
-	if flags.UseHashKey && hashSHA256.err == nil {
-		req.Header.Add("HashSHA256", hashSHA256.data)
+	body, encKey, err := encryptReqBody(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("encryptReqBody error, %w", err)
+	}
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("encryptReqBody error, %w", err)
 	}
 
-	err = retry.Do(ctx, retry.WithMaxRetries(3, b), func(ctx context.Context) error {
+	err = retry.Do(ctx, b, func(ctx context.Context) error {
+		req := newRequest(ctx, urlMetric, reportRunAddr, reqBody, bodyBytes, encKey)
+
 		res, err := httpClient.Do(req)
 		if err != nil {
-			if errors.As(err, &netErr) ||
-				netErr.Timeout() ||
-				strings.Contains(err.Error(), "EOF") ||
-				strings.Contains(err.Error(), "connection reset by peer") {
-
+			err = fmt.Errorf("%w: %w", ErrUndelivered, err)
+			if isRetryableNetErr(err) {
 				return retry.RetryableError(err)
 			}
 			return err
 		}
 		defer res.Body.Close()
 
+		if res.StatusCode == http.StatusTooManyRequests {
+			return b.rateLimited(res)
+		}
+		if err = batchStatusError(res); err != nil {
+			return err
+		}
+
+		if flags.UseHashKey {
+			body, err := io.ReadAll(res.Body)
+			if err == nil {
+				err = checkResSign(res, body)
+			}
+			if err != nil {
+				unverifiedResponses.Add(1)
+				log.Info().Err(err).Int64("unverified", unverifiedResponses.Load()).
+					Msg("batch accepted, response not verified")
+			}
+		}
+
 		log.Info().Str("status", res.Status).Msg(fmt.Sprintln("resBody Batch:", resBody.Description))
 		return nil
 	})
+	if err != nil && !errors.Is(err, ErrUndelivered) && errors.Is(err, ctx.Err()) {
+		// ctx отменен до очередной попытки
+		err = fmt.Errorf("%w: %w", ErrUndelivered, err)
+	}
+	if errors.Is(err, ErrRejected) {
+		rejectedBatches.Add(1)
+		log.Info().Err(err).Int64("rejected", rejectedBatches.Load()).Msg("batch skipped")
+	}
 	if err != nil {
-		return fmt.Errorf("SendMetricWorker error, %w", err)
+		return fmt.Errorf("sendBatchBody error, %w", err)
 	}
 
 	return nil
 }
 
-func SendMetricWorker(workerID int, chCashMetrics <-chan models.Metric, chCashMetricsErrors chan<- error,
+// SendMetricBatchSpooled отправляет батч после накопленных в sp, сохраняя порядок.
+// Пока сервер недоступен (ErrUndelivered), новые батчи складываются в sp и отправляются, когда он вернется.
+// Если spool не удалось отправить, новый батч не отправляется и тоже откладывается.
+// Батч, который сервер не принял по другой причине (401, 403, ...), возвращается ошибкой и в spool не попадает.
+// sp == nil - то же, что SendMetricBatch
+func SendMetricBatchSpooled(ctx context.Context, CashMetrics CashMetrics, sp *spool.Spool,
+	httpClient http.Client, reportRunAddr string) error {
+	if sp == nil {
+		return SendMetricBatch(ctx, CashMetrics, httpClient, reportRunAddr)
+	}
+
+	reqBody, err := json.Marshal(CashMetrics.CashMetrics)
+	if err != nil {
+		return fmt.Errorf("marshal Batch error, %w", err)
+	}
+
+	// пока spool не отправлен, новый батч не уходит на сервер, и его можно отложить
+	err = ReplaySpool(ctx, sp, httpClient, reportRunAddr)
+	if err == nil {
+		err = sendBatchBody(ctx, reqBody, httpClient, reportRunAddr)
+		switch {
+		case errors.Is(err, ErrRejected):
+			// некорректный батч не пройдет и позже, в spool он только задержал бы остальные
+			return nil
+		case err != nil && !errors.Is(err, ErrUndelivered):
+			return err
+		}
+	}
+	if err != nil {
+		if spoolErr := sp.Append(reqBody); spoolErr != nil {
+			return fmt.Errorf("%w, spool error: %w", err, spoolErr)
+		}
+		log.Info().Err(err).Int("metrics", len(CashMetrics.CashMetrics)).Msg("batch spooled")
+	}
+
+	return nil
+}
+
+// SpoolMetrics откладывает метрики в sp одним батчем
+func SpoolMetrics(sp *spool.Spool, metrics []models.Metric) error {
+	reqBody, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("marshal Batch error, %w", err)
+	}
+
+	return sp.Append(reqBody)
+}
+
+// ReplaySpool отправляет накопленные в sp батчи по порядку, до первой ошибки.
+// Отклоненные сервером как некорректные батчи пропускаются
+func ReplaySpool(ctx context.Context, sp *spool.Spool, httpClient http.Client, reportRunAddr string) error {
+	if sp.Empty() {
+		return nil
+	}
+
+	n, err := sp.Replay(func(data []byte) error {
+		if err := sendBatchBody(ctx, data, httpClient, reportRunAddr); !errors.Is(err, ErrRejected) {
+			return err
+		}
+		return nil
+	})
+	if n > 0 {
+		stats := sp.Stats()
+		log.Info().Int("batches", n).Int64("spoolBytes", stats.Bytes).Int64("dropped", stats.Dropped).
+			Msg("spool replayed")
+	}
+	if err != nil {
+		return fmt.Errorf("ReplaySpool: %w", err)
+	}
+
+	return nil
+}
+
+// SendMetricWorker отправляет метрики из очереди по одной, пока очередь не закрыта и не опустела
+// или не отменен ctx
+func SendMetricWorker(ctx context.Context, workerID int, metricsQueue *queue.Queue, chCashMetricsErrors chan<- error,
 	httpClient http.Client, reportRunAddr string) {
-	var netErr net.Error
-	urlMetric := fmt.Sprintf("http://%s/update/", reportRunAddr)
+	urlMetric := fmt.Sprintf("%s://%s/update/", flags.Scheme, reportRunAddr)
 	log.Info().Str("workerID", strconv.Itoa(workerID)).Msg("SendMetricWorker started")
 
-	for el := range chCashMetrics {
-		log.Info().Str("len", strconv.Itoa(len(chCashMetrics))).Msg("chCashMetrics_len")
+	for {
+		el, ok := metricsQueue.Get(ctx)
+		if !ok {
+			return
+		}
+		log.Info().Str("len", strconv.Itoa(metricsQueue.Len())).Msg("metricsQueue_len")
 
-		ctx := context.Background()
-		b := retry.NewFibonacci(1 * time.Second)
+		b := newRetryBackoff(retryBackoff)
 		respMetric := models.Metric{}
 
 		reqBody, err := json.Marshal(el)
@@ -165,42 +463,56 @@ func SendMetricWorker(workerID int, chCashMetrics <-chan models.Metric, chCashMe
 		}
 		log.Info().Str("reqBody", string(reqBody)).Msg("Marshal result")
 
-		if flags.UseHashKey {
-			hashSHA256.data, hashSHA256.err = signReqBody(reqBody)
-			if hashSHA256.err != nil {
-				chCashMetricsErrors <- fmt.Errorf("can not signReqBody:, %w", err)
-			}
-		}
-
 		var buf bytes.Buffer
 		gzipWriter := gzip.NewWriter(&buf)
 		gzipWriter.Write(reqBody)
 		gzipWriter.Close()
 
-		req, _ := http.NewRequest(http.MethodPost, urlMetric, &buf)
-		req.Header.Add("Content-Type", "application/json")
-		req.Header.Add("Content-Encoding", "gzip")
-
-		if flags.UseHashKey && hashSHA256.err == nil {
-			req.Header.Add("HashSHA256", hashSHA256.data)
+		body, encKey, err := encryptReqBody(buf.Bytes())
+		if err != nil {
+			chCashMetricsErrors <- fmt.Errorf("encryptReqBody error, %w", err)
+			continue
+		}
+		bodyBytes, err := io.ReadAll(body)
+		if err != nil {
+			chCashMetricsErrors <- fmt.Errorf("encryptReqBody error, %w", err)
+			continue
 		}
 
-		err = retry.Do(ctx, retry.WithMaxRetries(3, b), func(ctx context.Context) error {
+		err = retry.Do(ctx, b, func(ctx context.Context) error {
+			req := newRequest(ctx, urlMetric, reportRunAddr, reqBody, bodyBytes, encKey)
+
 			res, err := httpClient.Do(req)
 			if err != nil {
-				if errors.As(err, &netErr) ||
-					netErr.Timeout() ||
-					strings.Contains(err.Error(), "EOF") ||
-					strings.Contains(err.Error(), "connection reset by peer") {
-
+				if isRetryableNetErr(err) {
 					return retry.RetryableError(err)
 				}
 				return err
 			}
 			defer res.Body.Close()
 
-			dec := json.NewDecoder(res.Body)
-			if err := dec.Decode(&respMetric); err != nil {
+			if res.StatusCode == http.StatusTooManyRequests {
+				return b.rateLimited(res)
+			}
+			if res.StatusCode >= http.StatusInternalServerError {
+				return retry.RetryableError(fmt.Errorf("server error: %s", res.Status))
+			}
+			if res.StatusCode < 200 || res.StatusCode >= 300 {
+				return fmt.Errorf("unexpected status: %s", res.Status)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				return fmt.Errorf("read body error, %w", err)
+			}
+
+			if flags.UseHashKey {
+				if err = checkResSign(res, body); err != nil {
+					return err
+				}
+			}
+
+			if err := json.Unmarshal(body, &respMetric); err != nil {
 				return fmt.Errorf("decode body error, %w", err)
 			}
 
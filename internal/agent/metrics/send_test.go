@@ -0,0 +1,384 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/queue"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/spool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendMetricWorkerResponseSign(t *testing.T) {
+	resBody := `{"id":"PollCount","type":"counter","delta":1}`
+
+	flags.FlagHashKey = "testkey"
+	flags.UseHashKey = true
+	defer func() {
+		flags.FlagHashKey = ""
+		flags.UseHashKey = false
+	}()
+
+	goodSign, _ := signReqBody([]byte(resBody))
+
+	tests := []struct {
+		name    string
+		resHash string
+		wantErr string
+	}{
+		{
+			name:    "valid signature",
+			resHash: goodSign,
+		},
+		{
+			name:    "signature mismatch",
+			resHash: strings.Repeat("0", len(goodSign)),
+			wantErr: "response HashSHA256 signature mismatch",
+		},
+		{
+			name:    "signature missing",
+			wantErr: "response HashSHA256 header missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.resHash != "" {
+					w.Header().Set("HashSHA256", test.resHash)
+				}
+				w.Write([]byte(resBody))
+			}))
+			defer srv.Close()
+
+			delta := int64(1)
+			metricsQueue := queue.New(queue.Config{Capacity: 1})
+			chCashMetricsErrors := make(chan error, 1)
+			require.NoError(t, metricsQueue.Put(context.Background(), models.Metric{ID: "PollCount", MType: "counter", Delta: &delta}))
+			metricsQueue.Close()
+
+			SendMetricWorker(context.Background(), 0, metricsQueue, chCashMetricsErrors, *srv.Client(), strings.TrimPrefix(srv.URL, "http://"))
+
+			if test.wantErr == "" {
+				assert.Empty(t, chCashMetricsErrors)
+				return
+			}
+			if assert.Len(t, chCashMetricsErrors, 1) {
+				assert.ErrorContains(t, <-chCashMetricsErrors, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendMetricBatchRealIP(t *testing.T) {
+	chRealIP := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chRealIP <- r.Header.Get("X-Real-IP")
+	}))
+	defer srv.Close()
+
+	delta := int64(1)
+	err := SendMetricBatch(context.Background(), CashMetrics{CashMetrics: []models.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}}},
+		*srv.Client(), strings.TrimPrefix(srv.URL, "http://"))
+	assert.NoError(t, err)
+
+	// к 127.0.0.1 агент ходит с 127.0.0.1
+	assert.Equal(t, "127.0.0.1", <-chRealIP)
+}
+
+func TestOutboundCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lookups := 0
+	c := &outboundCache{
+		entries: make(map[string]outboundEntry),
+		lookup: func(addr string) (string, error) {
+			lookups++
+			if addr == "unreachable:80" {
+				return "", errors.New("no route")
+			}
+			return "10.0.0.1", nil
+		},
+		now: func() time.Time { return now },
+	}
+
+	for i := 0; i < 3; i++ {
+		ip, err := c.get("server:8080")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", ip)
+	}
+	assert.Equal(t, 1, lookups)
+
+	// ошибка тоже запоминается
+	_, err := c.get("unreachable:80")
+	assert.Error(t, err)
+	_, err = c.get("unreachable:80")
+	assert.Error(t, err)
+	assert.Equal(t, 2, lookups)
+
+	now = now.Add(outboundTTL)
+	_, err = c.get("server:8080")
+	require.NoError(t, err)
+	assert.Equal(t, 3, lookups)
+}
+
+func TestSendMetricBatchSpooled(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	var (
+		mu       sync.Mutex
+		down     = true
+		received []int64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var batch []models.Metric
+		if !assert.NoError(t, json.NewDecoder(gz).Decode(&batch)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, *batch[0].Delta)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	dir := t.TempDir()
+	sp, err := spool.Open(dir, spool.Config{})
+	require.NoError(t, err)
+
+	batch := func(i int64) CashMetrics {
+		return CashMetrics{CashMetrics: []models.Metric{{ID: "PollCount", MType: "counter", Delta: &i}}}
+	}
+
+	// сервер недоступен: батчи не теряются, а ложатся в spool
+	for i := int64(1); i <= 3; i++ {
+		assert.NoError(t, SendMetricBatchSpooled(context.Background(), batch(i), sp, *srv.Client(), addr))
+	}
+	assert.False(t, sp.Empty())
+	assert.Empty(t, received)
+
+	// перезапуск агента
+	require.NoError(t, sp.Close())
+	sp, err = spool.Open(dir, spool.Config{})
+	require.NoError(t, err)
+	defer sp.Close()
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+
+	assert.NoError(t, SendMetricBatchSpooled(context.Background(), batch(4), sp, *srv.Client(), addr))
+	assert.Equal(t, []int64{1, 2, 3, 4}, received)
+	assert.True(t, sp.Empty())
+}
+
+func TestSendMetricBatchSpooledStatus(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	var (
+		mu     sync.Mutex
+		status int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	setStatus := func(code int) {
+		mu.Lock()
+		status = code
+		mu.Unlock()
+	}
+
+	sp, err := spool.Open(t.TempDir(), spool.Config{})
+	require.NoError(t, err)
+	defer sp.Close()
+
+	delta := int64(1)
+	batch := CashMetrics{CashMetrics: []models.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}}}
+
+	// в spool попадают только недоставленные батчи: ошибка сети, 429 и 5xx
+	for _, code := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		setStatus(code)
+		err = SendMetricBatchSpooled(context.Background(), batch, sp, *srv.Client(), addr)
+		assert.Error(t, err, code)
+		assert.NotErrorIs(t, err, ErrUndelivered, code)
+		assert.True(t, sp.Empty(), code)
+	}
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		setStatus(code)
+		assert.NoError(t, SendMetricBatchSpooled(context.Background(), batch, sp, *srv.Client(), addr))
+		assert.False(t, sp.Empty(), code)
+	}
+
+	// некорректные батчи пропускаются и считаются отдельно
+	rejected := RejectedBatches()
+	setStatus(http.StatusUnprocessableEntity)
+	assert.NoError(t, SendMetricBatchSpooled(context.Background(), batch, sp, *srv.Client(), addr))
+	assert.True(t, sp.Empty())
+	assert.Equal(t, rejected+3, RejectedBatches())
+
+	setStatus(http.StatusBadRequest)
+	err = SendMetricBatch(context.Background(), batch, *srv.Client(), addr)
+	assert.ErrorIs(t, err, ErrRejected)
+
+	// ошибка сети - тоже недоставленный батч
+	srv.Close()
+	assert.NoError(t, SendMetricBatchSpooled(context.Background(), batch, sp, *srv.Client(), addr))
+	assert.False(t, sp.Empty())
+}
+
+func TestSendMetricBatchSpooledBadSignature(t *testing.T) {
+	flags.FlagHashKey = "testkey"
+	flags.UseHashKey = true
+	defer func() {
+		flags.FlagHashKey = ""
+		flags.UseHashKey = false
+	}()
+
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// ключи агента и сервера разошлись: батч применен, подпись ответа не сходится
+		w.Header().Set("HashSHA256", "bad")
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	sp, err := spool.Open(t.TempDir(), spool.Config{})
+	require.NoError(t, err)
+	defer sp.Close()
+
+	delta := int64(1)
+	batch := CashMetrics{CashMetrics: []models.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}}}
+
+	unverified := UnverifiedResponses()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, SendMetricBatchSpooled(context.Background(), batch, sp, *srv.Client(), addr))
+	}
+
+	// принятый батч не попадает в spool и не отправляется повторно
+	assert.True(t, sp.Empty())
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, unverified+2, UnverifiedResponses())
+}
+
+func TestSendMetricBatchRetryAfter(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	delta := int64(1)
+	batch := CashMetrics{CashMetrics: []models.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}}}
+
+	start := time.Now()
+	assert.NoError(t, SendMetricBatch(context.Background(), batch, *srv.Client(), addr))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "waits for Retry-After")
+	assert.Equal(t, 2, attempts)
+
+	// ожидание ограничено ctx
+	mu.Lock()
+	attempts = 0
+	mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.Error(t, SendMetricBatch(ctx, batch, *srv.Client(), addr))
+	assert.Less(t, time.Since(start), 900*time.Millisecond)
+}
+
+func TestSendMetricWorkerRetrySign(t *testing.T) {
+	retryBackoff = time.Millisecond
+	flags.FlagHashKey = "testkey"
+	flags.UseHashKey = true
+	defer func() {
+		retryBackoff = time.Second
+		flags.FlagHashKey = ""
+		flags.UseHashKey = false
+	}()
+
+	resBody := `{"id":"PollCount","type":"counter","delta":1}`
+	resSign, _ := signReqBody([]byte(resBody))
+
+	var (
+		mu     sync.Mutex
+		nonces []string
+		sizes  []int64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		nonces = append(nonces, r.Header.Get("HashSHA256-Nonce"))
+		n, _ := io.Copy(io.Discard, r.Body)
+		sizes = append(sizes, n)
+		if len(nonces) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("HashSHA256", resSign)
+		w.Write([]byte(resBody))
+	}))
+	defer srv.Close()
+
+	delta := int64(1)
+	metricsQueue := queue.New(queue.Config{Capacity: 1})
+	chCashMetricsErrors := make(chan error, 1)
+	require.NoError(t, metricsQueue.Put(context.Background(), models.Metric{ID: "PollCount", MType: "counter", Delta: &delta}))
+	metricsQueue.Close()
+
+	SendMetricWorker(context.Background(), 0, metricsQueue, chCashMetricsErrors, *srv.Client(), strings.TrimPrefix(srv.URL, "http://"))
+	assert.Empty(t, chCashMetricsErrors)
+
+	// повтор - новый запрос: тело целиком и свежий nonce
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1])
+	assert.Equal(t, sizes[0], sizes[1])
+	assert.Greater(t, sizes[1], int64(0))
+}
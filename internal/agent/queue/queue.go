@@ -0,0 +1,272 @@
+// Package queue ограниченная очередь метрик агента с явной политикой переполнения
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"sync"
+)
+
+var ErrClosed = errors.New("queue closed")
+
+// Policy что делать, если очередь заполнена
+type Policy string
+
+const (
+	// Block Put ждет, пока воркеры освободят место
+	Block Policy = "block"
+	// DropOldest вытесняет самую старую метрику
+	DropOldest Policy = "drop-oldest"
+	// DropNewest отбрасывает новую метрику
+	DropNewest Policy = "drop-newest"
+	// Coalesce новое значение gauge заменяет ожидающее с тем же именем, delta counter прибавляется к ожидающей.
+	// Пока имена повторяются, очередь не растет; если заполнена новыми именами - Put ждет, как Block
+	Coalesce Policy = "coalesce"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case Block, DropOldest, DropNewest, Coalesce:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown queue policy %q, want block, drop-oldest, drop-newest or coalesce", s)
+}
+
+// Config параметры очереди
+type Config struct {
+	Capacity int
+	Policy   Policy
+	// OnDrop вызывается для каждой отброшенной метрики, можно сохранить ее в другом месте
+	OnDrop func(models.Metric)
+}
+
+// Stats счетчики очереди
+type Stats struct {
+	Len       int
+	Dropped   int64 // отброшено при переполнении
+	Coalesced int64 // объединено с ожидающей метрикой того же имени
+}
+
+// Queue FIFO метрик. Методы безопасны для вызова из нескольких горутин
+type Queue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	cfg       Config
+	items     []models.Metric
+	head      int
+	index     map[string]int // ключ -> позиция в items, только для Coalesce
+	closed    bool
+	dropped   int64
+	coalesced int64
+}
+
+func New(cfg Config) *Queue {
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = 1
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = Block
+	}
+
+	q := &Queue{cfg: cfg}
+	q.cond = sync.NewCond(&q.mu)
+	if cfg.Policy == Coalesce {
+		q.index = make(map[string]int, cfg.Capacity)
+	}
+
+	return q
+}
+
+func key(m models.Metric) string {
+	return m.MType + ":" + m.ID
+}
+
+// Len число ожидающих метрик
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items) - q.head
+}
+
+// Stats снимок счетчиков
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return Stats{Len: len(q.items) - q.head, Dropped: q.dropped, Coalesced: q.coalesced}
+}
+
+// wait ждет сигнала cond, пока ctx не отменен. Вызывается под q.mu
+func (q *Queue) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.cond.Broadcast()
+	})
+	q.cond.Wait()
+	stop()
+
+	return ctx.Err()
+}
+
+// Put добавляет метрику по политике очереди. Ошибка - только если очередь закрыта
+// или ctx отменен во время ожидания (Block, Coalesce)
+func (q *Queue) Put(ctx context.Context, m models.Metric) error {
+	q.mu.Lock()
+
+	if q.cfg.Policy == Coalesce {
+		if i, ok := q.index[key(m)]; ok {
+			q.merge(i, m)
+			q.coalesced++
+			q.mu.Unlock()
+			return nil
+		}
+	}
+
+	var dropped []models.Metric
+	for !q.closed && len(q.items)-q.head >= q.cfg.Capacity {
+		switch q.cfg.Policy {
+		case DropNewest:
+			q.dropped++
+			q.mu.Unlock()
+			q.drop(m)
+			return nil
+		case DropOldest:
+			dropped = append(dropped, q.pop())
+			q.dropped++
+		default:
+			if err := q.wait(ctx); err != nil {
+				q.mu.Unlock()
+				return err
+			}
+			// пока ждали, метрика с тем же именем могла появиться снова
+			if i, ok := q.index[key(m)]; ok && q.cfg.Policy == Coalesce {
+				q.merge(i, m)
+				q.coalesced++
+				q.mu.Unlock()
+				return nil
+			}
+		}
+	}
+	if q.closed {
+		q.mu.Unlock()
+		return ErrClosed
+	}
+
+	if q.index != nil {
+		q.index[key(m)] = len(q.items)
+	}
+	q.items = append(q.items, m)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	for _, v := range dropped {
+		q.drop(v)
+	}
+
+	return nil
+}
+
+func (q *Queue) drop(m models.Metric) {
+	if q.cfg.OnDrop != nil {
+		q.cfg.OnDrop(m)
+	}
+}
+
+// merge объединяет m с ожидающей метрикой items[i]. Вызывается под q.mu
+func (q *Queue) merge(i int, m models.Metric) {
+	pending := &q.items[i]
+	if m.MType == "counter" && pending.Delta != nil && m.Delta != nil {
+		sum := *pending.Delta + *m.Delta
+		pending.Delta = &sum
+		return
+	}
+	pending.Delta = m.Delta
+	pending.Value = m.Value
+}
+
+// pop забирает первую метрику. Вызывается под q.mu, очередь не пуста
+func (q *Queue) pop() models.Metric {
+	m := q.items[q.head]
+	q.items[q.head] = models.Metric{}
+	q.head++
+	if q.index != nil {
+		delete(q.index, key(m))
+	}
+
+	// сдвигаем, когда прочитана половина, чтобы items не росли бесконечно
+	if q.head > len(q.items)/2 {
+		n := copy(q.items, q.items[q.head:])
+		q.items = q.items[:n]
+		q.head = 0
+		if q.index != nil {
+			for i, v := range q.items {
+				q.index[key(v)] = i
+			}
+		}
+	}
+
+	return m
+}
+
+// Get ждет и забирает первую метрику. false - очередь закрыта и пуста или ctx отменен
+func (q *Queue) Get(ctx context.Context) (models.Metric, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == q.head {
+		if q.closed {
+			return models.Metric{}, false
+		}
+		if err := q.wait(ctx); err != nil {
+			return models.Metric{}, false
+		}
+	}
+
+	m := q.pop()
+	q.cond.Broadcast()
+	return m, true
+}
+
+// Drain ждет хотя бы одну метрику и забирает все ожидающие, не больше max (0 - все).
+// Пустой результат - очередь закрыта и пуста или ctx отменен
+func (q *Queue) Drain(ctx context.Context, max int) []models.Metric {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == q.head {
+		if q.closed {
+			return nil
+		}
+		if err := q.wait(ctx); err != nil {
+			return nil
+		}
+	}
+
+	n := len(q.items) - q.head
+	if max > 0 && n > max {
+		n = max
+	}
+	result := make([]models.Metric, 0, n)
+	for i := 0; i < n; i++ {
+		result = append(result, q.pop())
+	}
+	q.cond.Broadcast()
+
+	return result
+}
+
+// Close будит ожидающих: Put возвращает ошибку, Get и Drain отдают оставшиеся метрики
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,164 @@
+package queue
+
+import (
+	"context"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/agent/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func gauge(id string, v float64) models.Metric {
+	return models.Metric{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) models.Metric {
+	return models.Metric{ID: id, MType: "counter", Delta: &d}
+}
+
+func values(list []models.Metric) []float64 {
+	result := make([]float64, 0, len(list))
+	for _, m := range list {
+		result = append(result, *m.Value)
+	}
+	return result
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"block", "drop-oldest", "drop-newest", "coalesce"} {
+		p, err := ParsePolicy(s)
+		assert.NoError(t, err)
+		assert.Equal(t, Policy(s), p)
+	}
+	_, err := ParsePolicy("random")
+	assert.Error(t, err)
+}
+
+func TestBlock(t *testing.T) {
+	ctx := context.Background()
+	q := New(Config{Capacity: 2, Policy: Block})
+
+	require.NoError(t, q.Put(ctx, gauge("a", 1)))
+	require.NoError(t, q.Put(ctx, gauge("b", 2)))
+
+	done := make(chan error, 1)
+	go func() { done <- q.Put(ctx, gauge("c", 3)) }()
+
+	select {
+	case <-done:
+		t.Fatal("Put did not block on full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m, ok := q.Get(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "a", m.ID)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []float64{2, 3}, values(q.Drain(ctx, 0)))
+	assert.Equal(t, int64(0), q.Stats().Dropped)
+
+	// отмена контекста прерывает ожидание
+	require.NoError(t, q.Put(ctx, gauge("a", 1)))
+	require.NoError(t, q.Put(ctx, gauge("b", 2)))
+	cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Put(cancelCtx, gauge("c", 3)), context.DeadlineExceeded)
+
+	q.Close()
+	assert.ErrorIs(t, q.Put(ctx, gauge("d", 4)), ErrClosed)
+}
+
+func TestDropOldest(t *testing.T) {
+	ctx := context.Background()
+
+	var dropped []models.Metric
+	q := New(Config{Capacity: 3, Policy: DropOldest, OnDrop: func(m models.Metric) { dropped = append(dropped, m) }})
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, q.Put(ctx, gauge("g", float64(i))))
+	}
+
+	assert.Equal(t, Stats{Len: 3, Dropped: 2}, q.Stats())
+	assert.Equal(t, []float64{1, 2}, values(dropped))
+	assert.Equal(t, []float64{3, 4, 5}, values(q.Drain(ctx, 0)))
+}
+
+func TestDropNewest(t *testing.T) {
+	ctx := context.Background()
+
+	var dropped []models.Metric
+	q := New(Config{Capacity: 3, Policy: DropNewest, OnDrop: func(m models.Metric) { dropped = append(dropped, m) }})
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, q.Put(ctx, gauge("g", float64(i))))
+	}
+
+	assert.Equal(t, Stats{Len: 3, Dropped: 2}, q.Stats())
+	assert.Equal(t, []float64{4, 5}, values(dropped))
+	assert.Equal(t, []float64{1, 2, 3}, values(q.Drain(ctx, 0)))
+}
+
+func TestCoalesce(t *testing.T) {
+	ctx := context.Background()
+	q := New(Config{Capacity: 3, Policy: Coalesce})
+
+	// десять опросов одних и тех же метрик занимают три места
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, q.Put(ctx, gauge("Alloc", float64(i))))
+		require.NoError(t, q.Put(ctx, gauge("Sys", float64(i*10))))
+		require.NoError(t, q.Put(ctx, counter("PollCount", 1)))
+	}
+
+	stats := q.Stats()
+	assert.Equal(t, 3, stats.Len)
+	assert.Equal(t, int64(27), stats.Coalesced)
+	assert.Equal(t, int64(0), stats.Dropped)
+
+	got := q.Drain(ctx, 0)
+	require.Len(t, got, 3)
+	assert.Equal(t, "Alloc", got[0].ID)
+	assert.Equal(t, 10.0, *got[0].Value)
+	assert.Equal(t, 100.0, *got[1].Value)
+	// delta counter не теряются, а суммируются
+	assert.Equal(t, int64(10), *got[2].Delta)
+
+	// после выборки то же имя снова занимает место
+	require.NoError(t, q.Put(ctx, gauge("Alloc", 11)))
+	m, ok := q.Get(ctx)
+	require.True(t, ok)
+	assert.Equal(t, 11.0, *m.Value)
+
+	// заполнена новыми именами - ждет, как Block
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, q.Put(ctx, gauge(id, 1)))
+	}
+	cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Put(cancelCtx, gauge("d", 1)), context.DeadlineExceeded)
+	assert.NoError(t, q.Put(cancelCtx, gauge("a", 2)))
+}
+
+func TestGetAfterClose(t *testing.T) {
+	ctx := context.Background()
+	q := New(Config{Capacity: 2})
+
+	require.NoError(t, q.Put(ctx, gauge("a", 1)))
+
+	done := make(chan []models.Metric)
+	go func() {
+		var got []models.Metric
+		for {
+			m, ok := q.Get(ctx)
+			if !ok {
+				done <- got
+				return
+			}
+			got = append(got, m)
+		}
+	}()
+
+	q.Close()
+	assert.Len(t, <-done, 1)
+}
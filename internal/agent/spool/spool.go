@@ -0,0 +1,504 @@
+// Package spool дисковая очередь неотправленных батчей агента.
+// Записи дописываются в сегменты (файлы NNNNNNNNNNNNNNNNNNNN.seg), позиция чтения хранится в файле offset,
+// поэтому очередь переживает перезапуск агента и отдается в порядке записи.
+// Размер и возраст ограничены: при переполнении удаляются самые старые сегменты
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+	offsetFile = "offset"
+	// headerSize длина (4), crc32 (4), время записи в наносекундах (8)
+	headerSize = 16
+
+	DefaultSegmentSize = 1 << 20
+)
+
+var (
+	ErrTooLarge = errors.New("spool: record larger than spool size")
+	errCorrupt  = errors.New("spool: corrupt record")
+)
+
+// Config ограничения очереди
+type Config struct {
+	// MaxSize суммарный размер сегментов в байтах, 0 - без ограничения
+	MaxSize int64
+	// MaxAge сколько хранить запись, 0 - без ограничения. Устаревшие записи пропускаются при чтении
+	MaxAge time.Duration
+	// SegmentSize после какого размера начинать новый сегмент, 0 - DefaultSegmentSize (но не больше MaxSize/4)
+	SegmentSize int64
+}
+
+type segment struct {
+	seq  int64
+	size int64
+}
+
+// Stats состояние очереди
+type Stats struct {
+	Segments int
+	Bytes    int64
+	// Dropped сколько записей удалено из-за ограничений размера и возраста
+	Dropped int64
+}
+
+// Spool очередь записей в каталоге dir. Методы безопасны для вызова из нескольких горутин
+type Spool struct {
+	mu       sync.Mutex
+	dir      string
+	cfg      Config
+	segments []segment // по возрастанию seq, последний - активный, в него идет запись
+	active   *os.File
+	readSeq  int64
+	readPos  int64
+	dropped  int64
+	now      func() time.Time
+}
+
+// Open открывает очередь в dir, создавая каталог при необходимости.
+// Недописанная при аварийной остановке запись в конце последнего сегмента отбрасывается
+func Open(dir string, cfg Config) (*Spool, error) {
+	if dir == "" {
+		return nil, errors.New("spool: empty dir")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+		if cfg.MaxSize > 0 && cfg.SegmentSize > cfg.MaxSize/4 {
+			cfg.SegmentSize = cfg.MaxSize / 4
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+
+	s := &Spool{dir: dir, cfg: cfg, now: time.Now}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("spool: %w", err)
+		}
+		s.segments = append(s.segments, segment{seq: seq, size: info.Size()})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if err = s.loadOffset(); err != nil {
+		return nil, err
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, segment{seq: 1})
+	}
+	if err = s.repairActive(); err != nil {
+		return nil, err
+	}
+
+	last := s.segments[len(s.segments)-1]
+	s.active, err = os.OpenFile(s.segmentPath(last.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+
+	if s.readSeq < s.segments[0].seq || s.readSeq > last.seq {
+		s.readSeq, s.readPos = s.segments[0].seq, 0
+	}
+
+	return s, nil
+}
+
+func (s *Spool) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// loadOffset позиция чтения: "<seq> <pos>"
+func (s *Spool) loadOffset() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, offsetFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+
+	if _, err = fmt.Sscanf(string(data), "%d %d", &s.readSeq, &s.readPos); err != nil {
+		log.Info().Err(err).Str("dir", s.dir).Msg("spool offset file ignored")
+		s.readSeq, s.readPos = 0, 0
+	}
+
+	return nil
+}
+
+// saveOffset атомарно сохраняет позицию чтения
+func (s *Spool) saveOffset() error {
+	path := filepath.Join(s.dir, offsetFile)
+
+	tmp, err := os.CreateTemp(s.dir, offsetFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = fmt.Fprintf(tmp, "%d %d\n", s.readSeq, s.readPos); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(s.dir)
+	return nil
+}
+
+// syncDir сбрасывает на диск записи каталога (созданные и переименованные файлы).
+// Не на всех системах каталог можно синхронизировать, поэтому ошибка не возвращается
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	if err = d.Sync(); err != nil {
+		log.Info().Err(err).Str("dir", dir).Msg("spool sync dir")
+	}
+	d.Close()
+}
+
+// repairActive обрезает последний сегмент по последней целой записи
+func (s *Spool) repairActive() error {
+	last := &s.segments[len(s.segments)-1]
+	if last.size == 0 {
+		return nil
+	}
+
+	f, err := os.Open(s.segmentPath(last.seq))
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	defer f.Close()
+
+	var pos int64
+	for pos < last.size {
+		_, _, n, err := readRecord(f, pos, last.size)
+		if err != nil {
+			break
+		}
+		pos += n
+	}
+	if pos == last.size {
+		return nil
+	}
+
+	log.Info().Int64("segment", last.seq).Int64("size", last.size).Int64("truncated", pos).
+		Msg("spool torn record truncated")
+	if err = os.Truncate(s.segmentPath(last.seq), pos); err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	last.size = pos
+
+	return nil
+}
+
+// readRecord читает запись с позиции pos сегмента длиной end, возвращает данные, время записи
+// и полную длину записи. Длина из заголовка, выходящая за сегмент, - испорченная запись:
+// буфер под нее не выделяется
+func readRecord(r io.ReaderAt, pos, end int64) ([]byte, time.Time, int64, error) {
+	if end-pos < headerSize {
+		return nil, time.Time{}, 0, errCorrupt
+	}
+	var header [headerSize]byte
+	if _, err := r.ReadAt(header[:], pos); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, time.Time{}, 0, errCorrupt
+		}
+		return nil, time.Time{}, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if int64(size) > end-pos-headerSize {
+		return nil, time.Time{}, 0, errCorrupt
+	}
+
+	data := make([]byte, 8+int64(size))
+	copy(data, header[8:16])
+	if _, err := r.ReadAt(data[8:], pos+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, time.Time{}, 0, errCorrupt
+		}
+		return nil, time.Time{}, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, time.Time{}, 0, errCorrupt
+	}
+
+	written := time.Unix(0, int64(binary.BigEndian.Uint64(data[0:8])))
+	return data[8:], written, headerSize + int64(size), nil
+}
+
+func encodeRecord(data []byte, written time.Time) []byte {
+	rec := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(rec[8:16], uint64(written.UnixNano()))
+	copy(rec[16:], data)
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(rec[8:]))
+	return rec
+}
+
+// Append дописывает запись в конец очереди. Если не хватает места, удаляются самые старые сегменты
+func (s *Spool) Append(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := encodeRecord(data, s.now())
+	recSize := int64(len(rec))
+	if s.cfg.MaxSize > 0 && recSize > s.cfg.MaxSize {
+		return ErrTooLarge
+	}
+
+	if last := s.segments[len(s.segments)-1]; last.size > 0 && last.size+recSize > s.cfg.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.MaxSize > 0 {
+		for s.totalSize()+recSize > s.cfg.MaxSize && len(s.segments) > 1 {
+			if err := s.dropOldest("size limit"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := s.active.Write(rec); err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	s.segments[len(s.segments)-1].size += recSize
+
+	return nil
+}
+
+func (s *Spool) totalSize() int64 {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// rotate закрывает активный сегмент и начинает следующий
+func (s *Spool) rotate() error {
+	seq := s.segments[len(s.segments)-1].seq + 1
+
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	syncDir(s.dir)
+	// закрытый сегмент больше не меняется, его записи должны пережить сбой питания
+	if err = s.active.Sync(); err != nil {
+		log.Info().Err(err).Msg("spool sync segment")
+	}
+	if err = s.active.Close(); err != nil {
+		log.Info().Err(err).Msg("spool close segment")
+	}
+
+	s.active = f
+	s.segments = append(s.segments, segment{seq: seq})
+
+	return nil
+}
+
+// dropOldest удаляет первый (не активный) сегмент вместе с непрочитанными записями
+func (s *Spool) dropOldest(reason string) error {
+	seg := s.segments[0]
+
+	if seg.seq >= s.readSeq {
+		var pos int64
+		if seg.seq == s.readSeq {
+			pos = s.readPos
+		}
+		s.dropped += s.countRecords(seg, pos)
+	}
+
+	if err := os.Remove(s.segmentPath(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("spool: %w", err)
+	}
+	s.segments = s.segments[1:]
+
+	log.Info().Int64("segment", seg.seq).Int64("size", seg.size).Str("reason", reason).Msg("spool segment dropped")
+
+	if s.readSeq <= seg.seq {
+		s.readSeq, s.readPos = s.segments[0].seq, 0
+		return s.saveOffset()
+	}
+	return nil
+}
+
+// countRecords число записей сегмента начиная с pos, для статистики
+func (s *Spool) countRecords(seg segment, pos int64) int64 {
+	f, err := os.Open(s.segmentPath(seg.seq))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var count int64
+	for pos < seg.size {
+		_, _, n, err := readRecord(f, pos, seg.size)
+		if err != nil {
+			break
+		}
+		pos += n
+		count++
+	}
+	return count
+}
+
+// next следующая непрочитанная запись и позиция после нее. ok == false - очередь пуста.
+// Прочитанные до конца сегменты удаляются, испорченные записи и сегменты пропускаются
+func (s *Spool) next() (data []byte, seq, pos int64, ok bool, err error) {
+	for {
+		last := s.segments[len(s.segments)-1]
+		if s.readSeq == last.seq && s.readPos >= last.size {
+			return nil, 0, 0, false, nil
+		}
+
+		seg := s.segments[0]
+		if seg.seq > s.readSeq {
+			// сегмента с позицией чтения уже нет
+			s.readSeq, s.readPos = seg.seq, 0
+		}
+		if s.readPos >= seg.size || seg.seq < s.readSeq {
+			// сегмент прочитан
+			if err = os.Remove(s.segmentPath(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, 0, 0, false, fmt.Errorf("spool: %w", err)
+			}
+			s.segments = s.segments[1:]
+			if s.readSeq <= seg.seq {
+				s.readSeq, s.readPos = s.segments[0].seq, 0
+			}
+			if err = s.saveOffset(); err != nil {
+				return nil, 0, 0, false, fmt.Errorf("spool: %w", err)
+			}
+			continue
+		}
+
+		f, err := os.Open(s.segmentPath(seg.seq))
+		if err != nil {
+			return nil, 0, 0, false, fmt.Errorf("spool: %w", err)
+		}
+		data, written, n, err := readRecord(f, s.readPos, seg.size)
+		f.Close()
+
+		if errors.Is(err, errCorrupt) {
+			log.Info().Int64("segment", seg.seq).Int64("pos", s.readPos).Msg("spool corrupt record, segment tail skipped")
+			s.readPos = seg.size
+			continue
+		}
+		if err != nil {
+			return nil, 0, 0, false, fmt.Errorf("spool: %w", err)
+		}
+
+		if s.cfg.MaxAge > 0 && s.now().Sub(written) > s.cfg.MaxAge {
+			s.dropped++
+			s.readPos += n
+			continue
+		}
+
+		return data, seg.seq, s.readPos + n, true, nil
+	}
+}
+
+// Replay передает записи в fn по порядку, начиная с самой старой.
+// Запись считается отправленной, когда fn вернула nil. При ошибке fn чтение останавливается,
+// запись остается в очереди и будет передана первой при следующем вызове
+func (s *Spool) Replay(fn func(data []byte) error) (int, error) {
+	var count int
+
+	for {
+		s.mu.Lock()
+		data, seq, pos, ok, err := s.next()
+		s.mu.Unlock()
+		if err != nil || !ok {
+			return count, err
+		}
+
+		if err = fn(data); err != nil {
+			return count, err
+		}
+
+		s.mu.Lock()
+		if s.readSeq == seq {
+			s.readPos = pos
+			err = s.saveOffset()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return count, fmt.Errorf("spool: %w", err)
+		}
+		count++
+	}
+}
+
+// Empty нет непрочитанных записей (устаревшие записи не учитываются до Replay)
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.segments[len(s.segments)-1]
+	return s.readSeq == last.seq && s.readPos >= last.size
+}
+
+// Stats размер очереди на диске и число удаленных записей
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{Segments: len(s.segments), Bytes: s.totalSize(), Dropped: s.dropped}
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.active.Sync(); err != nil {
+		s.active.Close()
+		return err
+	}
+	return s.active.Close()
+}
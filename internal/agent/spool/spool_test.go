@@ -0,0 +1,164 @@
+package spool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func replayAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+
+	var got []string
+	_, err := s.Replay(func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	require.NoError(t, err)
+	return got
+}
+
+func TestSpoolOrderAndRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Config{SegmentSize: 64})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Append([]byte(fmt.Sprintf("batch-%d", i))))
+	}
+	assert.Greater(t, s.Stats().Segments, 1)
+
+	// первая попытка отправки: две записи прошли, третья - сервер недоступен
+	sent := 0
+	n, err := s.Replay(func(data []byte) error {
+		if sent == 2 {
+			return errors.New("connection refused")
+		}
+		sent++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	require.NoError(t, s.Close())
+
+	// после перезапуска очередь продолжается с третьей записи
+	s, err = Open(dir, Config{SegmentSize: 64})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.False(t, s.Empty())
+	require.NoError(t, s.Append([]byte("batch-10")))
+
+	want := make([]string, 0, 9)
+	for i := 2; i <= 10; i++ {
+		want = append(want, fmt.Sprintf("batch-%d", i))
+	}
+	assert.Equal(t, want, replayAll(t, s))
+	assert.True(t, s.Empty())
+	assert.Equal(t, 1, s.Stats().Segments)
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	s, err := Open(t.TempDir(), Config{MaxSize: 200, SegmentSize: 50})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// запись 16 байт заголовка + 8 байт данных, в сегменте по две
+	for i := 0; i < 20; i++ {
+		require.NoError(t, s.Append([]byte(fmt.Sprintf("batch-%02d", i))))
+	}
+
+	stats := s.Stats()
+	assert.LessOrEqual(t, stats.Bytes, int64(200))
+	assert.Equal(t, int64(12), stats.Dropped)
+
+	got := replayAll(t, s)
+	assert.Equal(t, []string{"batch-12", "batch-13", "batch-14", "batch-15", "batch-16", "batch-17", "batch-18", "batch-19"}, got)
+
+	assert.ErrorIs(t, s.Append(make([]byte, 200)), ErrTooLarge)
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	now := time.Now()
+
+	s, err := Open(t.TempDir(), Config{MaxAge: time.Hour})
+	require.NoError(t, err)
+	defer s.Close()
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Append([]byte("old")))
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, s.Append([]byte("fresh")))
+	now = now.Add(45 * time.Minute)
+
+	assert.Equal(t, []string{"fresh"}, replayAll(t, s))
+	assert.Equal(t, int64(1), s.Stats().Dropped)
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, s.Append([]byte("first")))
+	require.NoError(t, s.Append([]byte("second")))
+	require.NoError(t, s.Close())
+
+	// агент упал посреди записи второго батча
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", 1, segmentExt))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	s, err = Open(dir, Config{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Append([]byte("third")))
+	assert.Equal(t, []string{"first", "third"}, replayAll(t, s))
+}
+
+func TestReadRecordSizeBeyondSegment(t *testing.T) {
+	rec := encodeRecord([]byte("batch"), time.Now())
+	_, _, n, err := readRecord(bytes.NewReader(rec), 0, int64(len(rec)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(rec)), n)
+
+	// испорченная длина в заголовке не должна приводить к выделению 4GiB
+	binary.BigEndian.PutUint32(rec[0:4], 0xFFFFFFFF)
+	_, _, _, err = readRecord(bytes.NewReader(rec), 0, int64(len(rec)))
+	assert.ErrorIs(t, err, errCorrupt)
+
+	_, _, _, err = readRecord(bytes.NewReader(rec), 0, headerSize-1)
+	assert.ErrorIs(t, err, errCorrupt)
+}
+
+func TestSpoolCorruptSize(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, s.Append([]byte("first")))
+	require.NoError(t, s.Append([]byte("second")))
+	require.NoError(t, s.Close())
+
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", 1, segmentExt))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	binary.BigEndian.PutUint32(data[headerSize+len("first"):], 0xFFFFFFF0)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	s, err = Open(dir, Config{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, []string{"first"}, replayAll(t, s))
+}
@@ -0,0 +1,148 @@
+// Package encryption гибридное шифрование тела запроса агент -> сервер.
+// Тело шифруется AES-256-GCM случайным ключом, ключ шифруется RSA-OAEP (SHA-256)
+// публичным ключом сервера и передается в заголовке HeaderEncryptedKey (base64)
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// HeaderEncryptedKey заголовок с зашифрованным RSA ключом AES
+const HeaderEncryptedKey = "X-Encrypted-Key"
+
+const aesKeySize = 32
+
+// Encrypt шифрует data: возвращает зашифрованный ключ и nonce||ciphertext
+func Encrypt(pub *rsa.PublicKey, data []byte) ([]byte, []byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("rand.Read: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("rand.Read: %w", err)
+	}
+
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rsa.EncryptOAEP: %w", err)
+	}
+
+	return encKey, gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypt расшифровывает результат Encrypt
+func Decrypt(priv *rsa.PrivateKey, encKey []byte, data []byte) ([]byte, error) {
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, encKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.DecryptOAEP: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	result, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("gcm.Open: %w", err)
+	}
+
+	return result, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+
+	return gcm, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	return block, nil
+}
+
+// LoadPublicKey читает публичный RSA ключ из PEM (PKIX "PUBLIC KEY" или PKCS#1 "RSA PUBLIC KEY")
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKIXPublicKey: %w", err)
+	}
+
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA public key", path)
+	}
+
+	return pub, nil
+}
+
+// LoadPrivateKey читает приватный RSA ключ из PEM (PKCS#8 "PRIVATE KEY" или PKCS#1 "RSA PRIVATE KEY")
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey: %w", err)
+	}
+
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA private key", path)
+	}
+
+	return priv, nil
+}
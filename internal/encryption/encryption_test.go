@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600))
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	data := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+
+	encKey, ciphertext, err := Encrypt(&priv.PublicKey, data)
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "PollCount")
+
+	result, err := Decrypt(priv, encKey, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, data, result)
+
+	// чужой ключ
+	_, err = Decrypt(otherPriv, encKey, ciphertext)
+	assert.Error(t, err)
+
+	// измененный шифротекст
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Decrypt(priv, encKey, tampered)
+	assert.Error(t, err)
+
+	_, err = Decrypt(priv, encKey, ciphertext[:4])
+	assert.Error(t, err)
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pkix, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, "private.pem"), "PRIVATE KEY", pkcs8)
+	writePEM(t, filepath.Join(dir, "private-pkcs1.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv))
+	writePEM(t, filepath.Join(dir, "public.pem"), "PUBLIC KEY", pkix)
+	writePEM(t, filepath.Join(dir, "public-pkcs1.pem"), "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&priv.PublicKey))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.pem"), []byte("not a pem"), 0600))
+
+	for _, name := range []string{"private.pem", "private-pkcs1.pem"} {
+		loaded, err := LoadPrivateKey(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+		assert.True(t, priv.Equal(loaded), name)
+	}
+
+	for _, name := range []string{"public.pem", "public-pkcs1.pem"} {
+		loaded, err := LoadPublicKey(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+		assert.True(t, priv.PublicKey.Equal(loaded), name)
+	}
+
+	_, err = LoadPublicKey(filepath.Join(dir, "garbage.pem"))
+	assert.Error(t, err)
+	_, err = LoadPrivateKey(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
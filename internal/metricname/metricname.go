@@ -0,0 +1,34 @@
+// Package metricname правила имен метрик, общие для агента и сервера:
+// агент проверяет по ним имена из внешних источников до отправки, сервер - при приеме
+package metricname
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxLen максимальная длина имени метрики, в БД сервера mname varchar(40)
+const MaxLen = 40
+
+// Validate проверяет имя метрики: 1..MaxLen символов из латинских букв, цифр и "_.:-",
+// первый символ - буква или "_"
+func Validate(name string) error {
+	if name == "" {
+		return errors.New("empty metric name")
+	}
+	if len(name) > MaxLen {
+		return fmt.Errorf("metric name %q longer than %d characters", name, MaxLen)
+	}
+
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.' || c == ':' || c == '-'):
+		default:
+			return fmt.Errorf("metric name %q: invalid character %q at %d "+
+				"(allowed: latin letters, digits, _ . : -, first - letter or _)", name, c, i)
+		}
+	}
+
+	return nil
+}
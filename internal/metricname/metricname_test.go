@@ -0,0 +1,17 @@
+package metricname
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"Alloc", "CPUutilization1", "_private", "host1.disk:sda-read", strings.Repeat("a", MaxLen)} {
+		assert.NoError(t, Validate(name), name)
+	}
+
+	for _, name := range []string{"", "1st", ".hidden", "with space", "юникод", "a/b", "a%2Fb", strings.Repeat("a", MaxLen+1)} {
+		assert.Error(t, Validate(name), name)
+	}
+}
@@ -0,0 +1,256 @@
+// Package cardinality ограничение числа различных серий метрик (тип + имя):
+// общее на сервер и на каждый источник (токен или адрес агента)
+package cardinality
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LimitError серия не принята из-за ограничения
+type LimitError struct {
+	Source string // пустой - общее ограничение
+	Limit  int
+	Series string
+}
+
+func (e *LimitError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("series limit reached: server already stores %d distinct series, new series %s rejected",
+			e.Limit, e.Series)
+	}
+	return fmt.Sprintf("series limit reached: source %s already wrote %d distinct series, new series %s rejected",
+		e.Source, e.Limit, e.Series)
+}
+
+// Series ключ серии
+func Series(mType, name string) string {
+	return mType + ":" + name
+}
+
+const (
+	// DefaultSourceTTL через сколько забывается источник, не писавший метрик
+	DefaultSourceTTL = 24 * time.Hour
+	// DefaultMaxSources сколько источников помнить, при переполнении забывается самый давний
+	DefaultMaxSources = 10000
+)
+
+// seriesSet серии: true - записаны в хранилище, false - приняты Admit и ждут записи
+type seriesSet map[string]bool
+
+// sourceSeries серии одного источника
+type sourceSeries struct {
+	series   seriesSet
+	lastSeen time.Time
+}
+
+// Limiter учет серий. Серии только добавляются: удаления метрик в хранилище нет.
+// Источники, давно не писавшие метрик, забываются: их серии остаются в общем учете,
+// а ограничение на источник начинается заново
+type Limiter struct {
+	mu           sync.Mutex
+	maxSeries    int // 0 - без ограничения
+	maxPerSource int // 0 - без ограничения
+	sourceTTL    time.Duration
+	maxSources   int
+	now          func() time.Time
+	lastExpire   time.Time
+	series       seriesSet
+	sources      map[string]*sourceSeries
+}
+
+func New(maxSeries, maxPerSource int) *Limiter {
+	return &Limiter{
+		maxSeries:    maxSeries,
+		maxPerSource: maxPerSource,
+		sourceTTL:    DefaultSourceTTL,
+		maxSources:   DefaultMaxSources,
+		now:          time.Now,
+		series:       make(seriesSet),
+		sources:      make(map[string]*sourceSeries),
+	}
+}
+
+// Seed учитывает серии, уже лежащие в хранилище, без привязки к источнику
+func (l *Limiter) Seed(series ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range series {
+		l.series[s] = true
+	}
+}
+
+// Reservation серии, принятые Admit. После записи в хранилище вызывается Commit,
+// при ошибке записи - Cancel, чтобы незаписанные серии не расходовали ограничения
+type Reservation struct {
+	l      *Limiter
+	source string
+	series []string
+	// серии, добавленные этим Admit
+	newGlobal, newOwned []string
+}
+
+// Admit принимает серии источника целиком или не принимает ни одной.
+// Уже известные серии ограничения не расходуют
+func (l *Limiter) Admit(source string, series ...string) (*Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.expire(now)
+	owned := l.sources[source]
+	var ownedSeries seriesSet
+	if owned != nil {
+		ownedSeries = owned.series
+	}
+
+	res := &Reservation{l: l, source: source}
+	seen := make(map[string]struct{}, len(series))
+	for _, s := range series {
+		if _, dup := seen[s]; dup {
+			continue
+		}
+		seen[s] = struct{}{}
+		res.series = append(res.series, s)
+
+		if _, ok := l.series[s]; !ok {
+			res.newGlobal = append(res.newGlobal, s)
+		}
+		if _, ok := ownedSeries[s]; !ok {
+			res.newOwned = append(res.newOwned, s)
+		}
+	}
+
+	if l.maxSeries > 0 && len(res.newGlobal) > 0 && len(l.series)+len(res.newGlobal) > l.maxSeries {
+		return nil, &LimitError{Limit: l.maxSeries, Series: res.newGlobal[0]}
+	}
+	if l.maxPerSource > 0 && len(res.newOwned) > 0 && len(ownedSeries)+len(res.newOwned) > l.maxPerSource {
+		return nil, &LimitError{Source: source, Limit: l.maxPerSource, Series: res.newOwned[0]}
+	}
+
+	for _, s := range res.newGlobal {
+		l.series[s] = false
+	}
+	if owned == nil {
+		if len(l.sources) >= l.maxSources {
+			l.evictOldest()
+		}
+		owned = &sourceSeries{series: make(seriesSet, len(res.newOwned))}
+		l.sources[source] = owned
+	}
+	owned.lastSeen = now
+	for _, s := range res.newOwned {
+		owned.series[s] = false
+	}
+
+	return res, nil
+}
+
+// Commit серии записаны в хранилище
+func (r *Reservation) Commit() {
+	l := r.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range r.series {
+		l.series[s] = true
+	}
+	if owned, ok := l.sources[r.source]; ok {
+		for _, s := range r.series {
+			owned.series[s] = true
+		}
+	}
+}
+
+// Cancel запись не удалась: добавленные этим Admit серии забываются,
+// если их не успел записать другой запрос
+func (r *Reservation) Cancel() {
+	l := r.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range r.newGlobal {
+		if written, ok := l.series[s]; ok && !written {
+			delete(l.series, s)
+		}
+	}
+	if owned, ok := l.sources[r.source]; ok {
+		for _, s := range r.newOwned {
+			if written, ok := owned.series[s]; ok && !written {
+				delete(owned.series, s)
+			}
+		}
+		if len(owned.series) == 0 {
+			delete(l.sources, r.source)
+		}
+	}
+}
+
+// expire забывает источники, не писавшие дольше sourceTTL. Проверка не чаще раза в минуту
+func (l *Limiter) expire(now time.Time) {
+	if now.Sub(l.lastExpire) < time.Minute {
+		return
+	}
+	l.lastExpire = now
+
+	for k, v := range l.sources {
+		if now.Sub(v.lastSeen) > l.sourceTTL {
+			delete(l.sources, k)
+		}
+	}
+}
+
+// evictOldest забывает источник, дольше всех не писавший метрик
+func (l *Limiter) evictOldest() {
+	var (
+		oldest string
+		seen   time.Time
+	)
+	for k, v := range l.sources {
+		if oldest == "" || v.lastSeen.Before(seen) {
+			oldest, seen = k, v.lastSeen
+		}
+	}
+	delete(l.sources, oldest)
+}
+
+// SourceStats серии одного источника
+type SourceStats struct {
+	Source string `json:"source"`
+	Series int    `json:"series"`
+}
+
+// Stats текущая кардинальность и ограничения
+type Stats struct {
+	Series       int           `json:"series"`
+	MaxSeries    int           `json:"max_series"`
+	MaxPerSource int           `json:"max_series_per_source"`
+	Sources      []SourceStats `json:"sources"`
+}
+
+// Stats снимок для admin API, источники по убыванию числа серий
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := Stats{
+		Series:       len(l.series),
+		MaxSeries:    l.maxSeries,
+		MaxPerSource: l.maxPerSource,
+		Sources:      make([]SourceStats, 0, len(l.sources)),
+	}
+	for k, v := range l.sources {
+		result.Sources = append(result.Sources, SourceStats{Source: k, Series: len(v.series)})
+	}
+	sort.Slice(result.Sources, func(i, j int) bool {
+		if result.Sources[i].Series != result.Sources[j].Series {
+			return result.Sources[i].Series > result.Sources[j].Series
+		}
+		return result.Sources[i].Source < result.Sources[j].Source
+	})
+
+	return result
+}
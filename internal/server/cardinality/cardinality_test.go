@@ -0,0 +1,112 @@
+package cardinality
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLimiterPerSource(t *testing.T) {
+	l := New(0, 2)
+
+	require.NoError(t, admit(l, "a", Series("gauge", "m1"), Series("gauge", "m1"), Series("counter", "m1")))
+
+	// повтор известных серий лимит не тратит
+	require.NoError(t, admit(l, "a", Series("gauge", "m1")))
+
+	err := admit(l, "a", Series("gauge", "m2"))
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "a", limitErr.Source)
+	assert.Equal(t, 2, limitErr.Limit)
+	assert.Contains(t, err.Error(), "gauge:m2")
+
+	// у другого источника свой лимит, общие серии ему тоже засчитываются
+	require.NoError(t, admit(l, "b", Series("gauge", "m1"), Series("gauge", "m2")))
+
+	// пакет принимается целиком или не принимается
+	assert.Error(t, admit(l, "c", Series("gauge", "x1"), Series("gauge", "x2"), Series("gauge", "x3")))
+	stats := l.Stats()
+	assert.Equal(t, 3, stats.Series)
+	assert.Equal(t, []SourceStats{{Source: "a", Series: 2}, {Source: "b", Series: 2}}, stats.Sources)
+}
+
+func TestLimiterGlobal(t *testing.T) {
+	l := New(3, 0)
+	l.Seed(Series("gauge", "old1"), Series("gauge", "old2"))
+
+	require.NoError(t, admit(l, "a", Series("gauge", "old1"), Series("gauge", "new1")))
+
+	err := admit(l, "b", Series("gauge", "new2"))
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Empty(t, limitErr.Source)
+	assert.Contains(t, err.Error(), "server already stores 3")
+
+	// существующие серии писать можно и после достижения лимита
+	require.NoError(t, admit(l, "b", Series("gauge", "old2"), Series("gauge", "new1")))
+
+	stats := l.Stats()
+	assert.Equal(t, Stats{
+		Series:    3,
+		MaxSeries: 3,
+		Sources:   []SourceStats{{Source: "a", Series: 2}, {Source: "b", Series: 2}},
+	}, stats)
+}
+
+// admit Admit с записью в хранилище
+func admit(l *Limiter, source string, series ...string) error {
+	res, err := l.Admit(source, series...)
+	if err != nil {
+		return err
+	}
+	res.Commit()
+	return nil
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := New(2, 1)
+
+	// незаписанные серии ограничения не расходуют
+	res, err := l.Admit("a", Series("gauge", "m1"))
+	require.NoError(t, err)
+	res.Cancel()
+	assert.Equal(t, 0, l.Stats().Series)
+	require.NoError(t, admit(l, "a", Series("gauge", "m2")))
+
+	// серию, которую записал другой запрос, отмена не убирает
+	res, err = l.Admit("b", Series("gauge", "m3"))
+	require.NoError(t, err)
+	require.NoError(t, admit(l, "c", Series("gauge", "m3")))
+	res.Cancel()
+
+	stats := l.Stats()
+	assert.Equal(t, 2, stats.Series)
+	assert.Equal(t, []SourceStats{{Source: "a", Series: 1}, {Source: "c", Series: 1}}, stats.Sources)
+}
+
+func TestLimiterExpireSources(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(0, 1)
+	l.now = func() time.Time { return now }
+	l.maxSources = 2
+
+	require.NoError(t, admit(l, "a", Series("gauge", "a1")))
+	now = now.Add(time.Second)
+	require.NoError(t, admit(l, "b", Series("gauge", "b1")))
+
+	// переполнение: забывается самый давний источник
+	now = now.Add(time.Second)
+	require.NoError(t, admit(l, "c", Series("gauge", "c1")))
+	stats := l.Stats()
+	assert.Equal(t, 3, stats.Series)
+	assert.Equal(t, []SourceStats{{Source: "b", Series: 1}, {Source: "c", Series: 1}}, stats.Sources)
+
+	// давно не писавший источник забывается, ограничение для него начинается заново
+	assert.Error(t, admit(l, "c", Series("gauge", "c2")))
+	now = now.Add(DefaultSourceTTL + time.Minute)
+	require.NoError(t, admit(l, "c", Series("gauge", "c2")))
+	assert.Equal(t, []SourceStats{{Source: "c", Series: 1}}, l.Stats().Sources)
+}
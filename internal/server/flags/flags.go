@@ -1,114 +1,399 @@
 package flags
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
 	"flag"
-	"github.com/rs/zerolog/log"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/encryption"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/keyring"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/tlsconfig"
+	"net/netip"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
 )
 
-type StoragePoint struct {
-	Memory   bool
-	File     bool
-	DataBase bool
+// Config настройки сервера из флагов и переменных окружения
+type Config struct {
+	RunAddr       string
+	StoreInterval int
+	FileStorePath string
+	Restore       bool
+	DBConn        string
+	BoltPath      string
+	Storage       string
+	HashKey       string
+	// StrictSign отклонять запросы без подписи, если задан HashKey
+	StrictSign bool
+	// SignMaxSkew допустимое расхождение времени подписи запроса с часами сервера (сек)
+	SignMaxSkew int
+	// SignNonceCache сколько nonce подписанных запросов помнить, заполненный кеш отвечает 503
+	SignNonceCache int
+	// CryptoKey путь к приватному RSA ключу (PEM) для расшифровки запросов агента
+	CryptoKey  string
+	PrivateKey *rsa.PrivateKey
+	// CryptoRequired отклонять запросы с телом без X-Encrypted-Key, иначе шифрование необязательно
+	CryptoRequired bool
+	// TLSCert, TLSKey сертификат и ключ сервера (PEM), оба заданы - сервер работает по https
+	TLSCert string
+	TLSKey  string
+	// TLSClientCA CA клиентских сертификатов, задан - включается mTLS
+	TLSClientCA string
+	TLSConfig   *tls.Config
+	// KeysFile файл ключей подписи "<id> <key>" для ротации, перечитывается при изменении
+	KeysFile string
+	// KeysReload как часто (сек) проверять изменение KeysFile
+	KeysReload int
+	// Keys набор ключей подписи: HashKey и ключи из KeysFile
+	Keys *keyring.Ring
+	// TrustedSubnet сети (CIDR через запятую), из которых принимаются метрики, пустое - любые
+	TrustedSubnet string
+	// TrustedProxies прокси, которым можно верить в X-Forwarded-For/X-Real-IP
+	TrustedProxies string
+	TrustedNets    []netip.Prefix
+	ProxyNets      []netip.Prefix
+	// MaxBodySize максимальный размер тела запроса в байтах (и после распаковки gzip), 0 - без ограничения
+	MaxBodySize int64
+	// MaxBatch максимальное число метрик в /updates, 0 - без ограничения
+	MaxBatch int
+	// RateLimit запросов в секунду с одного источника (токен или IP) и неудачных попыток
+	// авторизации с одного IP, 0 - без ограничения
+	RateLimit float64
+	// RateBurst сколько запросов подряд можно сделать сверх RateLimit, 0 - max(1, RateLimit)
+	RateBurst int
+	// MaxSeries сколько различных метрик хранит сервер, 0 - без ограничения
+	MaxSeries int
+	// MaxSeriesPerSource сколько различных метрик может записать один источник, 0 - без ограничения
+	MaxSeriesPerSource int
+	// Tokens хранилище токенов агентов (mem://, file:///path, postgres://..., postgres+dsn://...), пустое - без токенов
+	Tokens string
+	// AdminToken токен с правами admin для выпуска первых токенов
+	AdminToken string
+	// ShutdownTimeout сколько секунд ждать завершения запросов при остановке
+	ShutdownTimeout int
 }
 
-var (
-	FlagRunAddr       string
-	FlagStoreInterval int
-	FlagFileStorePath string
-	FlagRestore       bool
-	FlagDBConn        string
-	StorePoint        StoragePoint
-	FlagHashKey       string
-	UseHashKey        bool
-	err               error
-)
-
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
-		}
-	})
-	return found
+// UseHashKey проверять подпись запросов
+func (c *Config) UseHashKey() bool {
+	return c.HashKey != "" || c.KeysFile != ""
 }
 
-func ParseFlags() {
+// NewConfig настройки по умолчанию
+func NewConfig() *Config {
 	defaultFileStorePath := "/tmp/metrics-db.json"
 	if opSyst := runtime.GOOS; strings.Contains(opSyst, "windows") {
 		defaultFileStorePath = "c:/tmp/metrics-db.json"
 	}
 
+	return &Config{
+		RunAddr:         ":8080",
+		StoreInterval:   300,
+		FileStorePath:   defaultFileStorePath,
+		Restore:         true,
+		ShutdownTimeout: 10,
+		SignMaxSkew:     300,
+		SignNonceCache:  100000,
+		KeysReload:      10,
+		MaxBodySize:     8 << 20,
+		MaxBatch:        10000,
+		MaxSeries:       100000,
+		// агент по умолчанию шлет несколько десятков метрик
+		MaxSeriesPerSource: 10000,
+	}
+}
+
+func ParseFlags() (*Config, error) {
+	return ParseArgs(os.Args[0], os.Args[1:])
+}
+
+// ParseArgs разбирает аргументы командной строки, затем переменные окружения
+func ParseArgs(name string, args []string) (*Config, error) {
+	var err error
+
+	cfg := NewConfig()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
 	//defaultDBConn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 	//	`localhost`, `5432`, `praktikum`, `praktikum`, `praktikum`)
-	defaultDBConn := ""
-
-	//defaultHashKey := "0123456789ABCDEF"
-	defaultHashKey := ""
 
-	flag.StringVar(&FlagRunAddr, "a", ":8080", "addr to run on")
-	flag.IntVar(&FlagStoreInterval, "i", 300, "save to file interval (sec)")
-	flag.StringVar(&FlagFileStorePath, "f", defaultFileStorePath, "file to save")
-	flag.BoolVar(&FlagRestore, "r", true, "load metrics on start from file")
-	flag.StringVar(&FlagDBConn, "d", defaultDBConn, "db conn string")
-	flag.StringVar(&FlagHashKey, "k", defaultHashKey, "hashKey")
-	flag.Parse()
+	fs.StringVar(&cfg.RunAddr, "a", cfg.RunAddr, "addr to run on")
+	fs.IntVar(&cfg.StoreInterval, "i", cfg.StoreInterval, "save to file interval (sec)")
+	fs.StringVar(&cfg.FileStorePath, "f", cfg.FileStorePath, "file to save")
+	fs.BoolVar(&cfg.Restore, "r", cfg.Restore, "load metrics on start from file")
+	fs.StringVar(&cfg.DBConn, "d", cfg.DBConn, "db conn string")
+	fs.StringVar(&cfg.BoltPath, "b", cfg.BoltPath, "bolt db file (embedded key-value storage)")
+	fs.StringVar(&cfg.Storage, "storage", cfg.Storage, "storage url: mem://, file:///path, bolt:///path, postgres://..., postgres+dsn://host=...")
+	fs.StringVar(&cfg.HashKey, "k", cfg.HashKey, "hashKey")
+	fs.BoolVar(&cfg.StrictSign, "strict-sign", cfg.StrictSign, "reject unsigned requests when hashKey is set")
+	fs.IntVar(&cfg.SignMaxSkew, "sign-max-skew", cfg.SignMaxSkew, "signed request timestamp window (sec)")
+	fs.IntVar(&cfg.SignNonceCache, "sign-nonce-cache", cfg.SignNonceCache,
+		"signed request nonce cache size, should cover requests within 2*sign-max-skew (full cache answers 503)")
+	fs.StringVar(&cfg.CryptoKey, "crypto-key", cfg.CryptoKey,
+		"private key PEM file to decrypt agent payloads (unencrypted payloads are still accepted unless -crypto-required)")
+	fs.BoolVar(&cfg.CryptoRequired, "crypto-required", cfg.CryptoRequired, "reject request bodies that are not encrypted with crypto-key")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate PEM file")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key PEM file")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "CA PEM file to verify agent certificates (mTLS)")
+	fs.StringVar(&cfg.KeysFile, "keys-file", cfg.KeysFile, "signing keys file, lines \"<id> <key>\"")
+	fs.IntVar(&cfg.KeysReload, "keys-reload", cfg.KeysReload, "signing keys file reload check interval (sec)")
+	fs.StringVar(&cfg.TrustedSubnet, "t", cfg.TrustedSubnet, "trusted subnets for metric writes, comma separated CIDR")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", cfg.TrustedProxies, "trusted proxies, comma separated CIDR or IP")
+	fs.Int64Var(&cfg.MaxBodySize, "max-body", cfg.MaxBodySize, "max request body size in bytes after decompression (0 - no limit)")
+	fs.IntVar(&cfg.MaxBatch, "max-batch", cfg.MaxBatch, "max metrics in one /updates batch (0 - no limit)")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "requests per second per client IP or token, also failed auth attempts per IP (0 - no limit)")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "rate limit burst (0 - max(1, rate-limit))")
+	fs.IntVar(&cfg.MaxSeries, "max-series", cfg.MaxSeries, "max distinct metrics on server (0 - no limit)")
+	fs.IntVar(&cfg.MaxSeriesPerSource, "max-series-per-source", cfg.MaxSeriesPerSource,
+		"max distinct metrics per client IP or token (0 - no limit)")
+	fs.StringVar(&cfg.Tokens, "tokens", cfg.Tokens, "agent token storage DSN: mem://, file:///path, postgres://... or postgres+dsn://host=...")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bootstrap admin bearer token")
+	fs.IntVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "in-flight requests drain timeout (sec)")
+	if err = fs.Parse(args); err != nil {
+		return nil, err
+	}
 
 	if envVar := os.Getenv("ADDRESS"); envVar != "" {
-		FlagRunAddr = envVar
+		cfg.RunAddr = envVar
 	}
 
 	if envVar := os.Getenv("STORE_INTERVAL"); envVar != "" {
-		FlagStoreInterval, err = strconv.Atoi(envVar)
+		cfg.StoreInterval, err = strconv.Atoi(envVar)
 		if err != nil {
-			log.Fatal().Err(err).Msg("FlagStoreInterval")
+			return nil, fmt.Errorf("STORE_INTERVAL: %w", err)
 		}
 	}
 
 	if envVar := os.Getenv("FILE_STORAGE_PATH"); envVar != "" {
-		FlagFileStorePath = envVar
+		cfg.FileStorePath = envVar
 	}
 
 	if envVar := os.Getenv("RESTORE"); envVar != "" {
-		FlagRestore, err = strconv.ParseBool(envVar)
+		cfg.Restore, err = strconv.ParseBool(envVar)
 		if err != nil {
-			log.Fatal().Err(err).Msg("FlagRestore")
+			return nil, fmt.Errorf("RESTORE: %w", err)
 		}
 	}
 
 	if envVar := os.Getenv("DATABASE_DSN"); envVar != "" {
-		FlagDBConn = envVar
+		cfg.DBConn = envVar
+	}
+
+	if envVar := os.Getenv("BOLT_STORAGE_PATH"); envVar != "" {
+		cfg.BoltPath = envVar
+	}
+
+	if envVar := os.Getenv("STORAGE_URL"); envVar != "" {
+		cfg.Storage = envVar
+	}
+
+	// -storage не задан - собираем url из старых флагов -d, -b, -f
+	if cfg.Storage == "" {
+		if strings.Contains(cfg.DBConn, "://") {
+			cfg.Storage = cfg.DBConn
+		} else if cfg.DBConn != "" {
+			// DSN вида "host=... dbname=..." без схемы
+			cfg.Storage = "postgres+dsn://" + cfg.DBConn
+		} else if cfg.BoltPath != "" {
+			cfg.Storage = "bolt://" + cfg.BoltPath
+		} else if cfg.FileStorePath != "" {
+			cfg.Storage = "file://" + cfg.FileStorePath
+		} else {
+			cfg.Storage = "mem://"
+		}
+	}
+
+	if envHashKey := os.Getenv("KEY"); envHashKey != "" {
+		cfg.HashKey = envHashKey
+	}
+
+	if envVar := os.Getenv("STRICT_SIGN"); envVar != "" {
+		cfg.StrictSign, err = strconv.ParseBool(envVar)
 		if err != nil {
-			log.Fatal().Err(err).Msg("FlagDBConn")
+			return nil, fmt.Errorf("STRICT_SIGN: %w", err)
 		}
 	}
 
-	if isFlagPassed(FlagDBConn) || os.Getenv("DATABASE_DSN") != "" {
-		StorePoint.DataBase = true
-	} else if isFlagPassed(FlagFileStorePath) || FlagFileStorePath != "" {
-		StorePoint.File = true
-	} else {
-		StorePoint.Memory = true
+	if envVar := os.Getenv("SIGN_MAX_SKEW"); envVar != "" {
+		cfg.SignMaxSkew, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("SIGN_MAX_SKEW: %w", err)
+		}
 	}
-	//StorePoint.DataBase = true
 
-	if envHashKey := os.Getenv("KEY"); envHashKey != "" {
-		FlagHashKey = envHashKey
+	if envVar := os.Getenv("SIGN_NONCE_CACHE"); envVar != "" {
+		cfg.SignNonceCache, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("SIGN_NONCE_CACHE: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("SHUTDOWN_TIMEOUT"); envVar != "" {
+		cfg.ShutdownTimeout, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("SHUTDOWN_TIMEOUT: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("CRYPTO_KEY"); envVar != "" {
+		cfg.CryptoKey = envVar
+	}
+
+	if envVar := os.Getenv("CRYPTO_REQUIRED"); envVar != "" {
+		cfg.CryptoRequired, err = strconv.ParseBool(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("CRYPTO_REQUIRED: %w", err)
+		}
+	}
+
+	if cfg.CryptoKey != "" {
+		cfg.PrivateKey, err = encryption.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("crypto-key: %w", err)
+		}
+	} else if cfg.CryptoRequired {
+		return nil, errors.New("crypto-required requires crypto-key")
+	}
+
+	if envVar := os.Getenv("TLS_CERT"); envVar != "" {
+		cfg.TLSCert = envVar
+	}
+
+	if envVar := os.Getenv("TLS_KEY"); envVar != "" {
+		cfg.TLSKey = envVar
+	}
+
+	if envVar := os.Getenv("TLS_CLIENT_CA"); envVar != "" {
+		cfg.TLSClientCA = envVar
+	}
+
+	if envVar := os.Getenv("KEYS_FILE"); envVar != "" {
+		cfg.KeysFile = envVar
+	}
+
+	if envVar := os.Getenv("KEYS_RELOAD"); envVar != "" {
+		cfg.KeysReload, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("KEYS_RELOAD: %w", err)
+		}
 	}
 
-	if !isFlagPassed(FlagHashKey) && os.Getenv("KEY") == "" {
-		UseHashKey = false
-	} else {
-		UseHashKey = true
+	cfg.Keys = keyring.New(keyring.Key{Secret: cfg.HashKey})
+	if cfg.KeysFile != "" {
+		if err = cfg.Keys.Load(cfg.KeysFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if envVar := os.Getenv("TRUSTED_SUBNET"); envVar != "" {
+		cfg.TrustedSubnet = envVar
+	}
+
+	if envVar := os.Getenv("TRUSTED_PROXIES"); envVar != "" {
+		cfg.TrustedProxies = envVar
+	}
+
+	if cfg.TrustedNets, err = parsePrefixes(cfg.TrustedSubnet); err != nil {
+		return nil, fmt.Errorf("trusted subnet: %w", err)
+	}
+
+	if cfg.ProxyNets, err = parsePrefixes(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	if envVar := os.Getenv("MAX_BODY_SIZE"); envVar != "" {
+		cfg.MaxBodySize, err = strconv.ParseInt(envVar, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_BODY_SIZE: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("MAX_BATCH"); envVar != "" {
+		cfg.MaxBatch, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_BATCH: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("RATE_LIMIT"); envVar != "" {
+		cfg.RateLimit, err = strconv.ParseFloat(envVar, 64)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("RATE_BURST"); envVar != "" {
+		cfg.RateBurst, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_BURST: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("MAX_SERIES"); envVar != "" {
+		cfg.MaxSeries, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_SERIES: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("MAX_SERIES_PER_SOURCE"); envVar != "" {
+		cfg.MaxSeriesPerSource, err = strconv.Atoi(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_SERIES_PER_SOURCE: %w", err)
+		}
+	}
+
+	if envVar := os.Getenv("TOKENS_STORAGE"); envVar != "" {
+		cfg.Tokens = envVar
+	}
+
+	if envVar := os.Getenv("ADMIN_TOKEN"); envVar != "" {
+		cfg.AdminToken = envVar
+	}
+
+	if cfg.AdminToken != "" && cfg.Tokens == "" {
+		return nil, errors.New("admin-token requires tokens storage")
+	}
+
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		cfg.TLSConfig, err = tlsconfig.ServerConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	} else if cfg.TLSClientCA != "" {
+		return nil, errors.New("tls-client-ca requires tls-cert and tls-key")
+	}
+
+	return cfg, nil
+}
+
+// parsePrefixes список сетей через запятую: CIDR или отдельные адреса
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var result []netip.Prefix
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("bad address %q: %w", item, err)
+			}
+			result = append(result, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("bad subnet %q: %w", item, err)
+		}
+		result = append(result, prefix.Masked())
 	}
-	//UseHashKey = true
 
-	log.Info().
-		Str("UseHashKey", strconv.FormatBool(UseHashKey)).
-		Str("FlagHashKey", FlagHashKey).
-		Msg("UseHashKey server")
+	return result, nil
 }
@@ -0,0 +1,227 @@
+// Package keyring набор HMAC ключей подписи с идентификаторами для ротации без одновременного
+// перезапуска агентов и сервера. Агент передает идентификатор ключа в заголовке HeaderKeyID,
+// сервер принимает подпись любым активным ключом.
+//
+// Файл ключей - строки "<id> <key>", пустые строки и строки с # пропускаются.
+// Первый ключ файла - основной: им подписываются ответы на запросы без идентификатора,
+// если нет ключа -k
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderKeyID заголовок с идентификатором ключа подписи
+const HeaderKeyID = "HashSHA256-KeyID"
+
+// Key ключ подписи, ключ -k хранится с пустым ID
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Ring активные ключи: постоянные (из -k) и загруженные из файла
+type Ring struct {
+	mu      sync.RWMutex
+	static  []Key
+	file    []Key
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// New набор из постоянных ключей, ключи с пустым секретом пропускаются
+func New(static ...Key) *Ring {
+	r := &Ring{}
+	for _, k := range static {
+		if k.Secret != "" {
+			r.static = append(r.static, k)
+		}
+	}
+
+	return r
+}
+
+// Empty нет ни одного ключа - подпись не проверяется
+func (r *Ring) Empty() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.static) == 0 && len(r.file) == 0
+}
+
+// Keys все активные ключи, постоянные первыми
+func (r *Ring) Keys() []Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Key, 0, len(r.static)+len(r.file))
+	result = append(result, r.static...)
+	return append(result, r.file...)
+}
+
+// Lookup активный ключ по идентификатору
+func (r *Ring) Lookup(id string) (Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, keys := range [][]Key{r.static, r.file} {
+		for _, k := range keys {
+			if k.ID == id {
+				return k, true
+			}
+		}
+	}
+
+	return Key{}, false
+}
+
+// Primary ключ для подписи ответов на запросы без идентификатора
+func (r *Ring) Primary() (Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.static) > 0 {
+		return r.static[0], true
+	}
+	if len(r.file) > 0 {
+		return r.file[0], true
+	}
+
+	return Key{}, false
+}
+
+// Load загружает ключи из файла, заменяя ранее загруженные из файла.
+// При ошибке остаются прежние ключи
+func (r *Ring) Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("keyring %s: %w", path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range keys {
+		for _, s := range r.static {
+			if s.ID == k.ID {
+				return fmt.Errorf("keyring %s: key id %q already used", path, k.ID)
+			}
+		}
+	}
+
+	r.file = keys
+	r.path = path
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+
+	return nil
+}
+
+func parse(data []byte) ([]Key, error) {
+	var keys []Key
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want \"<id> <key>\"", line)
+		}
+		if seen[fields[0]] {
+			return nil, fmt.Errorf("line %d: duplicate key id %q", line, fields[0])
+		}
+		seen[fields[0]] = true
+
+		keys = append(keys, Key{ID: fields[0], Secret: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+
+	return keys, nil
+}
+
+// changed изменился ли файл с последней попытки загрузки
+func (r *Ring) changed() (os.FileInfo, bool, error) {
+	r.mu.RLock()
+	path, modTime, size := r.path, r.modTime, r.size
+	r.mu.RUnlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return info, !info.ModTime().Equal(modTime) || info.Size() != size, nil
+}
+
+// Watch раз в interval проверяет файл, загруженный Load, и перечитывает его при изменении.
+// Ошибки чтения логируются, сервер продолжает работать с прежними ключами. Завершается с ctx
+func (r *Ring) Watch(ctx context.Context, interval time.Duration, logger zerolog.Logger) {
+	r.mu.RLock()
+	path := r.path
+	r.mu.RUnlock()
+
+	if path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, changed, err := r.changed()
+			if err != nil {
+				logger.Info().Err(err).Str("path", path).Msg("keyring stat error")
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			if err = r.Load(path); err != nil {
+				// не повторяем ошибку, пока файл снова не изменится
+				r.mu.Lock()
+				r.modTime, r.size = info.ModTime(), info.Size()
+				r.mu.Unlock()
+
+				logger.Info().Err(err).Str("path", path).Msg("keyring reload error, keeping previous keys")
+				continue
+			}
+			logger.Info().Str("path", path).Int("keys", len(r.Keys())).Msg("keyring reloaded")
+		}
+	}
+}
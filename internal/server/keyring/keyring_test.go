@@ -0,0 +1,112 @@
+package keyring
+
+import (
+	"context"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	keys, err := parse([]byte("# комментарий\n\nk1 secret1\n  k2\tsecret2  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Key{{ID: "k1", Secret: "secret1"}, {ID: "k2", Secret: "secret2"}}, keys)
+
+	for name, data := range map[string]string{
+		"empty":         "# только комментарий\n",
+		"no secret":     "k1\n",
+		"extra field":   "k1 secret1 extra\n",
+		"duplicate ids": "k1 secret1\nk1 secret2\n",
+	} {
+		_, err = parse([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := New(Key{Secret: ""})
+	assert.True(t, r.Empty())
+	_, ok := r.Primary()
+	assert.False(t, ok)
+
+	r = New(Key{Secret: "legacy"})
+	assert.False(t, r.Empty())
+
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("k1 secret1\nk2 secret2\n"), 0600))
+	require.NoError(t, r.Load(path))
+
+	assert.Len(t, r.Keys(), 3)
+
+	key, ok := r.Lookup("k2")
+	assert.True(t, ok)
+	assert.Equal(t, "secret2", key.Secret)
+
+	key, ok = r.Lookup("")
+	assert.True(t, ok)
+	assert.Equal(t, "legacy", key.Secret)
+
+	_, ok = r.Lookup("k3")
+	assert.False(t, ok)
+
+	// -k основной, без него - первый ключ файла
+	key, _ = r.Primary()
+	assert.Equal(t, "legacy", key.Secret)
+
+	fileOnly := New()
+	require.NoError(t, fileOnly.Load(path))
+	key, _ = fileOnly.Primary()
+	assert.Equal(t, Key{ID: "k1", Secret: "secret1"}, key)
+
+	// ошибка загрузки не меняет набор
+	require.NoError(t, os.WriteFile(path, []byte("broken\n"), 0600))
+	assert.Error(t, fileOnly.Load(path))
+	assert.Len(t, fileOnly.Keys(), 2)
+
+	assert.Error(t, fileOnly.Load(filepath.Join(t.TempDir(), "missing")))
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("k1 secret1\n"), 0600))
+
+	r := New()
+	require.NoError(t, r.Load(path))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Watch(ctx, 10*time.Millisecond, zerolog.Nop())
+		close(done)
+	}()
+
+	// добавили ключ
+	require.NoError(t, os.WriteFile(path, []byte("k1 secret1\nk2 secret2\n"), 0600))
+	assert.Eventually(t, func() bool {
+		_, ok := r.Lookup("k2")
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// битый файл - остаются прежние ключи
+	require.NoError(t, os.WriteFile(path, []byte("broken\n"), 0600))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, r.Keys(), 2)
+
+	// убрали старый ключ
+	require.NoError(t, os.WriteFile(path, []byte("k2 secret2\n"), 0600))
+	assert.Eventually(t, func() bool {
+		_, ok := r.Lookup("k1")
+		return !ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not stop")
+	}
+}
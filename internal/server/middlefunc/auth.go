@@ -0,0 +1,91 @@
+package middlefunc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig настройки проверки bearer токенов
+type AuthConfig struct {
+	// Store хранилище токенов, nil - авторизация выключена
+	Store tokens.Store
+	// AdminToken токен с правами admin вне хранилища, нужен чтобы выпустить первые токены
+	AdminToken string
+	// Failures ограничение неудачных попыток с одного адреса, nil - без ограничения
+	Failures *AuthLimiter
+}
+
+// Enabled включена ли проверка токенов
+func (c AuthConfig) Enabled() bool {
+	return c.Store != nil
+}
+
+// bearerToken секрет из заголовка Authorization: Bearer <token>
+func bearerToken(r *http.Request) string {
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(secret)
+}
+
+// RequireToken пропускает запросы с токеном не ниже scope и кладет токен в контекст запроса.
+// Нет или неизвестный токен - 401, недостаточно прав - 403, после частых неудачных попыток
+// с адреса - 429 без обращения к хранилищу (см. AuthLimiter).
+// Ограничение по префиксу метрики проверяют обработчики через tokens.FromContext
+func RequireToken(cfg AuthConfig, scope tokens.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled() {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Failures.blocked(w, r) {
+				return
+			}
+
+			secret := bearerToken(r)
+			if secret == "" {
+				cfg.Failures.fail(r)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("bearer token required"))
+				return
+			}
+
+			var (
+				token tokens.Token
+				ok    bool
+				err   error
+			)
+			if cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.AdminToken)) == 1 {
+				token, ok = tokens.Token{ID: "bootstrap", Name: "admin-token", Scope: tokens.ScopeAdmin}, true
+			} else {
+				token, ok, err = cfg.Store.Lookup(tokens.HashSecret(secret))
+				if err != nil {
+					log.Info().Err(err).Msg("tokens Lookup")
+					writeError(w, http.StatusInternalServerError, errors.New("token lookup failed"))
+					return
+				}
+			}
+
+			if !ok {
+				cfg.Failures.fail(r)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+
+			if !token.Scope.Includes(scope) {
+				writeError(w, http.StatusForbidden, errors.New("token scope "+string(token.Scope)+" does not allow "+string(scope)))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(tokens.NewContext(r.Context(), token)))
+		})
+	}
+}
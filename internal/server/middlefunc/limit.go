@@ -0,0 +1,251 @@
+package middlefunc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
+	"github.com/rs/zerolog/log"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LimitBody отклоняет запросы с телом больше maxSize байт (413), 0 - без ограничения.
+// Тело читается сразу, поэтому после GzipDecompression распаковка останавливается на maxSize+1 байте
+// и gzip-бомба не раздувается в памяти. До Decrypt/GzipDecompression ограничивает размер как есть
+func LimitBody(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if maxSize <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxSize {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body larger than %d bytes", maxSize))
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("can not read request body"))
+				return
+			}
+			if int64(len(body)) > maxSize {
+				log.Info().Int64("maxSize", maxSize).Msg("LimitBody request rejected")
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body larger than %d bytes", maxSize))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateConfig ограничение частоты запросов
+type RateConfig struct {
+	// Rate запросов в секунду на источник, 0 - без ограничения
+	Rate float64
+	// Burst сколько запросов можно сделать подряд, 0 - max(1, Rate)
+	Burst int
+	// Proxies доверенные прокси для определения адреса клиента
+	Proxies []netip.Prefix
+}
+
+// bucket маркерная корзина одного источника
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter маркерные корзины по ключу источника
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	rate      float64
+	burst     float64
+	lastSweep time.Time
+}
+
+// sweepInterval как часто удалять полные корзины неактивных источников
+const sweepInterval = time.Minute
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &rateLimiter{
+		buckets: make(map[string]*bucket),
+		rate:    rate,
+		burst:   float64(burst),
+	}
+}
+
+// Allow забирает маркер из корзины key, иначе возвращает через сколько он появится
+func (l *rateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, l.wait(b)
+}
+
+// Wait через сколько в корзине key появится маркер, 0 - уже есть. Маркер не забирается
+func (l *rateLimiter) Wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return l.wait(b)
+}
+
+// bucket корзина key, пополненная на момент now
+func (l *rateLimiter) bucket(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+
+	return b
+}
+
+func (l *rateLimiter) wait(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep удаляет корзины, которые уже успели наполниться: они не отличаются от новых
+func (l *rateLimiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// SourceKey источник запроса для лимитов: токен, если запрос прошел RequireToken, иначе адрес клиента
+func SourceKey(r *http.Request, proxies []netip.Prefix) string {
+	if token, ok := tokens.FromContext(r.Context()); ok {
+		return "token:" + token.ID
+	}
+
+	addr, err := clientAddr(r, proxies)
+	if err != nil {
+		return "remote:" + r.RemoteAddr
+	}
+	return "ip:" + addr.String()
+}
+
+// RateLimit ограничивает частоту запросов с каждого источника (токен или IP),
+// сверх лимита - 429 с Retry-After в секундах. Результат можно подключить на несколько маршрутов,
+// корзины у них общие
+func RateLimit(cfg RateConfig) func(http.Handler) http.Handler {
+	limiter := newRateLimiter(cfg.Rate, cfg.Burst)
+
+	return func(next http.Handler) http.Handler {
+		if cfg.Rate <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := SourceKey(r, cfg.Proxies)
+
+			if ok, wait := limiter.Allow(key, time.Now()); !ok {
+				log.Info().Str("source", key).Dur("wait", wait).Msg("RateLimit request rejected")
+				writeRateLimited(w, wait, errors.New("rate limit exceeded"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeRateLimited ответ 429 с Retry-After в целых секундах, не меньше 1
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, err error) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, http.StatusTooManyRequests, err)
+}
+
+// AuthLimiter ограничивает неудачные попытки авторизации с одного адреса. RequireToken проверяет
+// корзину адреса до обращения к хранилищу токенов: пока она пуста, запросы отклоняются с 429.
+// Маркер тратит только запрос без токена или с неизвестным токеном, удачные запросы не ограничиваются
+type AuthLimiter struct {
+	limiter *rateLimiter
+	proxies []netip.Prefix
+}
+
+// NewAuthLimiter Rate неудачных попыток в секунду с адреса, 0 - без ограничения (nil)
+func NewAuthLimiter(cfg RateConfig) *AuthLimiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+
+	return &AuthLimiter{
+		limiter: newRateLimiter(cfg.Rate, cfg.Burst),
+		proxies: cfg.Proxies,
+	}
+}
+
+// key адрес клиента: до авторизации токена еще нет
+func (l *AuthLimiter) key(r *http.Request) string {
+	addr, err := clientAddr(r, l.proxies)
+	if err != nil {
+		return "remote:" + r.RemoteAddr
+	}
+	return "ip:" + addr.String()
+}
+
+// blocked отвечает 429, если с адреса запроса исчерпаны неудачные попытки
+func (l *AuthLimiter) blocked(w http.ResponseWriter, r *http.Request) bool {
+	if l == nil {
+		return false
+	}
+
+	key := l.key(r)
+	wait := l.limiter.Wait(key, time.Now())
+	if wait == 0 {
+		return false
+	}
+
+	log.Info().Str("source", key).Dur("wait", wait).Msg("RequireToken too many failed attempts")
+	writeRateLimited(w, wait, errors.New("too many failed authentication attempts"))
+	return true
+}
+
+// fail учитывает неудачную попытку с адреса запроса
+func (l *AuthLimiter) fail(r *http.Request) {
+	if l == nil {
+		return
+	}
+	l.limiter.Allow(l.key(r), time.Now())
+}
@@ -0,0 +1,202 @@
+package middlefunc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitBody(t *testing.T) {
+	const maxSize = 1024
+
+	var gotLen int
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotLen = len(body)
+	})
+	// как в роутере сервера: до и после распаковки
+	handler := LimitBody(maxSize)(GzipDecompression(LimitBody(maxSize)(echo)))
+
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	// 10 MiB нулей сжимаются в несколько КиБ
+	bomb := gzipped(make([]byte, 10<<20))
+	require.Less(t, len(bomb), 64<<10)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		gzip    bool
+		want    int
+		wantLen int
+	}{
+		{"small", make([]byte, maxSize), false, http.StatusOK, maxSize},
+		{"too large", make([]byte, maxSize+1), false, http.StatusRequestEntityTooLarge, 0},
+		{"small gzip", gzipped(make([]byte, maxSize)), true, http.StatusOK, maxSize},
+		{"gzip bomb", bomb, true, http.StatusRequestEntityTooLarge, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLen = 0
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.gzip {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.want, w.Code)
+			assert.Equal(t, tt.wantLen, gotLen)
+		})
+	}
+
+	// без ограничения middleware не подключается
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(make([]byte, maxSize*4)))
+	w := httptest.NewRecorder()
+	LimitBody(0)(echo).ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, maxSize*4, gotLen)
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a", now)
+		assert.True(t, ok, i)
+	}
+
+	ok, wait := l.Allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// другой источник не затронут
+	ok, _ = l.Allow("b", now)
+	assert.True(t, ok)
+
+	// за полсекунды появляется один маркер
+	ok, _ = l.Allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = l.Allow("a", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	// Wait маркер не забирает
+	assert.Equal(t, 500*time.Millisecond, l.Wait("a", now.Add(500*time.Millisecond)))
+	assert.Equal(t, time.Duration(0), l.Wait("b", now))
+	ok, _ = l.Allow("b", now)
+	assert.True(t, ok)
+
+	// неактивные корзины удаляются
+	l.Allow("c", now.Add(2*sweepInterval))
+	assert.Len(t, l.buckets, 1)
+
+	// burst по умолчанию
+	assert.Equal(t, float64(1), newRateLimiter(0.5, 0).burst)
+	assert.Equal(t, float64(3), newRateLimiter(2.5, 0).burst)
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(RateConfig{Rate: 0.1, Burst: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(remoteAddr string, token *tokens.Token) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+		request.RemoteAddr = remoteAddr
+		if token != nil {
+			request = request.WithContext(tokens.NewContext(request.Context(), *token))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1000", nil).Code)
+	// порт не важен - лимит на адрес
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:2000", nil).Code)
+
+	w := do("10.0.0.1:3000", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.2:1000", nil).Code)
+
+	// с токеном лимит на токен, а не на адрес
+	token := &tokens.Token{ID: "agent-1"}
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1000", token).Code)
+	assert.Equal(t, http.StatusOK, do("10.0.0.3:1000", token).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.4:1000", token).Code)
+
+	// 0 - без ограничения
+	unlimited := RateLimit(RateConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		unlimited.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+// countingTokens хранилище токенов, считающее обращения Lookup
+type countingTokens struct {
+	tokens.Store
+	lookups int
+}
+
+func (s *countingTokens) Lookup(hash string) (tokens.Token, bool, error) {
+	s.lookups++
+	return s.Store.Lookup(hash)
+}
+
+func TestRequireTokenFailures(t *testing.T) {
+	store := &countingTokens{Store: tokens.NewMemStore("")}
+	require.NoError(t, store.Create(tokens.Token{ID: "agent-1", Hash: tokens.HashSecret("good"), Scope: tokens.ScopeWrite}))
+
+	auth := AuthConfig{Store: store, Failures: NewAuthLimiter(RateConfig{Rate: 0.1, Burst: 2})}
+	handler := RequireToken(auth, tokens.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(remoteAddr, secret string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+		request.RemoteAddr = remoteAddr
+		if secret != "" {
+			request.Header.Set("Authorization", "Bearer "+secret)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	// удачные запросы попытки не тратят
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, do("10.0.0.1:1000", "good").Code)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.1:1000", "bad").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.1:1000", "").Code)
+	lookups := store.lookups
+
+	// попытки исчерпаны: 429 без обращения к хранилищу, в том числе с верным токеном
+	w := do("10.0.0.1:2000", "bad")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1000", "good").Code)
+	assert.Equal(t, lookups, store.lookups)
+
+	// другой адрес не затронут
+	assert.Equal(t, http.StatusOK, do("10.0.0.2:1000", "good").Code)
+
+	// без ограничения
+	assert.Nil(t, NewAuthLimiter(RateConfig{}))
+}
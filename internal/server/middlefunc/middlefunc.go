@@ -4,14 +4,22 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/hmac"
+	"crypto/rsa"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/encryption"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/keyring"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/signing"
 	"github.com/rs/zerolog/log"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // проверяем, что клиент отправил серверу сжатые данные в формате gzip
@@ -52,7 +60,97 @@ func GzipDecompression(next http.Handler) http.Handler {
 	})
 }
 
-func checkSign(r *http.Request) (bool, error) {
+// Decrypt расшифровывает тело запроса с заголовком X-Encrypted-Key приватным ключом сервера.
+// Подключается до GzipDecompression: агент сначала сжимает тело, потом шифрует.
+// Запросы без заголовка пропускаются как есть, а при required запросы с телом без заголовка
+// отклоняются с 400. nil ключ - middleware выключен
+func Decrypt(priv *rsa.PrivateKey, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if priv == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encKeyHeader := r.Header.Get(encryption.HeaderEncryptedKey)
+			if encKeyHeader == "" {
+				// запросы без тела (GET, POST /update/{type}/{name}/{value}) шифровать нечего
+				if required && r.ContentLength != 0 {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("%s header required", encryption.HeaderEncryptedKey))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			encKey, err := base64.StdEncoding.DecodeString(encKeyHeader)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("bad %s header", encryption.HeaderEncryptedKey))
+				return
+			}
+
+			bodyBytes, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("can not read request body"))
+				return
+			}
+
+			plain, err := encryption.Decrypt(priv, encKey, bodyBytes)
+			if err != nil {
+				log.Info().Err(err).Msg("Decrypt error")
+				writeError(w, http.StatusBadRequest, errors.New("can not decrypt request body"))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(plain))
+			r.ContentLength = int64(len(plain))
+			r.Header.Del(encryption.HeaderEncryptedKey)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeError ответ об ошибке в виде {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
+
+// SignConfig настройки проверки подписи запросов
+type SignConfig struct {
+	HashKey string // пустой ключ - без проверки
+	// Keys набор ключей с идентификаторами, если задан - HashKey не используется
+	Keys   *keyring.Ring
+	Strict bool // отклонять запросы без подписи и без конверта timestamp/nonce
+	// MaxSkew допустимое расхождение timestamp запроса с часами сервера
+	MaxSkew time.Duration
+	// NonceCacheSize сколько nonce помнить. Nonce хранится MaxSkew после timestamp запроса,
+	// кеш, заполненный действующими nonce, отвечает 503: размер должен покрывать запросы за 2*MaxSkew
+	NonceCacheSize int
+}
+
+// keyRing набор ключей проверки: Keys или единственный HashKey
+func (c SignConfig) keyRing() *keyring.Ring {
+	if c.Keys != nil {
+		return c.Keys
+	}
+	return keyring.New(keyring.Key{Secret: c.HashKey})
+}
+
+// signPayload подписываемые данные: тело запроса, а для запросов без тела
+// (POST /update/{metricType}/{metricName}/{metricVal}) - путь запроса
+func signPayload(r *http.Request, bodyBytes []byte) []byte {
+	if len(bodyBytes) == 0 {
+		return []byte(r.URL.Path)
+	}
+	return bodyBytes
+}
+
+// checkSign сверяет подпись запроса с каждым из keys, true - подошел хотя бы один
+func checkSign(r *http.Request, keys []keyring.Key) (bool, error) {
 
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -60,45 +158,202 @@ func checkSign(r *http.Request) (bool, error) {
 	}
 	log.Info().Str("bodyBytes", string(bodyBytes)).Msg("checkSign")
 
+	data := signPayload(r, bodyBytes)
+	if timestamp, nonce := r.Header.Get(signing.HeaderTimestamp), r.Header.Get(signing.HeaderNonce); timestamp != "" {
+		data = signing.Envelope(timestamp, nonce, data)
+	}
+
 	reqHeaderHash := r.Header.Get("HashSHA256")
-	h := hmac.New(sha256.New, []byte(flags.FlagHashKey))
-	h.Write(bodyBytes)
-	dst := h.Sum(nil)
 
 	// We need to set the body again because it was drained by io.ReadAll
 	r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
-	result := reqHeaderHash == hex.EncodeToString(dst)
-
+	reqHash, err := hex.DecodeString(reqHeaderHash)
+	if err != nil {
+		return false, nil
+	}
 	log.Info().Str("reqHeaderHash", reqHeaderHash).Msg("checkSign")
-	log.Info().Str("hex.EncodeToString(dst)", hex.EncodeToString(dst)).Msg("checkSign")
 
-	return result, nil
+	for _, key := range keys {
+		h := hmac.New(sha256.New, []byte(key.Secret))
+		h.Write(data)
+		if hmac.Equal(reqHash, h.Sum(nil)) {
+			log.Info().Str("keyID", key.ID).Msg("checkSign")
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
-func CheckReqBodySign(next http.Handler) http.Handler {
+// CheckReqBodySign проверяет подпись запроса.
+// Запрос без заголовка HashSHA256 в обычном режиме пропускается, в строгом - 401.
+// С заголовками HashSHA256-Timestamp (unix-время, сек) и HashSHA256-Nonce подписывается конверт
+// timestamp\nnonce\nданные: timestamp должен укладываться в MaxSkew, nonce - не повторяться.
+// Подпись только тела без конверта принимается лишь в обычном режиме.
+// С заголовком HashSHA256-KeyID подпись сверяется с этим ключом набора (неизвестный - 401),
+// без него - с любым активным ключом.
+// Кеш nonce общий для всех маршрутов, на которые подключен результат
+func CheckReqBodySign(cfg SignConfig) func(http.Handler) http.Handler {
+	nonces := newNonceCache(cfg.NonceCacheSize)
+
+	return func(next http.Handler) http.Handler {
+		return checkReqBodySign(next, cfg, nonces, time.Now)
+	}
+}
+
+func checkReqBodySign(next http.Handler, cfg SignConfig, nonces *nonceCache, now func() time.Time) http.Handler {
+	ring := cfg.keyRing()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ring.Empty() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		reqHeaderHash := r.Header.Get("HashSHA256")
-		if flags.UseHashKey && reqHeaderHash != "" {
-			if checkResult, err := checkSign(r); err != nil {
-				log.Info().Err(err).Msg("CheckReqBodySign error")
+		if reqHeaderHash == "" {
+			if cfg.Strict {
+				log.Info().Msg("CheckReqBodySign unsigned request rejected")
+				writeError(w, http.StatusUnauthorized, errors.New("HashSHA256 header required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
 
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				io.Copy(w, r.Body)
+		timestamp, nonce := r.Header.Get(signing.HeaderTimestamp), r.Header.Get(signing.HeaderNonce)
+		var reqTime time.Time
 
+		if timestamp == "" && nonce == "" {
+			if cfg.Strict {
+				log.Info().Msg("CheckReqBodySign request without envelope rejected")
+				writeError(w, http.StatusUnauthorized,
+					fmt.Errorf("%s and %s headers required", signing.HeaderTimestamp, signing.HeaderNonce))
+				return
+			}
+		} else {
+			if timestamp == "" || nonce == "" {
+				writeError(w, http.StatusBadRequest,
+					fmt.Errorf("both %s and %s headers required", signing.HeaderTimestamp, signing.HeaderNonce))
 				return
-			} else if !checkResult {
-				log.Info().Err(err).Msg("CheckReqBodySign checkResult error")
+			}
 
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				io.Copy(w, r.Body)
+			unixTime, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("bad %s header", signing.HeaderTimestamp))
+				return
+			}
+			reqTime = time.Unix(unixTime, 0)
 
+			if skew := now().Sub(reqTime); skew > cfg.MaxSkew || skew < -cfg.MaxSkew {
+				log.Info().Dur("skew", skew).Msg("CheckReqBodySign timestamp rejected")
+				writeError(w, http.StatusUnauthorized, errors.New("request timestamp outside allowed window"))
 				return
 			}
-			log.Info().Msg("CheckReqBodySign success")
 		}
+
+		keys := ring.Keys()
+		if keyID := r.Header.Get(keyring.HeaderKeyID); keyID != "" {
+			key, ok := ring.Lookup(keyID)
+			if !ok {
+				log.Info().Str("keyID", keyID).Msg("CheckReqBodySign unknown key id")
+				writeError(w, http.StatusUnauthorized, errors.New("unknown signing key id"))
+				return
+			}
+			keys = []keyring.Key{key}
+		}
+
+		if checkResult, err := checkSign(r, keys); err != nil {
+			log.Info().Err(err).Msg("CheckReqBodySign error")
+			writeError(w, http.StatusBadRequest, errors.New("can not read request body"))
+			return
+		} else if !checkResult {
+			log.Info().Msg("CheckReqBodySign checkResult error")
+			writeError(w, http.StatusBadRequest, errors.New("HashSHA256 signature mismatch"))
+			return
+		}
+
+		// nonce запоминаем только после проверки подписи, чтобы кеш не забивали чужие запросы.
+		// Позже reqTime+MaxSkew запрос отклонит проверка timestamp
+		if nonce != "" {
+			switch err := nonces.Add(nonce, reqTime.Add(cfg.MaxSkew), now()); {
+			case errors.Is(err, errNonceUsed):
+				log.Info().Str("nonce", nonce).Msg("CheckReqBodySign replay rejected")
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			case errors.Is(err, errNonceCacheFull):
+				// места освободятся, когда истекут MaxSkew самых старых записей
+				log.Info().Msg("CheckReqBodySign nonce cache full")
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, err)
+				return
+			}
+		}
+		log.Info().Msg("CheckReqBodySign success")
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// signResponseWriter копит ответ, чтобы подписать тело до отправки заголовков
+type signResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *signResponseWriter) WriteHeader(statusCode int) {
+	// как и net/http учитываем только первый код
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *signResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// SignResponse подписывает тело ответа ключом hashKey и передает подпись в заголовке HashSHA256.
+// Подключается после middleware.Compress, чтобы подписывалось несжатое тело
+func SignResponse(hashKey string) func(http.Handler) http.Handler {
+	return SignResponseKeys(keyring.New(keyring.Key{Secret: hashKey}))
+}
+
+// SignResponseKeys как SignResponse, но ключ выбирается из набора: ключ из HashSHA256-KeyID запроса,
+// иначе основной. Идентификатор ключа возвращается в заголовке HashSHA256-KeyID ответа
+func SignResponseKeys(ring *keyring.Ring) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := ring.Lookup(r.Header.Get(keyring.HeaderKeyID))
+			if !ok {
+				key, ok = ring.Primary()
+			}
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := &signResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+
+			h := hmac.New(sha256.New, []byte(key.Secret))
+			h.Write(sw.buf.Bytes())
+			w.Header().Set("HashSHA256", hex.EncodeToString(h.Sum(nil)))
+			if key.ID != "" {
+				w.Header().Set(keyring.HeaderKeyID, key.ID)
+			}
+
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			w.WriteHeader(sw.status)
+			if _, err := w.Write(sw.buf.Bytes()); err != nil {
+				log.Info().Err(err).Msg("SignResponse write error")
+			}
+		})
+	}
+}
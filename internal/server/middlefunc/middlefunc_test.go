@@ -0,0 +1,570 @@
+package middlefunc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/encryption"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/keyring"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(key string, data []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signed подпись с конвертом timestamp/nonce, как у агента
+type signed struct {
+	hash      string
+	timestamp string
+	nonce     string
+	keyID     string
+}
+
+func signEnv(key string, timestamp time.Time, nonce string, data []byte) signed {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return signed{
+		hash:      sign(key, signing.Envelope(ts, nonce, data)),
+		timestamp: ts,
+		nonce:     nonce,
+	}
+}
+
+func newSignRouter(cfg SignConfig, now func() time.Time) http.Handler {
+	nonces := newNonceCache(cfg.NonceCacheSize)
+	checkSign := func(next http.Handler) http.Handler {
+		return checkReqBodySign(next, cfg, nonces, now)
+	}
+
+	mux := chi.NewRouter()
+	mux.Route("/update", func(r chi.Router) {
+		r.Use(checkSign)
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Post("/{metricType}/{metricName}/{metricVal}", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	mux.Route("/updates", func(r chi.Router) {
+		r.Use(checkSign)
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	return mux
+}
+
+func doSigned(handler http.Handler, url string, body []byte, sig signed) (int, string) {
+	request := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if sig.hash != "" {
+		request.Header.Set("HashSHA256", sig.hash)
+	}
+	if sig.timestamp != "" {
+		request.Header.Set(signing.HeaderTimestamp, sig.timestamp)
+	}
+	if sig.nonce != "" {
+		request.Header.Set(signing.HeaderNonce, sig.nonce)
+	}
+	if sig.keyID != "" {
+		request.Header.Set(keyring.HeaderKeyID, sig.keyID)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	var resBody struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(res.Body).Decode(&resBody)
+
+	return res.StatusCode, resBody.Error
+}
+
+func TestCheckReqBodySign(t *testing.T) {
+	const key = "testkey"
+	body := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	longPath := "/update/counter/PollCount/1"
+	now := time.Unix(1700000000, 0)
+
+	type want struct {
+		code  int
+		error string
+	}
+
+	tests := []struct {
+		name   string
+		strict bool
+		url    string
+		body   []byte
+		sig    signed
+		want   want
+	}{
+		{
+			name: "unsigned passes in normal mode",
+			url:  "/update/",
+			body: body,
+			want: want{code: http.StatusOK},
+		},
+		{
+			name:   "unsigned rejected in strict mode",
+			strict: true,
+			url:    "/update/",
+			body:   body,
+			want:   want{code: http.StatusUnauthorized, error: "HashSHA256 header required"},
+		},
+		{
+			name: "body only signature passes in normal mode",
+			url:  "/update/",
+			body: body,
+			sig:  signed{hash: sign(key, body)},
+			want: want{code: http.StatusOK},
+		},
+		{
+			name:   "body only signature rejected in strict mode",
+			strict: true,
+			url:    "/update/",
+			body:   body,
+			sig:    signed{hash: sign(key, body)},
+			want:   want{code: http.StatusUnauthorized, error: "HashSHA256-Timestamp and HashSHA256-Nonce headers required"},
+		},
+		{
+			name:   "envelope signature passes in strict mode",
+			strict: true,
+			url:    "/update/",
+			body:   body,
+			sig:    signEnv(key, now, "n1", body),
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name: "bad signature rejected in normal mode",
+			url:  "/update/",
+			body: body,
+			sig:  signed{hash: sign("otherkey", body)},
+			want: want{code: http.StatusBadRequest, error: "HashSHA256 signature mismatch"},
+		},
+		{
+			name:   "not hex signature rejected",
+			strict: true,
+			url:    "/update/",
+			body:   body,
+			sig:    signed{hash: "zzz", timestamp: "1700000000", nonce: "n1"},
+			want:   want{code: http.StatusBadRequest, error: "HashSHA256 signature mismatch"},
+		},
+		{
+			name: "changed timestamp breaks signature",
+			url:  "/update/",
+			body: body,
+			sig: func() signed {
+				sig := signEnv(key, now, "n1", body)
+				sig.timestamp = strconv.FormatInt(now.Unix()+1, 10)
+				return sig
+			}(),
+			want: want{code: http.StatusBadRequest, error: "HashSHA256 signature mismatch"},
+		},
+		{
+			name: "nonce without timestamp rejected",
+			url:  "/update/",
+			body: body,
+			sig:  signed{hash: sign(key, body), nonce: "n1"},
+			want: want{code: http.StatusBadRequest, error: "both HashSHA256-Timestamp and HashSHA256-Nonce headers required"},
+		},
+		{
+			name: "stale timestamp rejected",
+			url:  "/update/",
+			body: body,
+			sig:  signEnv(key, now.Add(-time.Hour), "n1", body),
+			want: want{code: http.StatusUnauthorized, error: "request timestamp outside allowed window"},
+		},
+		{
+			name: "future timestamp rejected",
+			url:  "/update/",
+			body: body,
+			sig:  signEnv(key, now.Add(time.Hour), "n1", body),
+			want: want{code: http.StatusUnauthorized, error: "request timestamp outside allowed window"},
+		},
+		{
+			name:   "url form unsigned rejected in strict mode",
+			strict: true,
+			url:    longPath,
+			want:   want{code: http.StatusUnauthorized, error: "HashSHA256 header required"},
+		},
+		{
+			name:   "url form signed by path passes",
+			strict: true,
+			url:    longPath,
+			sig:    signEnv(key, now, "n1", []byte(longPath)),
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name:   "url form signature of other path rejected",
+			strict: true,
+			url:    longPath,
+			sig:    signEnv(key, now, "n1", []byte("/update/counter/PollCount/1000")),
+			want:   want{code: http.StatusBadRequest, error: "HashSHA256 signature mismatch"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newSignRouter(SignConfig{
+				HashKey:        key,
+				Strict:         test.strict,
+				MaxSkew:        time.Minute,
+				NonceCacheSize: 10,
+			}, func() time.Time { return now })
+
+			code, errMsg := doSigned(handler, test.url, test.body, test.sig)
+
+			assert.Equal(t, test.want.code, code)
+			assert.Equal(t, test.want.error, errMsg)
+		})
+	}
+}
+
+func TestCheckReqBodySignReplay(t *testing.T) {
+	const key = "testkey"
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	now := time.Unix(1700000000, 0)
+
+	handler := newSignRouter(SignConfig{
+		HashKey:        key,
+		Strict:         true,
+		MaxSkew:        time.Minute,
+		NonceCacheSize: 10,
+	}, func() time.Time { return now })
+
+	sig := signEnv(key, now, "n1", body)
+
+	code, _ := doSigned(handler, "/updates/", body, sig)
+	assert.Equal(t, http.StatusOK, code)
+
+	// тот же запрос повторно, в том числе на другой маршрут с общим кешем
+	code, errMsg := doSigned(handler, "/updates/", body, sig)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "request nonce already used", errMsg)
+
+	code, _ = doSigned(handler, "/update/", body, sig)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// новый nonce принимается
+	code, _ = doSigned(handler, "/updates/", body, signEnv(key, now, "n2", body))
+	assert.Equal(t, http.StatusOK, code)
+
+	// запрос с неверной подписью не занимает nonce
+	bad := signEnv("otherkey", now, "n3", body)
+	code, _ = doSigned(handler, "/updates/", body, bad)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doSigned(handler, "/updates/", body, signEnv(key, now, "n3", body))
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newNonceCache(3)
+
+	assert.NoError(t, c.Add("a", now.Add(time.Minute), now))
+	assert.ErrorIs(t, c.Add("a", now.Add(time.Minute), now), errNonceUsed)
+
+	// действующие записи не вытесняются: иначе вытесненный nonce можно повторить
+	assert.NoError(t, c.Add("b", now.Add(2*time.Minute), now))
+	assert.NoError(t, c.Add("c", now.Add(30*time.Second), now))
+	assert.ErrorIs(t, c.Add("d", now.Add(time.Minute), now), errNonceCacheFull)
+	assert.Equal(t, 3, c.Len())
+	assert.ErrorIs(t, c.Add("a", now.Add(time.Minute), now), errNonceUsed)
+
+	// просроченная запись в середине освобождает место
+	later := now.Add(45 * time.Second)
+	assert.NoError(t, c.Add("d", later.Add(time.Minute), later))
+	assert.Equal(t, 3, c.Len())
+
+	// просроченные удаляются
+	later = now.Add(3 * time.Minute)
+	assert.NoError(t, c.Add("b", later.Add(time.Minute), later))
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestCheckReqBodySignNonceCacheFull(t *testing.T) {
+	const key = "secret"
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	handler := newSignRouter(SignConfig{HashKey: key, MaxSkew: time.Minute, NonceCacheSize: 1},
+		func() time.Time { return now })
+
+	status, _ := doSigned(handler, "/update/", body, signEnv(key, now, "n1", body))
+	assert.Equal(t, http.StatusOK, status)
+
+	status, msg := doSigned(handler, "/update/", body, signEnv(key, now, "n2", body))
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "nonce cache full", msg)
+
+	// n1 не вытеснен и повтор по-прежнему отклоняется
+	status, _ = doSigned(handler, "/update/", body, signEnv(key, now, "n1", body))
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	now = now.Add(time.Minute + time.Second)
+	status, _ = doSigned(handler, "/update/", body, signEnv(key, now, "n2", body))
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestCheckReqBodySignNoKey(t *testing.T) {
+	handler := CheckReqBodySign(SignConfig{Strict: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader([]byte("{}")))
+	request.Header.Set("HashSHA256", "bad")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCheckReqBodySignKeyRing(t *testing.T) {
+	body := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	now := time.Unix(1700000000, 0)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(t, os.WriteFile(keysFile, []byte("# ротация\nk1 old-secret\nk2 new-secret\n"), 0600))
+
+	ring := keyring.New(keyring.Key{Secret: "legacy"})
+	assert.NoError(t, ring.Load(keysFile))
+
+	handler := newSignRouter(SignConfig{Keys: ring, Strict: true, MaxSkew: time.Minute, NonceCacheSize: 10},
+		func() time.Time { return now })
+
+	withID := func(sig signed, id string) signed {
+		sig.keyID = id
+		return sig
+	}
+
+	tests := []struct {
+		name string
+		sig  signed
+		code int
+	}{
+		{"old key with id", withID(signEnv("old-secret", now, "n1", body), "k1"), http.StatusOK},
+		{"new key with id", withID(signEnv("new-secret", now, "n2", body), "k2"), http.StatusOK},
+		{"new key without id", signEnv("new-secret", now, "n3", body), http.StatusOK},
+		{"legacy -k key without id", signEnv("legacy", now, "n4", body), http.StatusOK},
+		{"key does not match id", withID(signEnv("old-secret", now, "n5", body), "k2"), http.StatusBadRequest},
+		{"unknown id", withID(signEnv("old-secret", now, "n6", body), "k3"), http.StatusUnauthorized},
+		{"unknown key", signEnv("other", now, "n7", body), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := doSigned(handler, "/update/", body, tt.sig)
+			assert.Equal(t, tt.code, code)
+		})
+	}
+
+	// k1 выведен из набора: его подписи больше не принимаются
+	assert.NoError(t, os.WriteFile(keysFile, []byte("k2 new-secret\n"), 0600))
+	assert.NoError(t, ring.Load(keysFile))
+
+	code, _ := doSigned(handler, "/update/", body, withID(signEnv("old-secret", now, "n8", body), "k1"))
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = doSigned(handler, "/update/", body, signEnv("old-secret", now, "n9", body))
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doSigned(handler, "/update/", body, withID(signEnv("new-secret", now, "n10", body), "k2"))
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestSignResponseKeys(t *testing.T) {
+	resBody := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(t, os.WriteFile(keysFile, []byte("k1 old-secret\nk2 new-secret\n"), 0600))
+	ring := keyring.New()
+	assert.NoError(t, ring.Load(keysFile))
+
+	mux := chi.NewRouter()
+	mux.Use(SignResponseKeys(ring))
+	mux.Get("/value/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(resBody)
+	})
+
+	tests := []struct {
+		name   string
+		keyID  string
+		secret string
+		wantID string
+	}{
+		{"key from request", "k2", "new-secret", "k2"},
+		{"no id - primary", "", "old-secret", "k1"},
+		{"unknown id - primary", "k3", "old-secret", "k1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/value/", nil)
+			if tt.keyID != "" {
+				request.Header.Set(keyring.HeaderKeyID, tt.keyID)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, request)
+
+			assert.Equal(t, resBody, w.Body.Bytes())
+			assert.Equal(t, sign(tt.secret, resBody), w.Header().Get("HashSHA256"))
+			assert.Equal(t, tt.wantID, w.Header().Get(keyring.HeaderKeyID))
+		})
+	}
+}
+
+func TestSignResponse(t *testing.T) {
+	const key = "testkey"
+	resBody := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+
+	mux := chi.NewRouter()
+	mux.Use(middleware.Compress(5, "application/json"))
+	mux.Use(SignResponse(key))
+	mux.Get("/value/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusInternalServerError) // повторный код игнорируется
+		w.Write(resBody)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// клиент сам запрашивает и распаковывает gzip - подпись сверяется с несжатым телом
+	res, err := http.Get(srv.URL + "/value/")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, res.Uncompressed)
+	assert.Equal(t, resBody, body)
+	assert.Equal(t, sign(key, resBody), res.Header.Get("HashSHA256"))
+}
+
+func TestDecrypt(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	reqBody := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	gzipWriter.Write(reqBody)
+	gzipWriter.Close()
+
+	encKey, ciphertext, err := encryption.Encrypt(&priv.PublicKey, buf.Bytes())
+	assert.NoError(t, err)
+
+	mux := chi.NewRouter()
+	mux.Use(Decrypt(priv, false))
+	mux.Use(GzipDecompression)
+	mux.Post("/update/", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encKey   string
+		gzip     bool
+		wantCode int
+		wantBody []byte
+	}{
+		{
+			name:     "encrypted gzip body",
+			body:     ciphertext,
+			encKey:   base64.StdEncoding.EncodeToString(encKey),
+			gzip:     true,
+			wantCode: http.StatusOK,
+			wantBody: reqBody,
+		},
+		{
+			name:     "plain body passes",
+			body:     reqBody,
+			wantCode: http.StatusOK,
+			wantBody: reqBody,
+		},
+		{
+			name:     "bad key header",
+			body:     ciphertext,
+			encKey:   "!!!",
+			gzip:     true,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "tampered body",
+			body:     append(append([]byte{}, ciphertext[:len(ciphertext)-1]...), ciphertext[len(ciphertext)-1]^1),
+			encKey:   base64.StdEncoding.EncodeToString(encKey),
+			gzip:     true,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(test.body))
+			if test.encKey != "" {
+				request.Header.Set(encryption.HeaderEncryptedKey, test.encKey)
+			}
+			if test.gzip {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, request)
+
+			assert.Equal(t, test.wantCode, w.Code)
+			if test.wantBody != nil {
+				assert.Equal(t, test.wantBody, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestDecryptRequired(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	reqBody := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	encKey, ciphertext, err := encryption.Encrypt(&priv.PublicKey, reqBody)
+	require.NoError(t, err)
+
+	mux := chi.NewRouter()
+	mux.Use(Decrypt(priv, true))
+	mux.Post("/update/*", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+
+	// тело без X-Encrypted-Key
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(reqBody)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(ciphertext))
+	request.Header.Set(encryption.HeaderEncryptedKey, base64.StdEncoding.EncodeToString(encKey))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, reqBody, w.Body.Bytes())
+
+	// без тела шифровать нечего
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,89 @@
+package middlefunc
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errNonceUsed = errors.New("request nonce already used")
+	// errNonceCacheFull все записи еще действуют: вытеснить живой nonce значит разрешить повтор запроса
+	errNonceCacheFull = errors.New("nonce cache full")
+)
+
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// nonceCache ограниченный кеш использованных nonce.
+// Записи хранятся в порядке добавления, просроченные удаляются с начала.
+// Действующие записи не вытесняются: пока кеш заполнен ими, новые nonce не принимаются
+type nonceCache struct {
+	mu    sync.Mutex
+	size  int
+	seen  map[string]*list.Element
+	order *list.List
+}
+
+func newNonceCache(size int) *nonceCache {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &nonceCache{
+		size:  size,
+		seen:  make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Add запоминает nonce до expires. errNonceUsed - nonce уже использован,
+// errNonceCacheFull - нет места без вытеснения действующих записей
+func (c *nonceCache) Add(nonce string, expires, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.order.Front(); el != nil && !el.Value.(nonceEntry).expires.After(now); el = c.order.Front() {
+		c.remove(el)
+	}
+
+	if el, ok := c.seen[nonce]; ok {
+		if el.Value.(nonceEntry).expires.After(now) {
+			return errNonceUsed
+		}
+		c.remove(el)
+	}
+
+	if c.order.Len() >= c.size {
+		// timestamp запросов разные, просроченные записи могут стоять и за действующими
+		for el := c.order.Front(); el != nil; {
+			next := el.Next()
+			if !el.Value.(nonceEntry).expires.After(now) {
+				c.remove(el)
+			}
+			el = next
+		}
+		if c.order.Len() >= c.size {
+			return errNonceCacheFull
+		}
+	}
+
+	c.seen[nonce] = c.order.PushBack(nonceEntry{nonce: nonce, expires: expires})
+
+	return nil
+}
+
+func (c *nonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *nonceCache) remove(el *list.Element) {
+	delete(c.seen, el.Value.(nonceEntry).nonce)
+	c.order.Remove(el)
+}
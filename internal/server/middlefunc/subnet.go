@@ -0,0 +1,106 @@
+package middlefunc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// SubnetConfig разрешенные сети источников запросов
+type SubnetConfig struct {
+	// Allowed сети, из которых принимаются запросы, пустой список - без проверки
+	Allowed []netip.Prefix
+	// Proxies доверенные прокси: только от них учитываются X-Forwarded-For и X-Real-IP
+	Proxies []netip.Prefix
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// clientAddr адрес источника запроса. Адрес соединения, а если соединение от доверенного прокси -
+// последний недоверенный адрес цепочки X-Forwarded-For (справа налево), без нее - X-Real-IP
+func clientAddr(r *http.Request, proxies []netip.Prefix) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := parseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("bad remote address %q", r.RemoteAddr)
+	}
+
+	if !containsAddr(proxies, peer) {
+		return peer, nil
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		chain := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(chain) - 1; i >= 0; i-- {
+			addr, err := parseAddr(chain[i])
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("bad X-Forwarded-For address %q", chain[i])
+			}
+			if !containsAddr(proxies, addr) {
+				return addr, nil
+			}
+		}
+		// вся цепочка из доверенных прокси - клиентом считается первый адрес
+		return parseAddr(chain[0])
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		addr, err := parseAddr(realIP)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("bad X-Real-IP address %q", realIP)
+		}
+		return addr, nil
+	}
+
+	return peer, nil
+}
+
+// TrustedSubnet пропускает только запросы из cfg.Allowed, остальные - 403.
+// Заголовкам X-Forwarded-For и X-Real-IP верим только от cfg.Proxies,
+// иначе любой клиент мог бы назваться адресом из разрешенной сети
+func TrustedSubnet(cfg SubnetConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(cfg.Allowed) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := clientAddr(r, cfg.Proxies)
+			if err != nil {
+				log.Info().Err(err).Msg("TrustedSubnet")
+				writeError(w, http.StatusForbidden, errors.New("can not determine source address"))
+				return
+			}
+
+			if !containsAddr(cfg.Allowed, addr) {
+				log.Info().Str("addr", addr.String()).Msg("TrustedSubnet source rejected")
+				writeError(w, http.StatusForbidden, fmt.Errorf("source %s is not in trusted subnet", addr))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
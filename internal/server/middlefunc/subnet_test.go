@@ -0,0 +1,72 @@
+package middlefunc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	cfg := SubnetConfig{
+		Allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")},
+		Proxies: []netip.Prefix{netip.MustParsePrefix("192.168.1.1/32"), netip.MustParsePrefix("10.0.0.254/32")},
+	}
+	handler := TrustedSubnet(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       int
+	}{
+		{"peer in subnet", "10.1.2.3:5000", nil, "", http.StatusOK},
+		{"ipv6 peer in subnet", "[fd00::1]:5000", nil, "", http.StatusOK},
+		{"ipv4-mapped peer in subnet", "[::ffff:10.1.2.3]:5000", nil, "", http.StatusOK},
+		{"peer outside subnet", "172.16.0.1:5000", nil, "", http.StatusForbidden},
+		// без доверенного прокси заголовкам не верим
+		{"spoofed X-Real-IP", "172.16.0.1:5000", nil, "10.1.2.3", http.StatusForbidden},
+		{"spoofed X-Forwarded-For", "172.16.0.1:5000", []string{"10.1.2.3"}, "", http.StatusForbidden},
+		{"untrusted peer in subnet ignores headers", "10.1.2.3:5000", nil, "172.16.0.1", http.StatusOK},
+		{"X-Real-IP from proxy", "192.168.1.1:5000", nil, "10.1.2.3", http.StatusOK},
+		{"X-Real-IP from proxy rejected", "192.168.1.1:5000", nil, "172.16.0.1", http.StatusForbidden},
+		{"bad X-Real-IP from proxy", "192.168.1.1:5000", nil, "bad", http.StatusForbidden},
+		{"proxy without headers", "192.168.1.1:5000", nil, "", http.StatusForbidden},
+		{"X-Forwarded-For chain", "192.168.1.1:5000", []string{"172.16.0.9, 10.1.2.3, 10.0.0.254"}, "", http.StatusOK},
+		// клиент дописал себе адрес слева - берется правый недоверенный
+		{"X-Forwarded-For spoofed left", "192.168.1.1:5000", []string{"10.1.2.3, 172.16.0.9"}, "", http.StatusForbidden},
+		{"X-Forwarded-For several headers", "192.168.1.1:5000", []string{"172.16.0.9", "10.1.2.3"}, "", http.StatusOK},
+		{"X-Forwarded-For wins over X-Real-IP", "192.168.1.1:5000", []string{"172.16.0.9"}, "10.1.2.3", http.StatusForbidden},
+		{"bad X-Forwarded-For", "192.168.1.1:5000", []string{"bad"}, "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				request.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestTrustedSubnetDisabled(t *testing.T) {
+	handler := TrustedSubnet(SubnetConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+	request.RemoteAddr = "172.16.0.1:5000"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,14 @@
+-- +goose Up
+
+-- токены агентов, хранится только sha256 секрета
+CREATE TABLE IF NOT EXISTS tokens
+(
+    id          varchar(16) PRIMARY KEY,
+    name        text NOT NULL DEFAULT '',
+    hash        char(64) UNIQUE NOT NULL,
+    scope       varchar(10) NOT NULL,
+    prefix      varchar(40) NOT NULL DEFAULT '',
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+
+-- +goose Down
@@ -1,16 +1,15 @@
 package migrations
 
 import (
+	"database/sql"
 	"embed"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
 	"github.com/pressly/goose/v3"
 )
 
 //go:embed *.sql
 var SQLFiles embed.FS
 
-func ApplyMigrations() error {
-	db := storage.DBstorage.DBconn
+func ApplyMigrations(db *sql.DB) error {
 	fsys := SQLFiles
 
 	goose.SetBaseFS(fsys)
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
+	"net/http"
+)
+
+type createTokenRequest struct {
+	Name   string       `json:"name"`
+	Scope  tokens.Scope `json:"scope"`
+	Prefix string       `json:"prefix"`
+}
+
+// createTokenResponse описание токена и секрет, секрет больше нигде не показывается
+type createTokenResponse struct {
+	tokens.Token
+	Secret string `json:"token"`
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		s.logger.Info().Err(err).Msg("writeJSON")
+	}
+}
+
+func (s *Server) writeJSONError(w http.ResponseWriter, status int, err error) {
+	s.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// CreateTokenHandler POST /admin/tokens {"name", "scope", "prefix"} - выпуск токена
+func (s *Server) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req createTokenRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, fmt.Errorf("bad request body: %w", err))
+		return
+	}
+
+	if len(req.Prefix) > metricname.MaxLen {
+		s.writeJSONError(w, http.StatusBadRequest, fmt.Errorf("prefix longer than %d", metricname.MaxLen))
+		return
+	}
+
+	token, secret, err := tokens.Generate(req.Name, req.Scope, req.Prefix)
+	if err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = s.tokens.Create(token); err != nil {
+		s.logger.Info().Err(err).Msg("tokens Create")
+		s.writeJSONError(w, http.StatusInternalServerError, errors.New("can not store token"))
+		return
+	}
+
+	s.logger.Info().
+		Str("id", token.ID).
+		Str("name", token.Name).
+		Str("scope", string(token.Scope)).
+		Str("prefix", token.Prefix).
+		Msg("token created")
+
+	s.writeJSON(w, http.StatusCreated, createTokenResponse{Token: token, Secret: secret})
+}
+
+// ListTokensHandler GET /admin/tokens - токены без секретов
+func (s *Server) ListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := s.tokens.List()
+	if err != nil {
+		s.logger.Info().Err(err).Msg("tokens List")
+		s.writeJSONError(w, http.StatusInternalServerError, errors.New("can not list tokens"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, list)
+}
+
+// RevokeTokenHandler DELETE /admin/tokens/{tokenID} - отзыв токена, действует сразу
+func (s *Server) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "tokenID")
+
+	err := s.tokens.Revoke(id)
+	if errors.Is(err, tokens.ErrNotFound) {
+		s.writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.logger.Info().Err(err).Msg("tokens Revoke")
+		s.writeJSONError(w, http.StatusInternalServerError, errors.New("can not revoke token"))
+		return
+	}
+
+	s.logger.Info().Str("id", id).Msg("token revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CardinalityHandler GET /admin/cardinality - число различных метрик всего и по источникам.
+// С токенами нужен токен admin, без токенов - адрес из доверенной сети, как для записи
+func (s *Server) CardinalityHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.series.Stats())
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/cardinality"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "bootstrap-secret"
+
+func newAuthTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cfg := flags.NewConfig()
+	cfg.AdminToken = testAdminToken
+	srv := New(cfg, storage.NewMemStore(""), zerolog.Nop(), WithTokens(tokens.NewMemStore("")))
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func doAuth(t *testing.T, method, url, token, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res.StatusCode, string(resBody)
+}
+
+func createToken(t *testing.T, ts *httptest.Server, body string) createTokenResponse {
+	t.Helper()
+
+	status, resBody := doAuth(t, http.MethodPost, ts.URL+"/admin/tokens/", testAdminToken, body)
+	require.Equal(t, http.StatusCreated, status, resBody)
+
+	var result createTokenResponse
+	require.NoError(t, json.Unmarshal([]byte(resBody), &result))
+	require.NotEmpty(t, result.Secret)
+
+	return result
+}
+
+func TestTokenScopes(t *testing.T) {
+	ts := newAuthTestServer(t)
+
+	writer := createToken(t, ts, `{"name":"agent","scope":"write"}`)
+	reader := createToken(t, ts, `{"name":"dashboard","scope":"read"}`)
+
+	update := ts.URL + "/update/counter/PollCount/1"
+	value := ts.URL + "/value/counter/PollCount"
+
+	status, _ := doAuth(t, http.MethodPost, update, "", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doAuth(t, http.MethodPost, update, "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doAuth(t, http.MethodPost, update, reader.Secret, "")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = doAuth(t, http.MethodPost, update, writer.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = doAuth(t, http.MethodGet, value, "", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, body := doAuth(t, http.MethodGet, value, reader.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "1", body)
+
+	// write включает read
+	status, _ = doAuth(t, http.MethodGet, value, writer.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/updates/", reader.Secret, `[]`)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// admin API только для admin
+	status, _ = doAuth(t, http.MethodGet, ts.URL+"/admin/tokens/", writer.Secret, "")
+	assert.Equal(t, http.StatusForbidden, status)
+}
+
+func TestTokenPrefix(t *testing.T) {
+	ts := newAuthTestServer(t)
+
+	host1 := createToken(t, ts, `{"name":"host1","scope":"write","prefix":"host1."}`)
+
+	status, _ := doAuth(t, http.MethodPost, ts.URL+"/update/gauge/host1.Alloc/1.5", host1.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/host2.Alloc/1.5", host1.Secret, "")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/", host1.Secret,
+		`{"id":"host2.Alloc","type":"gauge","value":1}`)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// одна чужая метрика отклоняет весь пакет
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/updates/", host1.Secret,
+		`[{"id":"host1.Count","type":"counter","delta":1},{"id":"host2.Count","type":"counter","delta":1}]`)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/host2.Alloc/2", testAdminToken, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	// в ответе /updates только свои метрики
+	status, body := doAuth(t, http.MethodPost, ts.URL+"/updates/", host1.Secret,
+		`[{"id":"host1.Count","type":"counter","delta":1}]`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "host1.Count")
+	assert.NotContains(t, body, "host2.Alloc")
+
+	status, _ = doAuth(t, http.MethodGet, ts.URL+"/value/gauge/host2.Alloc", host1.Secret, "")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/value/", host1.Secret, `{"id":"host2.Alloc","type":"gauge"}`)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// страница со всеми метриками требует токен и показывает только его метрики
+	status, _ = doAuth(t, http.MethodGet, ts.URL+"/", "", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, body = doAuth(t, http.MethodGet, ts.URL+"/", host1.Secret, "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "host1.Alloc")
+	assert.NotContains(t, body, "host2.Alloc")
+
+	status, body = doAuth(t, http.MethodGet, ts.URL+"/", testAdminToken, "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "host2.Alloc")
+}
+
+func TestAdminTokens(t *testing.T) {
+	ts := newAuthTestServer(t)
+
+	status, _ := doAuth(t, http.MethodPost, ts.URL+"/admin/tokens/", testAdminToken, `{"name":"x","scope":"root"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/admin/tokens/", testAdminToken, `{"name":"x","scope":"read","prefix":"`+
+		strings.Repeat("a", metricname.MaxLen+1)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	created := createToken(t, ts, `{"name":"agent","scope":"write","prefix":"host1."}`)
+	admin := createToken(t, ts, `{"name":"ops","scope":"admin"}`)
+
+	// выпущенный admin токен управляет токенами
+	status, body := doAuth(t, http.MethodGet, ts.URL+"/admin/tokens/", admin.Secret, "")
+	require.Equal(t, http.StatusOK, status)
+	assert.NotContains(t, body, created.Secret)
+	assert.NotContains(t, body, tokens.HashSecret(created.Secret))
+
+	var list []tokens.Token
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Len(t, list, 2)
+	ids := []string{list[0].ID, list[1].ID}
+	assert.ElementsMatch(t, []string{created.ID, admin.ID}, ids)
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/host1.Alloc/1", created.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = doAuth(t, http.MethodDelete, ts.URL+"/admin/tokens/"+created.ID, admin.Secret, "")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, _ = doAuth(t, http.MethodDelete, ts.URL+"/admin/tokens/"+created.ID, admin.Secret, "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// отозванный токен сразу перестает работать
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/host1.Alloc/1", created.Secret, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestNoTokens(t *testing.T) {
+	// без хранилища токенов все как раньше, admin API не подключен
+	ts := httptest.NewServer(newTestServer().Handler())
+	defer ts.Close()
+
+	status, _ := doAuth(t, http.MethodPost, ts.URL+"/update/counter/PollCount/1", "", "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = doAuth(t, http.MethodGet, ts.URL+"/admin/tokens/", testAdminToken, "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestMetricLimits(t *testing.T) {
+	cfg := flags.NewConfig()
+	cfg.AdminToken = testAdminToken
+	cfg.MaxSeries = 4
+	cfg.MaxSeriesPerSource = 2
+
+	repo := storage.NewMemStore("")
+	repo.SetGauge("Existing", 1)
+	srv := New(cfg, repo, zerolog.Nop(), WithTokens(tokens.NewMemStore("")))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	agent1 := createToken(t, ts, `{"name":"agent1","scope":"write"}`)
+	agent2 := createToken(t, ts, `{"name":"agent2","scope":"write"}`)
+
+	status, body := doAuth(t, http.MethodPost, ts.URL+"/update/gauge/"+strings.Repeat("a", 41)+"/1", agent1.Secret, "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "longer than 40")
+
+	status, body = doAuth(t, http.MethodPost, ts.URL+"/update/", agent1.Secret, `{"id":"bad name","type":"gauge","value":1}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "invalid character")
+
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/updates/", agent1.Secret,
+		`[{"id":"A1","type":"gauge","value":1},{"id":"A2","type":"gauge","value":1}]`)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, body = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/A3/1", agent1.Secret, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, body, "source token:"+agent1.ID)
+
+	// обновлять свои метрики можно и на лимите
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/A1/2", agent1.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	// Existing, A1, A2 + B1 = 4 - общий лимит исчерпан
+	status, _ = doAuth(t, http.MethodPost, ts.URL+"/update/gauge/B1/1", agent2.Secret, "")
+	assert.Equal(t, http.StatusOK, status)
+	status, body = doAuth(t, http.MethodPost, ts.URL+"/update/counter/B2/1", agent2.Secret, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, body, "server already stores 4")
+
+	_, ok, _ := repo.GetGauge("A3")
+	assert.False(t, ok)
+
+	status, _ = doAuth(t, http.MethodGet, ts.URL+"/admin/cardinality", agent1.Secret, "")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, body = doAuth(t, http.MethodGet, ts.URL+"/admin/cardinality", testAdminToken, "")
+	require.Equal(t, http.StatusOK, status)
+
+	var stats cardinality.Stats
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	assert.Equal(t, cardinality.Stats{
+		Series:       4,
+		MaxSeries:    4,
+		MaxPerSource: 2,
+		Sources: []cardinality.SourceStats{
+			{Source: "token:" + agent1.ID, Series: 2},
+			{Source: "token:" + agent2.ID, Series: 1},
+		},
+	}, stats)
+}
+
+func TestCardinalityWithoutTokens(t *testing.T) {
+	cfg := flags.NewConfig()
+	ts := httptest.NewServer(New(cfg, storage.NewMemStore(""), zerolog.Nop()).Handler())
+	defer ts.Close()
+
+	status, _ := doAuth(t, http.MethodPost, ts.URL+"/update/gauge/A1/1", "", "")
+	require.Equal(t, http.StatusOK, status)
+
+	status, body := doAuth(t, http.MethodGet, ts.URL+"/admin/cardinality", "", "")
+	require.Equal(t, http.StatusOK, status)
+	var stats cardinality.Stats
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	assert.Equal(t, 1, stats.Series)
+
+	// остальной admin API без токенов не подключается
+	status, _ = doAuth(t, http.MethodGet, ts.URL+"/admin/tokens/", "", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// с -t кардинальность видна только из доверенных сетей
+	cfg.TrustedNets = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	ts2 := httptest.NewServer(New(cfg, storage.NewMemStore(""), zerolog.Nop()).Handler())
+	defer ts2.Close()
+
+	status, _ = doAuth(t, http.MethodGet, ts2.URL+"/admin/cardinality", "", "")
+	assert.Equal(t, http.StatusForbidden, status)
+}
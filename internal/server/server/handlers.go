@@ -1,14 +1,16 @@
-package handlers
+package server
 
 import (
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/metricname"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/cardinality"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/middlefunc"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/models"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
-	"github.com/rs/zerolog/log"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
 	"net/http"
 	"strconv"
 	"strings"
@@ -21,14 +23,6 @@ type responseData struct {
 	size   int
 }
 
-func GetStore() storage.Storer {
-	if flags.StorePoint.DataBase {
-		return storage.DBstorage
-	} else {
-		return storage.MemStorage
-	}
-}
-
 // добавляем кастомную реализацию http.ResponseWriter
 type loggingResponseWriter struct {
 	// встраиваем оригинальный http.ResponseWriter
@@ -56,7 +50,7 @@ func (r *loggingResponseWriter) WriteHeaderStatus(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
-func logHTTPResult(start time.Time, lw loggingResponseWriter, r http.Request,
+func (s *Server) logHTTPResult(start time.Time, lw loggingResponseWriter, r http.Request,
 	Req []models.Metrics,
 	Res []models.Metrics,
 	optErr ...error) {
@@ -66,7 +60,7 @@ func logHTTPResult(start time.Time, lw loggingResponseWriter, r http.Request,
 	}
 
 	for _, v := range Req {
-		log.Info().
+		s.logger.Info().
 			Str("URI", r.URL.Path).
 			Str("Method", r.Method).
 			Str("Header-HashSHA256", r.Header.Get("HashSHA256")).
@@ -76,7 +70,7 @@ func logHTTPResult(start time.Time, lw loggingResponseWriter, r http.Request,
 	}
 
 	for _, v := range Res {
-		log.Info().
+		s.logger.Info().
 			Str("Status", strconv.Itoa(lw.responseData.status)).
 			Str("Content-Length", strconv.Itoa(lw.responseData.size)).
 			Str("Res", v.String()).
@@ -85,19 +79,71 @@ func logHTTPResult(start time.Time, lw loggingResponseWriter, r http.Request,
 	}
 }
 
+// writeError ответ об ошибке в виде {"error": "..."}
+func (r *loggingResponseWriter) writeError(statusCode int, err error) {
+	r.Header().Set("Content-Type", "application/json")
+	r.WriteHeaderStatus(statusCode)
+	json.NewEncoder(r).Encode(map[string]string{"error": err.Error()})
+}
+
+// admitMetrics проверяет метрики до записи: тип, значение, имя и ограничения числа различных метрик.
+// Возвращает HTTP статус ошибки. После записи вызывается Commit резерва, при ошибке записи - Cancel
+func (s *Server) admitMetrics(r *http.Request, metrics []models.Metrics) (*cardinality.Reservation, int, error) {
+	series := make([]string, 0, len(metrics))
+
+	for _, m := range metrics {
+		if err := metricname.Validate(m.ID); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		switch {
+		case m.MType == "gauge" && m.Value == nil:
+			return nil, http.StatusBadRequest, fmt.Errorf("bad gauge value for %s", m.ID)
+		case m.MType == "counter" && m.Delta == nil:
+			return nil, http.StatusBadRequest, fmt.Errorf("bad counter delta for %s", m.ID)
+		case m.MType != "gauge" && m.MType != "counter":
+			return nil, http.StatusBadRequest, fmt.Errorf("bad metric type %q for %s", m.MType, m.ID)
+		}
+
+		series = append(series, cardinality.Series(m.MType, m.ID))
+	}
+
+	res, err := s.series.Admit(middlefunc.SourceKey(r, s.cfg.ProxyNets), series...)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	return res, 0, nil
+}
+
+// checkTokenMetric ошибка, если токен запроса ограничен префиксом и метрика под него не подходит.
+// Без токена (авторизация выключена) разрешено все
+func checkTokenMetric(r *http.Request, name string) error {
+	token, ok := tokens.FromContext(r.Context())
+	if !ok || token.AllowsMetric(name) {
+		return nil
+	}
+
+	return fmt.Errorf("token %s is limited to metrics with prefix %q", token.ID, token.Prefix)
+}
+
 func UpdateMetric(reqJSON models.Metrics, repo storage.Storer) error {
 	if reqJSON.MType == "gauge" {
 		value := reqJSON.Value
 		if value == nil {
 			return fmt.Errorf("bad gauge value")
 		}
-		repo.SetGauge(reqJSON.ID, storage.Gauge(*value))
+		if err := repo.SetGauge(reqJSON.ID, storage.Gauge(*value)); err != nil {
+			return err
+		}
 	} else if reqJSON.MType == "counter" {
 		value := reqJSON.Delta
 		if value == nil {
 			return fmt.Errorf("bad counetr delta")
 		}
-		repo.UpdateCounter(reqJSON.ID, storage.Counter(*value))
+		if err := repo.UpdateCounter(reqJSON.ID, storage.Counter(*value)); err != nil {
+			return err
+		}
 	} else {
 		return fmt.Errorf("bad metric type: %s", reqJSON.MType)
 	}
@@ -105,7 +151,7 @@ func UpdateMetric(reqJSON models.Metrics, repo storage.Storer) error {
 	return nil
 }
 
-func UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
 	var (
 		valCounter       storage.Counter
 		valGauge         storage.Gauge
@@ -113,7 +159,7 @@ func UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
 		reqJSON, resJSON models.Metrics
 	)
 	start := time.Now()
-	repo := GetStore()
+	repo := s.repo
 
 	responseData := &responseData{
 		status: 0,
@@ -127,11 +173,17 @@ func UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
 	reqJSON.ID = chi.URLParam(r, "metricName")
 	reqJSON.MType = chi.URLParam(r, "metricType")
 
+	if err := checkTokenMetric(r, reqJSON.ID); err != nil {
+		lw.WriteHeaderStatus(http.StatusForbidden)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
 	if reqJSON.MType == "counter" {
 		counterVal, err := strconv.ParseInt(chi.URLParam(r, "metricVal"), 10, 64)
 		if err != nil {
 			lw.WriteHeaderStatus(http.StatusBadRequest)
-			logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+			s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 			return
 		}
 		reqJSON.Delta = &counterVal
@@ -139,26 +191,35 @@ func UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
 		gaugeVal, err := strconv.ParseFloat(chi.URLParam(r, "metricVal"), 64)
 		if err != nil {
 			lw.WriteHeaderStatus(http.StatusBadRequest)
-			logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+			s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 			return
 		}
 		reqJSON.Value = &gaugeVal
 	} else {
 		err := fmt.Errorf("can not get val for %v from repo", reqJSON.MType)
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
 	lw.Header().Set("Content-Type", "application/json")
 	lw.Header().Set("Date", time.Now().String())
 
-	err := UpdateMetric(reqJSON, repo)
+	admitted, status, err := s.admitMetrics(r, []models.Metrics{reqJSON})
+	if err != nil {
+		lw.writeError(status, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
+	err = UpdateMetric(reqJSON, repo)
 	if err != nil {
+		admitted.Cancel()
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
+	admitted.Commit()
 
 	resJSON = reqJSON
 	if resJSON.MType == "counter" {
@@ -174,7 +235,7 @@ func UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
 	} else {
 		err := fmt.Errorf("can not get val for %v from repo", reqJSON.ID)
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
@@ -184,19 +245,19 @@ func UpdateHandlerLong(w http.ResponseWriter, r *http.Request) {
 		lw.WriteHeaderStatus(http.StatusNotFound)
 	}
 
-	if flags.FlagStoreInterval == 0 && flags.StorePoint.File {
+	if s.cfg.StoreInterval == 0 {
 		err = repo.StoreMetrics()
 		if err != nil {
 			lw.WriteHeaderStatus(http.StatusInternalServerError)
-			logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+			s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 			return
 		}
 	}
 
-	logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
+	s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
 }
 
-func UpdateHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		reqJSON, resJSON models.Metrics
 		valCounter       storage.Counter
@@ -205,7 +266,7 @@ func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 		err              error
 	)
 	start := time.Now()
-	repo := GetStore()
+	repo := s.repo
 
 	responseData := &responseData{
 		status: 0,
@@ -219,19 +280,34 @@ func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&reqJSON); err != nil {
 		lw.WriteHeaderStatus(http.StatusInternalServerError)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
+	if err := checkTokenMetric(r, reqJSON.ID); err != nil {
+		lw.WriteHeaderStatus(http.StatusForbidden)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
 	lw.Header().Set("Content-Type", "application/json")
 	lw.Header().Set("Date", time.Now().String())
 
+	admitted, status, err := s.admitMetrics(r, []models.Metrics{reqJSON})
+	if err != nil {
+		lw.writeError(status, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
 	err = UpdateMetric(reqJSON, repo)
 	if err != nil {
+		admitted.Cancel()
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
+	admitted.Commit()
 
 	resJSON.ID = reqJSON.ID
 	resJSON.MType = reqJSON.MType
@@ -249,7 +325,7 @@ func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		err := fmt.Errorf("can not get val for %v from repo", resJSON.ID)
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
@@ -259,30 +335,30 @@ func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(resJSON); err != nil {
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
-	if flags.FlagStoreInterval == 0 && flags.StorePoint.File {
+	if s.cfg.StoreInterval == 0 {
 		err = repo.StoreMetrics()
 		if err != nil {
 			lw.WriteHeaderStatus(http.StatusInternalServerError)
-			logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+			s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 			return
 		}
 	}
 
-	logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
+	s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
 }
 
 // UpdatesHandler обновление метрик - при условии получения их в виде массива
-func UpdatesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UpdatesHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		reqJSON, resJSON []models.Metrics
 		err              error
 	)
 
-	repo := GetStore()
+	repo := s.repo
 
 	type responseBody struct {
 		Description string `json:"description"` // имя метрики
@@ -302,24 +378,52 @@ func UpdatesHandler(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&reqJSON); err != nil {
 		lw.WriteHeaderStatus(http.StatusInternalServerError)
-		logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
+		s.logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
 		return
 	}
 
+	if s.cfg.MaxBatch > 0 && len(reqJSON) > s.cfg.MaxBatch {
+		err = fmt.Errorf("batch of %d metrics exceeds limit %d", len(reqJSON), s.cfg.MaxBatch)
+		lw.writeError(http.StatusRequestEntityTooLarge, err)
+		s.logHTTPResult(start, lw, *r, nil, resJSON, err)
+		return
+	}
+
+	// пакет принимается целиком или не принимается
+	for _, v := range reqJSON {
+		if err := checkTokenMetric(r, v.ID); err != nil {
+			lw.WriteHeaderStatus(http.StatusForbidden)
+			s.logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
+			return
+		}
+	}
+
 	lw.Header().Set("Content-Type", "application/json")
 	lw.Header().Set("Date", time.Now().String())
 
+	admitted, status, err := s.admitMetrics(r, reqJSON)
+	if err != nil {
+		lw.writeError(status, err)
+		s.logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
+		return
+	}
+
 	err = repo.UpdateMetricBatch(reqJSON)
 	if err != nil {
+		admitted.Cancel()
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
-		log.Info().Err(err).Msg("DB UpdateMetricBatch error")
+		s.logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
+		s.logger.Info().Err(err).Msg("DB UpdateMetricBatch error")
 		return
 	}
+	admitted.Commit()
 
 	allMetrics, _ := repo.GetAllMetrics()
 
 	for k, v := range allMetrics.Gauges {
+		if checkTokenMetric(r, k) != nil {
+			continue
+		}
 		tempV := float64(v)
 		metrics := models.Metrics{
 			ID:    k,
@@ -330,6 +434,9 @@ func UpdatesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for k, v := range allMetrics.Counters {
+		if checkTokenMetric(r, k) != nil {
+			continue
+		}
 		tempV := int64(v)
 		metrics := models.Metrics{
 			ID:    k,
@@ -345,14 +452,14 @@ func UpdatesHandler(w http.ResponseWriter, r *http.Request) {
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(resJSON); err != nil {
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
+		s.logHTTPResult(start, lw, *r, reqJSON, resJSON, err)
 		return
 	}
 
-	logHTTPResult(start, lw, *r, reqJSON, resJSON)
+	s.logHTTPResult(start, lw, *r, reqJSON, resJSON)
 }
 
-func ValueHandlerLong(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ValueHandlerLong(w http.ResponseWriter, r *http.Request) {
 	var (
 		valCounter       storage.Counter
 		valGauge         storage.Gauge
@@ -361,7 +468,7 @@ func ValueHandlerLong(w http.ResponseWriter, r *http.Request) {
 		reqJSON, resJSON models.Metrics
 	)
 	start := time.Now()
-	repo := GetStore()
+	repo := s.repo
 
 	responseData := &responseData{
 		status: 0,
@@ -375,6 +482,12 @@ func ValueHandlerLong(w http.ResponseWriter, r *http.Request) {
 	reqJSON.ID = chi.URLParam(r, "metricName")
 	reqJSON.MType = chi.URLParam(r, "metricType")
 
+	if err := checkTokenMetric(r, reqJSON.ID); err != nil {
+		lw.WriteHeaderStatus(http.StatusForbidden)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
 	lw.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	lw.Header().Set("Date", time.Now().String())
 
@@ -391,7 +504,7 @@ func ValueHandlerLong(w http.ResponseWriter, r *http.Request) {
 	} else {
 		err := fmt.Errorf("can not get val for %v from repo", reqJSON.ID)
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
@@ -402,11 +515,11 @@ func ValueHandlerLong(w http.ResponseWriter, r *http.Request) {
 		lw.WriteHeaderStatus(http.StatusNotFound)
 	}
 
-	logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
+	s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
 
 }
 
-func ValueHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ValueHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		valCounter       storage.Counter
 		valGauge         storage.Gauge
@@ -415,7 +528,7 @@ func ValueHandler(w http.ResponseWriter, r *http.Request) {
 		reqJSON, resJSON models.Metrics
 	)
 	start := time.Now()
-	repo := GetStore()
+	repo := s.repo
 
 	responseData := &responseData{
 		status: 0,
@@ -429,7 +542,13 @@ func ValueHandler(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	if err = dec.Decode(&reqJSON); err != nil {
 		lw.WriteHeaderStatus(http.StatusInternalServerError)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
+	if err = checkTokenMetric(r, reqJSON.ID); err != nil {
+		lw.WriteHeaderStatus(http.StatusForbidden)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
@@ -452,7 +571,7 @@ func ValueHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		err = fmt.Errorf("can not get val for %v from repo", resJSON.MType)
 		lw.WriteHeaderStatus(http.StatusBadRequest)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
@@ -462,7 +581,7 @@ func ValueHandler(w http.ResponseWriter, r *http.Request) {
 		enc.SetIndent("", "  ")
 		if err := enc.Encode(resJSON); err != nil {
 			lw.WriteHeaderStatus(http.StatusBadRequest)
-			logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+			s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 			return
 		}
 	} else {
@@ -470,14 +589,14 @@ func ValueHandler(w http.ResponseWriter, r *http.Request) {
 		lw.WriteHeaderStatus(http.StatusNotFound)
 	}
 
-	logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+	s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 }
 
-func RootHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RootHandler(w http.ResponseWriter, r *http.Request) {
 	var reqJSON, resJSON models.Metrics
 
 	start := time.Now()
-	repo := GetStore()
+	repo := s.repo
 
 	responseData := &responseData{
 		status: 0,
@@ -491,8 +610,21 @@ func RootHandler(w http.ResponseWriter, r *http.Request) {
 	lw.Header().Set("Content-Type", "text/html")
 	lw.Header().Set("Date", time.Now().String())
 
-	WebPage1, _ := gauges2String(repo.GetGauges())
-	WebPage2, _ := сounters2String(repo.GetCounters())
+	gauges, _ := repo.GetGauges()
+	counters, _ := repo.GetCounters()
+	for k := range gauges {
+		if checkTokenMetric(r, k) != nil {
+			delete(gauges, k)
+		}
+	}
+	for k := range counters {
+		if checkTokenMetric(r, k) != nil {
+			delete(counters, k)
+		}
+	}
+
+	WebPage1, _ := gauges2String(gauges, nil)
+	WebPage2, _ := сounters2String(counters, nil)
 	WebPage := fmt.Sprintf(`<!DOCTYPE html>
 	<html lang="en">
 	<head>
@@ -514,18 +646,17 @@ func RootHandler(w http.ResponseWriter, r *http.Request) {
 	lw.WriteHeaderStatus(http.StatusOK)
 
 	if _, err := lw.Write(data); err != nil {
-		log.Info().Err(err).Msg("RootHandler")
+		s.logger.Info().Err(err).Msg("RootHandler")
 		lw.WriteHeaderStatus(http.StatusInternalServerError)
 		return
 	}
 
-	logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
+	s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON})
 }
 
-func PingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PingHandler(w http.ResponseWriter, r *http.Request) {
 	var reqJSON, resJSON models.Metrics
 
-	db := storage.DBstorage.DBconn
 	start := time.Now()
 
 	responseData := &responseData{
@@ -537,15 +668,23 @@ func PingHandler(w http.ResponseWriter, r *http.Request) {
 		responseData:   responseData,
 	}
 
-	err := storage.PingDB(db)
+	pinger, ok := s.repo.(storage.Pinger)
+	if !ok {
+		err := errors.New("storage does not support ping")
+		lw.WriteHeaderStatus(http.StatusInternalServerError)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		return
+	}
+
+	err := pinger.Ping()
 	if err != nil {
 		lw.WriteHeaderStatus(http.StatusInternalServerError)
-		logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+		s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 		return
 	}
 
 	lw.WriteHeaderStatus(http.StatusOK)
-	logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
+	s.logHTTPResult(start, lw, *r, []models.Metrics{reqJSON}, []models.Metrics{resJSON}, err)
 }
 
 func сounters2String(mapCounters map[string]storage.Counter, _ error) (string, error) {
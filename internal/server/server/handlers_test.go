@@ -1,4 +1,4 @@
-package handlers
+package server
 
 import (
 	"bytes"
@@ -7,7 +7,11 @@ import (
 	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/middlefunc"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
@@ -28,6 +32,10 @@ type metricsSendBad struct {
 	Value string `json:"value,omitempty"` // значение метрики в случае передачи gauge
 }
 
+func newTestServer() *Server {
+	return New(flags.NewConfig(), storage.NewMemStore(""), zerolog.Nop())
+}
+
 func TestUpdateHandler1(t *testing.T) {
 
 	type want struct {
@@ -74,7 +82,7 @@ func TestUpdateHandler1(t *testing.T) {
 			mux := chi.NewRouter()
 			mux.Use(middleware.Logger)
 
-			mux.Post("/update/", UpdateHandler)
+			mux.Post("/update/", newTestServer().UpdateHandler)
 
 			reqBody, _ := json.Marshal(test.body)
 
@@ -147,7 +155,7 @@ func TestUpdateHandler2(t *testing.T) {
 			mux := chi.NewRouter()
 			mux.Use(middleware.Logger)
 
-			mux.Post("/update/", UpdateHandler)
+			mux.Post("/update/", newTestServer().UpdateHandler)
 
 			reqBody, _ := json.Marshal(test.body)
 
@@ -214,7 +222,7 @@ func TestGzipCompression3(t *testing.T) {
 	mux.Use(middlefunc.GzipDecompression)
 	mux.Use(middleware.Compress(5, "application/json"))
 
-	mux.Post("/update/", UpdateHandler)
+	mux.Post("/update/", newTestServer().UpdateHandler)
 
 	for _, test := range tests {
 		// sends_gzip
@@ -247,3 +255,34 @@ func TestGzipCompression3(t *testing.T) {
 		})
 	}
 }
+
+// failingStore хранилище, запись в которое не удается
+type failingStore struct {
+	*storage.MemStore
+}
+
+func (failingStore) SetGauge(name string, value storage.Gauge) error {
+	return errors.New("write failed")
+}
+
+func (failingStore) UpdateMetricBatch([]models.Metrics) error {
+	return errors.New("write failed")
+}
+
+func TestAdmitAfterWrite(t *testing.T) {
+	cfg := flags.NewConfig()
+	cfg.MaxSeries = 1
+	srv := New(cfg, failingStore{storage.NewMemStore("")}, zerolog.Nop())
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/update/gauge/Failed1/1", nil),
+		httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(`[{"id":"Failed2","type":"gauge","value":1}]`)),
+	} {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	// незаписанные метрики лимит не расходуют
+	assert.Equal(t, 0, srv.series.Stats().Series)
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"compress/flate"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/cardinality"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/keyring"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/middlefunc"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/tokens"
+	"github.com/rs/zerolog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Server сервер метрик: настройки, хранилище, логгер и роутер.
+// Несколько экземпляров можно запускать в одном процессе
+type Server struct {
+	cfg    *flags.Config
+	repo   storage.Storer
+	logger zerolog.Logger
+	router chi.Router
+	tokens tokens.Store
+	keys   *keyring.Ring
+	series *cardinality.Limiter
+}
+
+// Option необязательная зависимость сервера
+type Option func(*Server)
+
+// WithTokens включает проверку bearer токенов на /update, /updates, /value и admin API /admin/tokens
+func WithTokens(store tokens.Store) Option {
+	return func(s *Server) {
+		s.tokens = store
+	}
+}
+
+func New(cfg *flags.Config, repo storage.Storer, logger zerolog.Logger, opts ...Option) *Server {
+	s := &Server{
+		cfg:    cfg,
+		repo:   repo,
+		logger: logger,
+		keys:   cfg.Keys,
+		series: cardinality.New(cfg.MaxSeries, cfg.MaxSeriesPerSource),
+	}
+	if s.keys == nil {
+		s.keys = keyring.New(keyring.Key{Secret: cfg.HashKey})
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.router = s.newRouter()
+	s.seedSeries()
+
+	return s
+}
+
+// seedSeries учитывает в ограничении кардинальности метрики, уже лежащие в хранилище
+func (s *Server) seedSeries() {
+	all, err := s.repo.GetAllMetrics()
+	if err != nil {
+		s.logger.Info().Err(err).Msg("seedSeries GetAllMetrics")
+		return
+	}
+
+	series := make([]string, 0, len(all.Gauges)+len(all.Counters))
+	for k := range all.Gauges {
+		series = append(series, cardinality.Series("gauge", k))
+	}
+	for k := range all.Counters {
+		series = append(series, cardinality.Series("counter", k))
+	}
+	s.series.Seed(series...)
+}
+
+func (s *Server) newRouter() chi.Router {
+	mux := chi.NewRouter()
+	mux.Use(middleware.RequestID)
+	mux.Use(middleware.Logger)
+	mux.Use(middleware.Recoverer)
+	// размер тела ограничивается и как есть, и после распаковки
+	mux.Use(middlefunc.LimitBody(s.cfg.MaxBodySize))
+	mux.Use(middlefunc.Decrypt(s.cfg.PrivateKey, s.cfg.CryptoRequired))
+	mux.Use(middlefunc.GzipDecompression)
+	mux.Use(middlefunc.LimitBody(s.cfg.MaxBodySize))
+	mux.Use(middleware.Compress(flate.DefaultCompression, "application/json", "text/html"))
+	mux.Use(middlefunc.SignResponseKeys(s.keys))
+
+	// ping DB
+	mux.Get("/ping", s.PingHandler)
+
+	checkSign := middlefunc.CheckReqBodySign(middlefunc.SignConfig{
+		Keys:           s.keys,
+		Strict:         s.cfg.StrictSign,
+		MaxSkew:        time.Second * time.Duration(s.cfg.SignMaxSkew),
+		NonceCacheSize: s.cfg.SignNonceCache,
+	})
+
+	// корзины общие для всех маршрутов метрик
+	rateConfig := middlefunc.RateConfig{
+		Rate:    s.cfg.RateLimit,
+		Burst:   s.cfg.RateBurst,
+		Proxies: s.cfg.ProxyNets,
+	}
+	rateLimit := middlefunc.RateLimit(rateConfig)
+
+	auth := middlefunc.AuthConfig{
+		Store:      s.tokens,
+		AdminToken: s.cfg.AdminToken,
+		// неудачные попытки ограничиваются по адресу до обращения к хранилищу токенов
+		Failures: middlefunc.NewAuthLimiter(rateConfig),
+	}
+	// запись метрик только из доверенных сетей
+	trusted := middlefunc.TrustedSubnet(middlefunc.SubnetConfig{
+		Allowed: s.cfg.TrustedNets,
+		Proxies: s.cfg.ProxyNets,
+	})
+
+	// return all metrics on WEB page, токен с префиксом видит только свои метрики
+	mux.With(middlefunc.RequireToken(auth, tokens.ScopeRead), rateLimit).Get("/", s.RootHandler)
+
+	// get metrics in array
+	mux.Route("/updates", func(r chi.Router) {
+		r.Use(trusted)
+		r.Use(middlefunc.RequireToken(auth, tokens.ScopeWrite))
+		r.Use(rateLimit)
+		r.Use(checkSign)
+		r.Post("/", s.UpdatesHandler)
+	})
+
+	mux.Route("/update", func(r chi.Router) {
+		r.Use(trusted)
+		r.Use(middlefunc.RequireToken(auth, tokens.ScopeWrite))
+		r.Use(rateLimit)
+		r.Use(checkSign)
+		r.Post("/", s.UpdateHandler)
+		// без тела - подписывается путь запроса
+		r.Post("/{metricType}/{metricName}/{metricVal}", s.UpdateHandlerLong)
+	})
+
+	mux.Route("/value", func(r chi.Router) {
+		r.Use(middlefunc.RequireToken(auth, tokens.ScopeRead))
+		r.Use(rateLimit)
+		r.Get("/{metricType}/{metricName}", s.ValueHandlerLong)
+		r.With(checkSign).Post("/", s.ValueHandler)
+	})
+
+	// без хранилища токенов admin API не подключается: его некому защищать
+	if auth.Enabled() {
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(middlefunc.RequireToken(auth, tokens.ScopeAdmin))
+			r.Route("/tokens", func(r chi.Router) {
+				r.Get("/", s.ListTokensHandler)
+				r.Post("/", s.CreateTokenHandler)
+				r.Delete("/{tokenID}", s.RevokeTokenHandler)
+			})
+			r.Get("/cardinality", s.CardinalityHandler)
+		})
+	} else {
+		// ограничения кардинальности действуют и без токенов, поэтому их состояние доступно
+		// на тех же условиях, что и запись метрик: из доверенных сетей (-t), без -t - всем
+		mux.With(trusted, rateLimit).Get("/admin/cardinality", s.CardinalityHandler)
+	}
+
+	return mux
+}
+
+// Handler роутер сервера, например для httptest.NewServer
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// RestoreMetrics загружает метрики из хранилища, если включено -r
+func (s *Server) RestoreMetrics() error {
+	if s.cfg.Restore {
+		err := s.repo.RestoreMetrics()
+		if err != nil {
+			return fmt.Errorf("repo.RestoreMetrics: %w", err)
+		}
+		s.seedSeries()
+	}
+
+	return nil
+}
+
+// storeTimer сохраняет метрики раз в StoreInterval секунд до отмены ctx
+func (s *Server) storeTimer(ctx context.Context) {
+	if s.cfg.StoreInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second * time.Duration(s.cfg.StoreInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.repo.StoreMetrics()
+			if err != nil {
+				s.logger.Info().Err(err).Msg("storeTimer StoreMetrics")
+			}
+		}
+	}
+}
+
+// Serve обслуживает запросы на уже открытом listener (например 127.0.0.1:0) до отмены ctx,
+// при заданном TLSConfig - по TLS.
+// Остановка по порядку: закрываем listener и ждем текущие запросы не дольше ShutdownTimeout,
+// останавливаем таймер сохранения и сохраняем метрики последний раз.
+// Хранилище не закрывается: его закрывает тот, кто создал и передал в New
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	var (
+		errs []error
+		wg   sync.WaitGroup
+	)
+
+	httpServer := &http.Server{Handler: s.router}
+
+	timerCtx, stopTimer := context.WithCancel(context.Background())
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.storeTimer(timerCtx)
+	}()
+	go func() {
+		defer wg.Done()
+		s.keys.Watch(timerCtx, time.Second*time.Duration(s.cfg.KeysReload), s.logger)
+	}()
+
+	if s.cfg.TLSConfig != nil {
+		l = tls.NewListener(l, s.cfg.TLSConfig)
+	}
+
+	chServeErr := make(chan error, 1)
+	go func() {
+		chServeErr <- httpServer.Serve(l)
+	}()
+	s.logger.Info().
+		Str("Running on", l.Addr().String()).
+		Bool("TLS", s.cfg.TLSConfig != nil).
+		Bool("mTLS", s.cfg.TLSConfig != nil && s.cfg.TLSConfig.ClientCAs != nil).
+		Msg("Server started")
+
+	select {
+	case <-ctx.Done():
+		s.logger.Info().Msg("Server shutting down")
+	case err := <-chServeErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, fmt.Errorf("serve: %w", err))
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(s.cfg.ShutdownTimeout))
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		// не дождались запросов - обрываем соединения
+		errs = append(errs, fmt.Errorf("shutdown: %w", err))
+		httpServer.Close()
+	}
+
+	stopTimer()
+	wg.Wait()
+
+	if err := s.repo.StoreMetrics(); err != nil {
+		errs = append(errs, fmt.Errorf("final StoreMetrics: %w", err))
+	}
+
+	s.logger.Info().Msg("Server stopped")
+
+	return errors.Join(errs...)
+}
+
+// Run слушает RunAddr из настроек, см. Serve
+func (s *Server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.cfg.RunAddr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, l)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeRandomPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// два сервера с разными хранилищами в одном процессе
+	srv1 := newTestServer()
+	srv2 := newTestServer()
+
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go srv1.Serve(ctx, l1)
+	go srv2.Serve(ctx, l2)
+
+	res, err := http.Post(fmt.Sprintf("http://%s/update/counter/PollCount/5", l1.Addr()), "text/plain", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(fmt.Sprintf("http://%s/value/counter/PollCount", l1.Addr()))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "5", string(body))
+
+	res, err = http.Get(fmt.Sprintf("http://%s/value/counter/PollCount", l2.Addr()))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics-db.json")
+	cfg := flags.NewConfig()
+	repo := &closeCountingStore{MemStore: storage.NewMemStore(filePath)}
+	srv := New(cfg, repo, zerolog.Nop())
+
+	// медленный обработчик: запрос в обработке во время остановки
+	chStarted := make(chan struct{})
+	srv.router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(chStarted)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chServeErr := make(chan error, 1)
+	go func() {
+		chServeErr <- srv.Serve(ctx, l)
+	}()
+
+	res, err := http.Post(fmt.Sprintf("http://%s/update/gauge/Alloc/1.5", l.Addr()), "text/plain", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	chSlowStatus := make(chan int, 1)
+	go func() {
+		res, err := http.Get(fmt.Sprintf("http://%s/slow", l.Addr()))
+		if err != nil {
+			chSlowStatus <- -1
+			return
+		}
+		res.Body.Close()
+		chSlowStatus <- res.StatusCode
+	}()
+
+	<-chStarted
+	cancel()
+
+	require.NoError(t, <-chServeErr)
+	assert.Equal(t, http.StatusOK, <-chSlowStatus)
+
+	// после остановки новые соединения не принимаются
+	_, err = http.Get(fmt.Sprintf("http://%s/value/gauge/Alloc", l.Addr()))
+	assert.Error(t, err)
+
+	// финальное сохранение в файл
+	restored := storage.NewMemStore(filePath)
+	require.NoError(t, restored.RestoreMetrics())
+	val, ok, _ := restored.GetGauge("Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, storage.Gauge(1.5), val)
+
+	// хранилище закрывает владелец, а не Serve
+	assert.Equal(t, 0, repo.closed)
+}
+
+// closeCountingStore считает вызовы Close
+type closeCountingStore struct {
+	*storage.MemStore
+	closed int
+}
+
+func (s *closeCountingStore) Close() error {
+	s.closed++
+	return s.MemStore.Close()
+}
+
+func TestTrustedSubnetRoutes(t *testing.T) {
+	cfg := flags.NewConfig()
+	cfg.TrustedNets = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	srv := New(cfg, storage.NewMemStore(""), zerolog.Nop())
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// запись из 127.0.0.1 запрещена, X-Real-IP без доверенного прокси не помогает
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/counter/PollCount/1", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Real-IP", "10.1.2.3")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res, err = http.Post(ts.URL+"/updates/", "application/json", strings.NewReader(`[]`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// чтение не ограничено
+	res, err = http.Get(ts.URL + "/value/counter/PollCount")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// через доверенный прокси на 127.0.0.1
+	cfg.ProxyNets = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	ts2 := httptest.NewServer(New(cfg, storage.NewMemStore(""), zerolog.Nop()).Handler())
+	defer ts2.Close()
+
+	req, err = http.NewRequest(http.MethodPost, ts2.URL+"/update/counter/PollCount/1", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Real-IP", "10.1.2.3")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestLimits(t *testing.T) {
+	cfg := flags.NewConfig()
+	cfg.MaxBatch = 2
+	cfg.MaxBodySize = 512
+	cfg.RateLimit = 0.01
+	cfg.RateBurst = 3
+	ts := httptest.NewServer(New(cfg, storage.NewMemStore(""), zerolog.Nop()).Handler())
+	defer ts.Close()
+
+	post := func(url, body string) *http.Response {
+		res, err := http.Post(ts.URL+url, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	metric := `{"id":"PollCount","type":"counter","delta":1}`
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge,
+		post("/updates/", "["+strings.Join([]string{metric, metric, metric}, ",")+"]").StatusCode)
+	assert.Equal(t, http.StatusRequestEntityTooLarge,
+		post("/update/", `{"id":"`+strings.Repeat("a", 600)+`","type":"counter","delta":1}`).StatusCode)
+	assert.Equal(t, http.StatusOK, post("/updates/", "["+metric+","+metric+"]").StatusCode)
+
+	// в burst 3 засчитаны отклоненный пакет и успешный запрос, слишком большое тело отсекается раньше лимитера
+	assert.Equal(t, http.StatusOK, post("/update/", metric).StatusCode)
+	res := post("/update/", metric)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+}
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/migrations"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/models"
 	"github.com/rs/zerolog/log"
 	"github.com/sethvargo/go-retry"
@@ -20,25 +20,51 @@ type DBstore struct {
 	Store  Store
 }
 
-var DBstorage = &DBstore{
-	Store: Store{
-		Gauges:   make(map[string]Gauge),
-		Counters: make(map[string]Counter),
-	},
+func init() {
+	for _, scheme := range []string{"postgres", "postgresql", "postgres+dsn"} {
+		Register(scheme, func(dsn string) (Storer, error) {
+			conn, _ := PostgresConn(dsn)
+			return NewDBStore(conn)
+		})
+	}
 }
 
-func InitConnDB() error {
-	var err error
-
-	ps := flags.FlagDBConn
+// PostgresConn строка подключения pgx для dsn со схемой postgres://, postgresql://
+// или postgres+dsn://host=... dbname=... (формат key=value). ok == false - схема не postgres.
+// Нужна и другим хранилищам в той же БД, например токенам
+func PostgresConn(dsn string) (string, bool) {
+	scheme, _, _ := strings.Cut(dsn, "://")
+	switch scheme {
+	case "postgres", "postgresql":
+		return dsn, true
+	case "postgres+dsn":
+		return dsnPath(dsn), true
+	default:
+		return "", false
+	}
+}
 
+// NewDBStore открывает соединение с БД и применяет миграции
+func NewDBStore(dsn string) (Storer, error) {
 	// не возвращает ошибку елси нет коннетка к БД
-	DBstorage.DBconn, err = sql.Open("pgx", ps)
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	err = migrations.ApplyMigrations(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migration error: %w", err)
+	}
+
+	return &DBstore{
+		DBconn: db,
+		Store: Store{
+			Gauges:   make(map[string]Gauge),
+			Counters: make(map[string]Counter),
+		},
+	}, nil
 }
 
 func PingDB(db *sql.DB) error {
@@ -162,45 +188,40 @@ func selectAllCounters(db *sql.DB) (map[string]Counter, error) {
 	return result, nil
 }
 
+// StoreMetrics все изменения сразу пишутся в БД.
+// Повторная запись кеша через UpdateCounter удваивала бы счетчики
 func (d *DBstore) StoreMetrics() error {
-	var err error
-
-	for k, v := range d.Store.Gauges {
-		err = d.SetGauge(k, v)
-		if err != nil {
-			log.Info().Err(err).Msg("StoreMetricsToDB error")
-			return err
-		}
-	}
+	return nil
+}
 
-	for k, v := range d.Store.Counters {
-		err = d.UpdateCounter(k, v)
-		if err != nil {
-			log.Info().Err(err).Msg("StoreMetricsToDB error")
-			return err
-		}
-	}
+func (d *DBstore) Ping() error {
+	return PingDB(d.DBconn)
+}
 
-	return nil
+func (d *DBstore) Close() error {
+	return d.DBconn.Close()
 }
 
 func (d *DBstore) GetAllMetrics() (Store, error) {
-	var err error
+	var (
+		result Store
+		err    error
+	)
 
-	d.Store.Gauges, err = selectAllGauges(d.DBconn)
+	result.Gauges, err = selectAllGauges(d.DBconn)
 	if err != nil {
 		log.Info().Err(err).Msg("DB selectAllGauges error")
 		return Store{}, err
 	}
 
-	d.Store.Counters, err = selectAllCounters(d.DBconn)
+	result.Counters, err = selectAllCounters(d.DBconn)
 	if err != nil {
 		log.Info().Err(err).Msg("DB selectAllCounters error")
 		return Store{}, err
 
 	}
 
-	return d.Store, nil
+	return result, nil
 }
 
 func (d *DBstore) GetGauge(name string) (Gauge, bool, error) {
@@ -220,7 +241,7 @@ func (d *DBstore) GetGauge(name string) (Gauge, bool, error) {
 			} else if errors.As(err, &pgErr) {
 				return retry.RetryableError(err)
 			} else {
-				log.Info().Err(err).Msg("DB selectAllCounters error")
+				log.Info().Err(err).Msg("DB GetGauge QueryRow error")
 				return err
 			}
 		}
@@ -228,6 +249,9 @@ func (d *DBstore) GetGauge(name string) (Gauge, bool, error) {
 		return nil
 	})
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return Gauge(result), false, nil
+	}
 	if err != nil {
 		return Gauge(result), false, err
 	}
@@ -406,29 +430,19 @@ func (d *DBstore) GetCounters() (map[string]Counter, error) {
 }
 
 // UpdateCounter do with retry
+// прибавление выполняется в самой БД, параллельные обновления не теряются
 func (d *DBstore) UpdateCounter(name string, value Counter) error {
 	b := retry.NewFibonacci(1 * time.Second)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	curVal, ok, err := d.GetCounter(name)
-	if err != nil {
-		log.Info().Err(err).Msg("DB GetCounter UpdateCounter GetCounters error")
-		return err
-	}
-	if !ok {
-		curVal = 0
-	}
-	curVal = curVal + value
-
 	insertUpdate := `INSERT INTO counter (mname, val) VALUES ($1, $2)
 						ON CONFLICT (mname)
-						DO UPDATE SET val = $3
-						WHERE counter.mname = $4`
+						DO UPDATE SET val = counter.val + excluded.val`
 
-	err = retry.Do(ctx, retry.WithMaxRetries(3, b), func(ctx context.Context) error {
-		_, err = d.DBconn.Exec(insertUpdate, name, curVal, curVal, name)
+	err := retry.Do(ctx, retry.WithMaxRetries(3, b), func(ctx context.Context) error {
+		_, err := d.DBconn.ExecContext(ctx, insertUpdate, name, value)
 		if err != nil {
 			var pgErr *pgconn.PgError
 			if errors.As(err, &pgErr) {
@@ -469,15 +483,14 @@ func (d *DBstore) RestoreMetrics() error {
 	return nil
 }
 
+// UpdateMetricBatch весь батч пишется одной транзакцией
 func (d *DBstore) UpdateMetricBatch(reqJSON []models.Metrics) error {
 	var (
 		err            error
 		gaugeArgs      []interface{}
 		gaugeStrings   []string
-		gaugeQuery     string
 		counterArgs    []interface{}
 		counterStrings []string
-		counterQuery   string
 		indCounter     int
 	)
 	tmpStoreGauge := make(map[string]Gauge)
@@ -487,35 +500,22 @@ func (d *DBstore) UpdateMetricBatch(reqJSON []models.Metrics) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	if err = checkMetricBatch(reqJSON); err != nil {
+		return err
+	}
+
 	insertUpdateGauge1 := `INSERT INTO gauge (mname, val) VALUES `
 	insertUpdateGauge2 := ` ON CONFLICT (mname) DO UPDATE SET val = excluded.val;`
 
 	insertUpdateCounter1 := `INSERT INTO counter (mname, val) VALUES `
-	insertUpdateCounter2 := ` ON CONFLICT (mname) DO UPDATE SET val = excluded.val;`
+	insertUpdateCounter2 := ` ON CONFLICT (mname) DO UPDATE SET val = counter.val + excluded.val;`
 
-	indCounter = 0
+	// в одном INSERT ключ может встретиться один раз - складываем повторы заранее
 	for _, v := range reqJSON {
 		if v.MType == "gauge" {
 			tmpStoreGauge[v.ID] = Gauge(*v.Value)
-		} else if v.MType == "counter" {
-			// был ли такой ключ в пришедшем батче ранее
-			_, ok := tmpStoreCounter[v.ID]
-			if ok {
-				tmpStoreCounter[v.ID] = tmpStoreCounter[v.ID] + Counter(*v.Delta)
-			} else {
-				counterDBVal, ok, err := d.GetCounter(v.ID)
-				if err != nil {
-					return err
-				}
-
-				if ok {
-					tmpStoreCounter[v.ID] = counterDBVal + Counter(*v.Delta)
-				} else {
-					tmpStoreCounter[v.ID] = Counter(*v.Delta)
-				}
-			}
 		} else {
-			return fmt.Errorf("can not get val for %v from reqJSON", v.ID)
+			tmpStoreCounter[v.ID] += Counter(*v.Delta)
 		}
 	}
 
@@ -535,30 +535,38 @@ func (d *DBstore) UpdateMetricBatch(reqJSON []models.Metrics) error {
 		counterStrings = append(counterStrings, fmt.Sprintf("($%d, $%d)", indCounter*2-1, indCounter*2))
 	}
 
-	gaugeQuery = insertUpdateGauge1 + strings.Join(gaugeStrings, ",") + insertUpdateGauge2
-	counterQuery = insertUpdateCounter1 + strings.Join(counterStrings, ",") + insertUpdateCounter2
-
 	err = retry.Do(ctx, retry.WithMaxRetries(3, b), func(ctx context.Context) error {
-
-		if _, err := d.DBconn.Exec(gaugeQuery, gaugeArgs...); err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				return retry.RetryableError(err)
-			} else {
-				return fmt.Errorf("insertUpdateGauge: %w", err)
+		tx, err := d.DBconn.BeginTx(ctx, nil)
+		if err != nil {
+			return retry.RetryableError(err)
+		}
+		defer tx.Rollback()
+
+		if len(gaugeStrings) > 0 {
+			gaugeQuery := insertUpdateGauge1 + strings.Join(gaugeStrings, ",") + insertUpdateGauge2
+			if _, err := tx.ExecContext(ctx, gaugeQuery, gaugeArgs...); err != nil {
+				var pgErr *pgconn.PgError
+				if errors.As(err, &pgErr) {
+					return retry.RetryableError(err)
+				} else {
+					return fmt.Errorf("insertUpdateGauge: %w", err)
+				}
 			}
 		}
 
-		if _, err := d.DBconn.Exec(counterQuery, counterArgs...); err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				return retry.RetryableError(err)
-			} else {
-				return fmt.Errorf("insertUpdateCounter: %w", err)
+		if len(counterStrings) > 0 {
+			counterQuery := insertUpdateCounter1 + strings.Join(counterStrings, ",") + insertUpdateCounter2
+			if _, err := tx.ExecContext(ctx, counterQuery, counterArgs...); err != nil {
+				var pgErr *pgconn.PgError
+				if errors.As(err, &pgErr) {
+					return retry.RetryableError(err)
+				} else {
+					return fmt.Errorf("insertUpdateCounter: %w", err)
+				}
 			}
 		}
 
-		return nil
+		return tx.Commit()
 	})
 
 	if err != nil {
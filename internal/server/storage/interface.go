@@ -13,4 +13,10 @@ type Storer interface {
 	UpdateMetricBatch([]models.Metrics) error
 	StoreMetrics() error
 	RestoreMetrics() error
+	Close() error
+}
+
+// Pinger реализуют хранилища, у которых можно проверить соединение
+type Pinger interface {
+	Ping() error
 }
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory создает хранилище по строке подключения вида scheme://...
+type Factory func(dsn string) (Storer, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register регистрирует фабрику хранилища для схемы URL.
+// Вызывается из init() пакета с реализацией, повторная регистрация - паника
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes возвращает отсортированный список зарегистрированных схем
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	result := make([]string, 0, len(factories))
+	for k := range factories {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// New создает хранилище по схеме из dsn
+func New(dsn string) (Storer, error) {
+	scheme, _, ok := strings.Cut(dsn, "://")
+	if !ok || scheme == "" {
+		return nil, fmt.Errorf("storage: no scheme in %q", dsn)
+	}
+
+	factoriesMu.RLock()
+	factory, ok := factories[scheme]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("storage: unknown scheme %q (registered: %s)", scheme, strings.Join(Schemes(), ", "))
+	}
+
+	repo, err := factory(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("storage %s: %w", scheme, err)
+	}
+
+	return repo, nil
+}
+
+// dsnPath возвращает часть dsn после scheme://
+func dsnPath(dsn string) string {
+	_, path, _ := strings.Cut(dsn, "://")
+	return path
+}
@@ -1,26 +1,58 @@
 package storage
 
 import (
-	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"errors"
+	"fmt"
 	"github.com/pochtalexa/ya-practicum-metrics/internal/server/models"
 	"github.com/rs/zerolog/log"
+	"sync"
 )
 
 type Gauge float64
 type Counter int64
 
+// Store снимок всех метрик, в таком виде они пишутся в файл
 type Store struct {
 	Gauges   map[string]Gauge
 	Counters map[string]Counter
 }
 
-var MemStorage = &Store{
-	Gauges:   make(map[string]Gauge),
-	Counters: make(map[string]Counter),
+// MemStore хранилище метрик в памяти с сохранением в файл
+type MemStore struct {
+	mu       sync.RWMutex
+	data     Store
+	filePath string // файл для StoreMetrics/RestoreMetrics, пустой - только память
 }
 
-func (m *Store) StoreMetrics() error {
-	StoreFile, err := NewStoreFile(flags.FlagFileStorePath)
+func init() {
+	Register("mem", func(dsn string) (Storer, error) {
+		return NewMemStore(""), nil
+	})
+	Register("file", func(dsn string) (Storer, error) {
+		path := dsnPath(dsn)
+		if path == "" {
+			return nil, errors.New("empty file path")
+		}
+		return NewMemStore(path), nil
+	})
+}
+
+func NewMemStore(filePath string) *MemStore {
+	return &MemStore{
+		data: Store{
+			Gauges:   make(map[string]Gauge),
+			Counters: make(map[string]Counter),
+		},
+		filePath: filePath,
+	}
+}
+
+func (m *MemStore) StoreMetrics() error {
+	if m.filePath == "" {
+		return nil
+	}
+
+	StoreFile, err := NewStoreFile(m.filePath)
 	if err != nil {
 		log.Info().Err(err).Msg("StoreMetricsToFile error")
 		return err
@@ -35,55 +67,143 @@ func (m *Store) StoreMetrics() error {
 	return nil
 }
 
-func (m *Store) GetAllMetrics() (Store, error) {
-	return *m, nil
+// GetAllMetrics возвращает копию, чтобы вызывающий не держал ссылки на внутренние map
+func (m *MemStore) GetAllMetrics() (Store, error) {
+	gauges, _ := m.GetGauges()
+	counters, _ := m.GetCounters()
+
+	return Store{
+		Gauges:   gauges,
+		Counters: counters,
+	}, nil
 }
 
-func (m *Store) GetGauge(name string) (Gauge, bool, error) {
-	val, exists := m.Gauges[name]
+func (m *MemStore) GetGauge(name string) (Gauge, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	val, exists := m.data.Gauges[name]
 	return val, exists, nil
 }
 
-func (m *Store) GetGauges() (map[string]Gauge, error) {
-	return m.Gauges, nil
+func (m *MemStore) GetGauges() (map[string]Gauge, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]Gauge, len(m.data.Gauges))
+	for k, v := range m.data.Gauges {
+		result[k] = v
+	}
+
+	return result, nil
 }
 
-func (m *Store) SetGauge(name string, value Gauge) error {
-	m.Gauges[name] = value
+func (m *MemStore) SetGauge(name string, value Gauge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data.Gauges[name] = value
 	return nil
 }
 
-func (m *Store) GetCounter(name string) (Counter, bool, error) {
-	val, exists := m.Counters[name]
+func (m *MemStore) GetCounter(name string) (Counter, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	val, exists := m.data.Counters[name]
 	return val, exists, nil
 }
 
-func (m *Store) GetCounters() (map[string]Counter, error) {
-	return m.Counters, nil
+func (m *MemStore) GetCounters() (map[string]Counter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]Counter, len(m.data.Counters))
+	for k, v := range m.data.Counters {
+		result[k] = v
+	}
+
+	return result, nil
 }
 
-func (m *Store) UpdateCounter(name string, value Counter) error {
-	m.Counters[name] += value
+func (m *MemStore) UpdateCounter(name string, value Counter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data.Counters[name] += value
 	return nil
 }
 
-func (m *Store) RestoreMetrics() error {
-	RestoreFile, err := NewRestoreFile(flags.FlagFileStorePath)
+func (m *MemStore) RestoreMetrics() error {
+	if m.filePath == "" {
+		return nil
+	}
+
+	RestoreFile, err := NewRestoreFile(m.filePath)
 	if err != nil {
 		log.Info().Err(err).Msg("can not read metrics from file")
 		return err
 	}
 	defer RestoreFile.Close()
 
-	if err := RestoreFile.ReadMetrics(m); err != nil {
+	restored := Store{}
+	if err := RestoreFile.ReadMetrics(&restored); err != nil {
 		log.Info().Err(err).Msg("can not read metrics from file")
 		return err
 	}
+	if restored.Gauges == nil {
+		restored.Gauges = make(map[string]Gauge)
+	}
+	if restored.Counters == nil {
+		restored.Counters = make(map[string]Counter)
+	}
+
+	m.mu.Lock()
+	m.data = restored
+	m.mu.Unlock()
 
 	log.Info().Msg("metrics restored from file")
 	return nil
 }
 
-func (m *Store) UpdateMetricBatch([]models.Metrics) error {
+// checkMetricBatch проверяет батч целиком до записи, чтобы не применять его частично
+func checkMetricBatch(reqJSON []models.Metrics) error {
+	for _, v := range reqJSON {
+		if v.MType == "gauge" {
+			if v.Value == nil {
+				return fmt.Errorf("bad gauge value for %v", v.ID)
+			}
+		} else if v.MType == "counter" {
+			if v.Delta == nil {
+				return fmt.Errorf("bad counter delta for %v", v.ID)
+			}
+		} else {
+			return fmt.Errorf("can not get val for %v from reqJSON", v.ID)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemStore) UpdateMetricBatch(reqJSON []models.Metrics) error {
+	if err := checkMetricBatch(reqJSON); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range reqJSON {
+		if v.MType == "gauge" {
+			m.data.Gauges[v.ID] = Gauge(*v.Value)
+		} else {
+			m.data.Counters[v.ID] += Counter(*v.Delta)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemStore) Close() error {
 	return nil
 }
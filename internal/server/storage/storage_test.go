@@ -0,0 +1,65 @@
+package storage_test
+
+import (
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	storagetest.Run(t, storagetest.Options{
+		NewDSN: func(t *testing.T) string {
+			return "mem://"
+		},
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	storagetest.Run(t, storagetest.Options{
+		NewDSN: func(t *testing.T) string {
+			return "file://" + filepath.Join(t.TempDir(), "metrics-db.json")
+		},
+		Persistent: true,
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	storagetest.Run(t, storagetest.Options{
+		NewDSN: func(t *testing.T) string {
+			return "bolt://" + filepath.Join(t.TempDir(), "metrics.db")
+		},
+		Persistent: true,
+	})
+}
+
+func TestDBStore(t *testing.T) {
+	dsn := storagetest.PostgresDSN(t)
+
+	storagetest.Run(t, storagetest.Options{
+		NewDSN: func(t *testing.T) string {
+			storagetest.ResetPostgres(t, dsn)
+			return dsn
+		},
+		Persistent: true,
+	})
+}
+
+func TestPostgresConn(t *testing.T) {
+	tests := []struct {
+		dsn, want string
+		ok        bool
+	}{
+		{"postgres://user@localhost/metrics", "postgres://user@localhost/metrics", true},
+		{"postgresql://user@localhost/metrics", "postgresql://user@localhost/metrics", true},
+		{"postgres+dsn://host=localhost dbname=metrics", "host=localhost dbname=metrics", true},
+		{"mem://", "", false},
+		{"host=localhost", "", false},
+	}
+	for _, tt := range tests {
+		conn, ok := storage.PostgresConn(tt.dsn)
+		assert.Equal(t, tt.ok, ok, tt.dsn)
+		assert.Equal(t, tt.want, conn, tt.dsn)
+	}
+}
@@ -0,0 +1,83 @@
+package storagetest
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/migrations"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// PostgresDSN возвращает dsn тестовой БД: из TEST_DATABASE_DSN или временного кластера,
+// поднятого через initdb/pg_ctl из PATH. Если ни того ни другого нет - тест пропускается.
+// Кластер останавливается по завершении t
+func PostgresDSN(t *testing.T) string {
+	t.Helper()
+
+	if dsn := os.Getenv("TEST_DATABASE_DSN"); dsn != "" {
+		return dsn
+	}
+
+	initdb, errInitdb := exec.LookPath("initdb")
+	pgCtl, errPgCtl := exec.LookPath("pg_ctl")
+	if errInitdb != nil || errPgCtl != nil {
+		t.Skip("no TEST_DATABASE_DSN and no initdb/pg_ctl in PATH")
+	}
+
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+
+	out, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "--auth=trust").CombinedOutput()
+	if err != nil {
+		t.Fatalf("initdb: %v\n%s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("free port: %v", err)
+	}
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, dir)
+	out, err = exec.Command(pgCtl, "-D", dataDir, "-o", opts, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		t.Fatalf("pg_ctl start: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		exec.Command(pgCtl, "-D", dataDir, "-m", "fast", "-w", "stop").Run()
+	})
+
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+}
+
+// ResetPostgres применяет миграции и очищает таблицы метрик
+func ResetPostgres(t *testing.T, dsn string) {
+	t.Helper()
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+
+	if err = migrations.ApplyMigrations(db); err != nil {
+		t.Fatalf("ApplyMigrations: %v", err)
+	}
+
+	if _, err = db.Exec("TRUNCATE gauge, counter"); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,274 @@
+// Package storagetest набор тестов на соответствие для реализаций storage.Storer.
+// Реализация подключается через реестр storage: тест получает dsn пустого хранилища
+// и открывает его через storage.New, в том числе повторно для проверки восстановления
+package storagetest
+
+import (
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/models"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// Options описывает проверяемую реализацию
+type Options struct {
+	// NewDSN возвращает dsn нового пустого хранилища
+	NewDSN func(t *testing.T) string
+	// Persistent данные переживают Close и повторное открытие по тому же dsn
+	Persistent bool
+}
+
+// Run запускает все проверки для реализации
+func Run(t *testing.T, opts Options) {
+	t.Run("GetSetGauge", func(t *testing.T) { testGetSetGauge(t, opts) })
+	t.Run("CounterAccumulation", func(t *testing.T) { testCounterAccumulation(t, opts) })
+	t.Run("GetAll", func(t *testing.T) { testGetAll(t, opts) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, opts) })
+	t.Run("BatchErrors", func(t *testing.T) { testBatchErrors(t, opts) })
+	t.Run("RestoreAfterStore", func(t *testing.T) { testRestoreAfterStore(t, opts) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, opts) })
+}
+
+func open(t *testing.T, dsn string) storage.Storer {
+	t.Helper()
+
+	repo, err := storage.New(dsn)
+	require.NoError(t, err)
+
+	return repo
+}
+
+func openNew(t *testing.T, opts Options) storage.Storer {
+	t.Helper()
+
+	repo := open(t, opts.NewDSN(t))
+	t.Cleanup(func() {
+		repo.Close()
+	})
+
+	return repo
+}
+
+func gauge(id string, val float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &val}
+}
+
+func counter(id string, delta int64) models.Metrics {
+	return models.Metrics{ID: id, MType: "counter", Delta: &delta}
+}
+
+func testGetSetGauge(t *testing.T, opts Options) {
+	repo := openNew(t, opts)
+
+	_, ok, err := repo.GetGauge("Alloc")
+	require.NoError(t, err)
+	assert.False(t, ok, "missing gauge")
+
+	require.NoError(t, repo.SetGauge("Alloc", 1.5))
+	val, ok, err := repo.GetGauge("Alloc")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, storage.Gauge(1.5), val)
+
+	// gauge перезаписывается
+	require.NoError(t, repo.SetGauge("Alloc", -2.25))
+	val, _, err = repo.GetGauge("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(-2.25), val)
+
+	// одно имя у разных типов не пересекается
+	_, ok, err = repo.GetCounter("Alloc")
+	require.NoError(t, err)
+	assert.False(t, ok, "gauge must not be visible as counter")
+}
+
+func testCounterAccumulation(t *testing.T, opts Options) {
+	repo := openNew(t, opts)
+
+	_, ok, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	assert.False(t, ok, "missing counter")
+
+	require.NoError(t, repo.UpdateCounter("PollCount", 5))
+	require.NoError(t, repo.UpdateCounter("PollCount", 7))
+	require.NoError(t, repo.UpdateCounter("PollCount", -2))
+
+	val, ok, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, storage.Counter(10), val)
+}
+
+func testGetAll(t *testing.T, opts Options) {
+	repo := openNew(t, opts)
+
+	all, err := repo.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, all.Gauges)
+	assert.Empty(t, all.Counters)
+
+	require.NoError(t, repo.SetGauge("g1", 1))
+	require.NoError(t, repo.SetGauge("g2", 2))
+	require.NoError(t, repo.UpdateCounter("c1", 3))
+
+	gauges, err := repo.GetGauges()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]storage.Gauge{"g1": 1, "g2": 2}, gauges)
+
+	counters, err := repo.GetCounters()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]storage.Counter{"c1": 3}, counters)
+
+	all, err = repo.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, gauges, all.Gauges)
+	assert.Equal(t, counters, all.Counters)
+
+	// результат - снимок, его изменение не влияет на хранилище
+	all.Gauges["g1"] = 100
+	val, _, err := repo.GetGauge("g1")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1), val)
+}
+
+func testBatch(t *testing.T, opts Options) {
+	repo := openNew(t, opts)
+
+	require.NoError(t, repo.UpdateCounter("c1", 10))
+	require.NoError(t, repo.SetGauge("g1", 1))
+
+	err := repo.UpdateMetricBatch([]models.Metrics{
+		gauge("g1", 2),
+		gauge("g1", 3), // последнее значение gauge в батче побеждает
+		gauge("g2", 4),
+		counter("c1", 1),
+		counter("c1", 2), // повторы counter в батче складываются
+		counter("c2", 5),
+	})
+	require.NoError(t, err)
+
+	all, err := repo.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]storage.Gauge{"g1": 3, "g2": 4}, all.Gauges)
+	assert.Equal(t, map[string]storage.Counter{"c1": 13, "c2": 5}, all.Counters)
+
+	// батчи только из одного типа и пустой батч
+	require.NoError(t, repo.UpdateMetricBatch([]models.Metrics{gauge("g3", 1)}))
+	require.NoError(t, repo.UpdateMetricBatch([]models.Metrics{counter("c3", 1)}))
+	require.NoError(t, repo.UpdateMetricBatch(nil))
+
+	all, err = repo.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Len(t, all.Gauges, 3)
+	assert.Len(t, all.Counters, 3)
+}
+
+func testBatchErrors(t *testing.T, opts Options) {
+	repo := openNew(t, opts)
+
+	tests := []struct {
+		name  string
+		batch []models.Metrics
+	}{
+		{
+			name:  "unknown type",
+			batch: []models.Metrics{gauge("g1", 1), {ID: "x", MType: "histogram"}},
+		},
+		{
+			name:  "gauge without value",
+			batch: []models.Metrics{counter("c1", 1), {ID: "g2", MType: "gauge"}},
+		},
+		{
+			name:  "counter without delta",
+			batch: []models.Metrics{gauge("g1", 1), {ID: "c2", MType: "counter"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Error(t, repo.UpdateMetricBatch(test.batch))
+		})
+	}
+
+	// ошибочный батч не применяется частично
+	all, err := repo.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, all.Gauges)
+	assert.Empty(t, all.Counters)
+}
+
+func testRestoreAfterStore(t *testing.T, opts Options) {
+	if !opts.Persistent {
+		t.Skip("storage is not persistent")
+	}
+
+	dsn := opts.NewDSN(t)
+
+	repo := open(t, dsn)
+	require.NoError(t, repo.SetGauge("Alloc", 1.5))
+	require.NoError(t, repo.UpdateCounter("PollCount", 5))
+	require.NoError(t, repo.UpdateMetricBatch([]models.Metrics{gauge("HeapAlloc", 2), counter("PollCount", 1)}))
+	require.NoError(t, repo.StoreMetrics())
+	require.NoError(t, repo.Close())
+
+	repo = open(t, dsn)
+	defer repo.Close()
+	require.NoError(t, repo.RestoreMetrics())
+
+	all, err := repo.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]storage.Gauge{"Alloc": 1.5, "HeapAlloc": 2}, all.Gauges)
+	assert.Equal(t, map[string]storage.Counter{"PollCount": 6}, all.Counters)
+
+	// после восстановления счетчик продолжает накапливаться
+	require.NoError(t, repo.UpdateCounter("PollCount", 4))
+	val, _, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(10), val)
+}
+
+func testConcurrency(t *testing.T, opts Options) {
+	const (
+		workers = 8
+		updates = 25
+	)
+
+	repo := openNew(t, opts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+
+			for j := 0; j < updates; j++ {
+				assert.NoError(t, repo.UpdateCounter("PollCount", 1))
+				assert.NoError(t, repo.SetGauge(fmt.Sprintf("worker%d", workerID), storage.Gauge(j)))
+				assert.NoError(t, repo.UpdateMetricBatch([]models.Metrics{counter("BatchCount", 2)}))
+				_, _, err := repo.GetCounter("PollCount")
+				assert.NoError(t, err)
+				_, err = repo.GetAllMetrics()
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	val, _, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(workers*updates), val)
+
+	val, _, err = repo.GetCounter("BatchCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(workers*updates*2), val)
+
+	gauges, err := repo.GetGauges()
+	require.NoError(t, err)
+	assert.Len(t, gauges, workers)
+	for _, v := range gauges {
+		assert.Equal(t, storage.Gauge(updates-1), v)
+	}
+}
@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/models"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"time"
+)
+
+var (
+	gaugeBucket   = []byte("gauge")
+	counterBucket = []byte("counter")
+)
+
+// BoltStore хранилище метрик во встроенной key-value БД bbolt.
+// Каждый тип метрики лежит в своем bucket, запись фиксируется на диске
+// при коммите транзакции, поэтому отдельный шаг StoreMetrics/RestoreMetrics не нужен
+type BoltStore struct {
+	DB *bolt.DB
+}
+
+func init() {
+	Register("bolt", func(dsn string) (Storer, error) {
+		path := dsnPath(dsn)
+		if path == "" {
+			return nil, errors.New("empty bolt file path")
+		}
+		return NewBoltStore(path)
+	})
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt.Open: %w", err)
+	}
+
+	// одиночные обновления от параллельных запросов склеиваются в общие транзакции
+	db.MaxBatchDelay = 10 * time.Millisecond
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(gaugeBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(counterBucket); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create buckets: %w", err)
+	}
+
+	return &BoltStore{DB: db}, nil
+}
+
+func encodeGauge(value Gauge) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(float64(value)))
+	return buf
+}
+
+func decodeGauge(buf []byte) (Gauge, error) {
+	if len(buf) != 8 {
+		return 0, errors.New("bad gauge value length")
+	}
+	return Gauge(math.Float64frombits(binary.BigEndian.Uint64(buf))), nil
+}
+
+func encodeCounter(value Counter) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(value))
+	return buf
+}
+
+func decodeCounter(buf []byte) (Counter, error) {
+	if len(buf) != 8 {
+		return 0, errors.New("bad counter value length")
+	}
+	return Counter(binary.BigEndian.Uint64(buf)), nil
+}
+
+func addCounter(b *bolt.Bucket, name string, value Counter) error {
+	curVal := Counter(0)
+
+	if buf := b.Get([]byte(name)); buf != nil {
+		var err error
+		if curVal, err = decodeCounter(buf); err != nil {
+			return err
+		}
+	}
+
+	return b.Put([]byte(name), encodeCounter(curVal+value))
+}
+
+// StoreMetrics данные уже записаны на диск при коммите
+func (b *BoltStore) StoreMetrics() error {
+	return nil
+}
+
+// RestoreMetrics данные читаются из файла БД напрямую
+func (b *BoltStore) RestoreMetrics() error {
+	return nil
+}
+
+func (b *BoltStore) Ping() error {
+	return b.DB.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.DB.Close()
+}
+
+func (b *BoltStore) GetAllMetrics() (Store, error) {
+	var err error
+	result := Store{}
+
+	result.Gauges, err = b.GetGauges()
+	if err != nil {
+		return Store{}, err
+	}
+
+	result.Counters, err = b.GetCounters()
+	if err != nil {
+		return Store{}, err
+	}
+
+	return result, nil
+}
+
+func (b *BoltStore) GetGauge(name string) (Gauge, bool, error) {
+	var (
+		result Gauge
+		ok     bool
+	)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(gaugeBucket).Get([]byte(name))
+		if buf == nil {
+			return nil
+		}
+
+		var err error
+		result, err = decodeGauge(buf)
+		ok = err == nil
+		return err
+	})
+
+	return result, ok, err
+}
+
+func (b *BoltStore) GetGauges() (map[string]Gauge, error) {
+	result := make(map[string]Gauge)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(gaugeBucket).ForEach(func(k, v []byte) error {
+			val, err := decodeGauge(v)
+			if err != nil {
+				return fmt.Errorf("gauge %s: %w", k, err)
+			}
+			result[string(k)] = val
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (b *BoltStore) SetGauge(name string, value Gauge) error {
+	return b.DB.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(gaugeBucket).Put([]byte(name), encodeGauge(value))
+	})
+}
+
+func (b *BoltStore) GetCounter(name string) (Counter, bool, error) {
+	var (
+		result Counter
+		ok     bool
+	)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(counterBucket).Get([]byte(name))
+		if buf == nil {
+			return nil
+		}
+
+		var err error
+		result, err = decodeCounter(buf)
+		ok = err == nil
+		return err
+	})
+
+	return result, ok, err
+}
+
+func (b *BoltStore) GetCounters() (map[string]Counter, error) {
+	result := make(map[string]Counter)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(counterBucket).ForEach(func(k, v []byte) error {
+			val, err := decodeCounter(v)
+			if err != nil {
+				return fmt.Errorf("counter %s: %w", k, err)
+			}
+			result[string(k)] = val
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UpdateCounter через Batch: функция может быть вызвана повторно,
+// поэтому внутри только чтение текущего значения и запись суммы
+func (b *BoltStore) UpdateCounter(name string, value Counter) error {
+	return b.DB.Batch(func(tx *bolt.Tx) error {
+		return addCounter(tx.Bucket(counterBucket), name, value)
+	})
+}
+
+// UpdateMetricBatch весь батч пишется одной транзакцией
+func (b *BoltStore) UpdateMetricBatch(reqJSON []models.Metrics) error {
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		gauges := tx.Bucket(gaugeBucket)
+		counters := tx.Bucket(counterBucket)
+
+		for _, v := range reqJSON {
+			if v.MType == "gauge" {
+				if v.Value == nil {
+					return fmt.Errorf("bad gauge value for %v", v.ID)
+				}
+				if err := gauges.Put([]byte(v.ID), encodeGauge(Gauge(*v.Value))); err != nil {
+					return err
+				}
+			} else if v.MType == "counter" {
+				if v.Delta == nil {
+					return fmt.Errorf("bad counter delta for %v", v.ID)
+				}
+				if err := addCounter(counters, v.ID, Counter(*v.Delta)); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("can not get val for %v from reqJSON", v.ID)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Info().Err(err).Msg("bolt UpdateMetricBatch error")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/migrations"
+	"time"
+)
+
+const dbTimeout = 5 * time.Second
+
+// DBStore токены в таблице tokens
+type DBStore struct {
+	DBconn *sql.DB
+}
+
+// NewDBStore открывает соединение с БД и применяет миграции
+func NewDBStore(dsn string) (*DBStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = migrations.ApplyMigrations(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migration error: %w", err)
+	}
+
+	return &DBStore{DBconn: db}, nil
+}
+
+func (d *DBStore) Create(t Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := d.DBconn.ExecContext(ctx,
+		`INSERT INTO tokens (id, name, hash, scope, prefix, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		t.ID, t.Name, t.Hash, string(t.Scope), t.Prefix, t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert token: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DBStore) List() ([]Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := d.DBconn.QueryContext(ctx,
+		`SELECT id, name, hash, scope, prefix, created_at FROM tokens ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("select tokens: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]Token, 0)
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+
+	return result, rows.Err()
+}
+
+func (d *DBStore) Revoke(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := d.DBconn.ExecContext(ctx, `DELETE FROM tokens WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete token: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (d *DBStore) Lookup(hash string) (Token, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := d.DBconn.QueryRowContext(ctx,
+		`SELECT id, name, hash, scope, prefix, created_at FROM tokens WHERE hash = $1`, hash)
+
+	t, err := scanToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Token{}, false, nil
+	}
+	if err != nil {
+		return Token{}, false, err
+	}
+
+	return t, true, nil
+}
+
+func (d *DBStore) Close() error {
+	return d.DBconn.Close()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row scanner) (Token, error) {
+	var (
+		t     Token
+		scope string
+	)
+
+	if err := row.Scan(&t.ID, &t.Name, &t.Hash, &scope, &t.Prefix, &t.CreatedAt); err != nil {
+		return Token{}, err
+	}
+	t.Scope = Scope(scope)
+	t.CreatedAt = t.CreatedAt.UTC()
+
+	return t, nil
+}
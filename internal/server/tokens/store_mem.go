@@ -0,0 +1,167 @@
+package tokens
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemStore токены в памяти. С filePath каждое изменение сохраняется в JSON файл
+type MemStore struct {
+	mu       sync.RWMutex
+	byID     map[string]Token
+	byHash   map[string]string // hash -> id
+	filePath string
+}
+
+func NewMemStore(filePath string) *MemStore {
+	return &MemStore{
+		byID:     make(map[string]Token),
+		byHash:   make(map[string]string),
+		filePath: filePath,
+	}
+}
+
+// fileToken формат файла: в отличие от API хеш сохраняется
+type fileToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+// OpenFileStore загружает токены из файла, если он есть
+func OpenFileStore(filePath string) (*MemStore, error) {
+	if filePath == "" {
+		return nil, errors.New("tokens: empty file path")
+	}
+
+	m := NewMemStore(filePath)
+
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []fileToken
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("tokens: parse %s: %w", filePath, err)
+	}
+	for _, v := range list {
+		v.Token.Hash = v.Hash
+		m.byID[v.ID] = v.Token
+		m.byHash[v.Hash] = v.ID
+	}
+
+	return m, nil
+}
+
+func (m *MemStore) Create(t Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byID[t.ID]; ok {
+		return fmt.Errorf("token %s already exists", t.ID)
+	}
+	m.byID[t.ID] = t
+	m.byHash[t.Hash] = t.ID
+
+	if err := m.save(); err != nil {
+		delete(m.byID, t.ID)
+		delete(m.byHash, t.Hash)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MemStore) List() ([]Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]Token, 0, len(m.byID))
+	for _, v := range m.byID {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt) ||
+			result[i].CreatedAt.Equal(result[j].CreatedAt) && result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
+
+func (m *MemStore) Revoke(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.byID, id)
+	delete(m.byHash, t.Hash)
+
+	if err := m.save(); err != nil {
+		m.byID[id] = t
+		m.byHash[t.Hash] = id
+		return err
+	}
+
+	return nil
+}
+
+func (m *MemStore) Lookup(hash string) (Token, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.byHash[hash]
+	if !ok {
+		return Token{}, false, nil
+	}
+
+	return m.byID[id], true, nil
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
+
+// save перезаписывает файл через временный, вызывается под m.mu
+func (m *MemStore) save() error {
+	if m.filePath == "" {
+		return nil
+	}
+
+	list := make([]fileToken, 0, len(m.byID))
+	for _, v := range m.byID {
+		list = append(list, fileToken{Token: v, Hash: v.Hash})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.filePath), filepath.Base(m.filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.filePath)
+}
@@ -0,0 +1,144 @@
+// Package tokens bearer токены агентов с правами read/write/admin
+// и необязательным ограничением по префиксу имени метрики.
+// Сервер хранит только sha256 от секрета, сам секрет выдается один раз при создании
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"strings"
+	"time"
+)
+
+// Scope права токена, каждый следующий включает предыдущие
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"  // чтение /value
+	ScopeWrite Scope = "write" // запись /update, /updates и чтение
+	ScopeAdmin Scope = "admin" // управление токенами и все остальное
+)
+
+var scopeLevel = map[Scope]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// Valid известна ли область прав
+func (s Scope) Valid() bool {
+	_, ok := scopeLevel[s]
+	return ok
+}
+
+// Includes s дает права need
+func (s Scope) Includes(need Scope) bool {
+	return s.Valid() && scopeLevel[s] >= scopeLevel[need]
+}
+
+var ErrNotFound = errors.New("token not found")
+
+// Token описание токена без секрета
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     Scope     `json:"scope"`
+	Prefix    string    `json:"prefix,omitempty"` // пустой - любые метрики
+	Hash      string    `json:"-"`                // sha256 секрета, hex
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AllowsMetric разрешена ли токену метрика с именем name
+func (t Token) AllowsMetric(name string) bool {
+	return strings.HasPrefix(name, t.Prefix)
+}
+
+// Store хранилище токенов
+type Store interface {
+	Create(t Token) error
+	List() ([]Token, error)
+	// Revoke удаляет токен, ErrNotFound если его нет
+	Revoke(id string) error
+	// Lookup ищет токен по хешу секрета
+	Lookup(hash string) (Token, bool, error)
+	Close() error
+}
+
+// HashSecret хеш секрета, под которым токен лежит в хранилище
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate новый токен и его секрет
+func Generate(name string, scope Scope, prefix string) (Token, string, error) {
+	if !scope.Valid() {
+		return Token{}, "", fmt.Errorf("bad scope %q", scope)
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	return Token{
+		ID:        id,
+		Name:      name,
+		Scope:     scope,
+		Prefix:    prefix,
+		Hash:      HashSecret(secret),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}, secret, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// New создает хранилище токенов по dsn: mem://, file:///path или postgres://...
+func New(dsn string) (Store, error) {
+	scheme, path, ok := strings.Cut(dsn, "://")
+	if !ok || scheme == "" {
+		return nil, fmt.Errorf("tokens: no scheme in %q", dsn)
+	}
+
+	// схемы postgres те же, что у хранилища метрик, включая postgres+dsn:// из DATABASE_DSN
+	if conn, ok := storage.PostgresConn(dsn); ok {
+		return NewDBStore(conn)
+	}
+
+	switch scheme {
+	case "mem":
+		return NewMemStore(""), nil
+	case "file":
+		return OpenFileStore(path)
+	default:
+		return nil, fmt.Errorf("tokens: unknown scheme %q (mem, file, postgres, postgres+dsn)", scheme)
+	}
+}
+
+type ctxKey struct{}
+
+// NewContext кладет токен запроса в контекст
+func NewContext(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext токен запроса, false - авторизация выключена
+func FromContext(ctx context.Context) (Token, bool) {
+	t, ok := ctx.Value(ctxKey{}).(Token)
+	return t, ok
+}
@@ -0,0 +1,150 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	assert.True(t, ScopeAdmin.Includes(ScopeWrite))
+	assert.True(t, ScopeAdmin.Includes(ScopeRead))
+	assert.True(t, ScopeWrite.Includes(ScopeRead))
+	assert.True(t, ScopeRead.Includes(ScopeRead))
+	assert.False(t, ScopeRead.Includes(ScopeWrite))
+	assert.False(t, ScopeWrite.Includes(ScopeAdmin))
+	assert.False(t, Scope("root").Includes(ScopeRead))
+}
+
+func TestGenerate(t *testing.T) {
+	token, secret, err := Generate("host-1", ScopeWrite, "host1.")
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.ID)
+	assert.Len(t, secret, 64)
+	assert.Equal(t, HashSecret(secret), token.Hash)
+	assert.True(t, token.AllowsMetric("host1.Alloc"))
+	assert.False(t, token.AllowsMetric("host2.Alloc"))
+
+	other, otherSecret, err := Generate("host-2", ScopeRead, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, token.ID, other.ID)
+	assert.NotEqual(t, secret, otherSecret)
+	assert.True(t, other.AllowsMetric("anything"))
+
+	_, _, err = Generate("bad", Scope("root"), "")
+	assert.Error(t, err)
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	token := Token{ID: "1", Scope: ScopeRead}
+	got, ok := FromContext(NewContext(context.Background(), token))
+	assert.True(t, ok)
+	assert.Equal(t, token, got)
+}
+
+// testStore общие проверки реализаций Store
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	list, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	writeToken, writeSecret, err := Generate("agent", ScopeWrite, "host1.")
+	require.NoError(t, err)
+	readToken, _, err := Generate("dashboard", ScopeRead, "")
+	require.NoError(t, err)
+
+	require.NoError(t, store.Create(writeToken))
+	require.NoError(t, store.Create(readToken))
+
+	got, ok, err := store.Lookup(HashSecret(writeSecret))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, writeToken, got)
+
+	_, ok, err = store.Lookup(HashSecret("unknown"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	list, err = store.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	require.NoError(t, store.Revoke(writeToken.ID))
+	assert.ErrorIs(t, store.Revoke(writeToken.ID), ErrNotFound)
+
+	_, ok, err = store.Lookup(HashSecret(writeSecret))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	list, err = store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []Token{readToken}, list)
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore(""))
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	store, err := New("file://" + path)
+	require.NoError(t, err)
+	testStore(t, store)
+	require.NoError(t, store.Close())
+
+	// токены переживают перезапуск
+	token, secret, err := Generate("agent", ScopeWrite, "")
+	require.NoError(t, err)
+
+	store, err = OpenFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Create(token))
+
+	reopened, err := OpenFileStore(path)
+	require.NoError(t, err)
+	got, ok, err := reopened.Lookup(HashSecret(secret))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, token, got)
+
+	list, err := reopened.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
+
+func TestDBStore(t *testing.T) {
+	dsn := storagetest.PostgresDSN(t)
+
+	store, err := New(dsn)
+	require.NoError(t, err)
+	defer store.Close()
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("TRUNCATE tokens")
+	require.NoError(t, err)
+
+	testStore(t, store)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("redis://localhost")
+	assert.Error(t, err)
+	_, err = New("tokens.json")
+	assert.Error(t, err)
+
+	store, err := New("mem://")
+	require.NoError(t, err)
+	assert.IsType(t, &MemStore{}, store)
+}
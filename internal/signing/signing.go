@@ -0,0 +1,21 @@
+// Package signing конверт подписи запросов агент -> сервер. HMAC-SHA256 считается по timestamp,
+// nonce и данным: сервер проверяет timestamp и помнит nonce, поэтому перехваченный запрос нельзя повторить
+package signing
+
+// Заголовки конверта подписи
+const (
+	// HeaderTimestamp unix-время подписи в секундах
+	HeaderTimestamp = "HashSHA256-Timestamp"
+	// HeaderNonce одноразовое случайное значение
+	HeaderNonce = "HashSHA256-Nonce"
+)
+
+// Envelope конверт подписи: timestamp, nonce и данные через перевод строки
+func Envelope(timestamp, nonce string, payload []byte) []byte {
+	result := make([]byte, 0, len(timestamp)+len(nonce)+len(payload)+2)
+	result = append(result, timestamp...)
+	result = append(result, '\n')
+	result = append(result, nonce...)
+	result = append(result, '\n')
+	return append(result, payload...)
+}
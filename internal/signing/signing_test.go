@@ -0,0 +1,11 @@
+package signing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	assert.Equal(t, []byte("1700000000\nabc\n{\"id\":\"Alloc\"}"), Envelope("1700000000", "abc", []byte(`{"id":"Alloc"}`)))
+	assert.Equal(t, []byte("1\nn\n"), Envelope("1", "n", nil))
+}
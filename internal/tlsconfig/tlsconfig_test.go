@@ -0,0 +1,203 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/flags"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/server"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/server/storage"
+	"github.com/pochtalexa/ya-practicum-metrics/internal/tlsconfig"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCert самоподписанный (parent == nil) или подписанный parent сертификат
+func newCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+// write сохраняет сертификат и ключ в dir, возвращает пути
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.NoError(t, os.WriteFile(keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+// startServer запускает server.Server с TLS на случайном порту
+func startServer(t *testing.T, tlsCfg *tls.Config) string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg := flags.NewConfig()
+	cfg.TLSConfig = tlsCfg
+	srv := server.New(cfg, storage.NewMemStore(""), zerolog.Nop())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ctx, l)
+
+	return fmt.Sprintf("https://%s/update/counter/PollCount/1", l.Addr())
+}
+
+func post(tlsCfg *tls.Config, url string) (int, error) {
+	client := http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsCfg},
+	}
+
+	res, err := client.Post(url, "text/plain", nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	return res.StatusCode, nil
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newCert(t, "test-ca", nil, true)
+	caPath, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := newCert(t, "127.0.0.1", ca, false).write(t, dir, "server")
+
+	serverCfg, err := tlsconfig.ServerConfig(serverCert, serverKey, "")
+	require.NoError(t, err)
+	url := startServer(t, serverCfg)
+
+	clientCfg, err := tlsconfig.ClientConfig(caPath, "", "")
+	require.NoError(t, err)
+	status, err := post(clientCfg, url)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+
+	// системные CA не знают тестовый CA
+	_, err = post(&tls.Config{MinVersion: tls.VersionTLS12}, url)
+	assert.Error(t, err)
+
+	// plain http на TLS порт: net/http отвечает 400 без обработки запроса
+	status, err = post(nil, "http"+url[len("https"):])
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newCert(t, "test-ca", nil, true)
+	caPath, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := newCert(t, "127.0.0.1", ca, false).write(t, dir, "server")
+	clientCert, clientKey := newCert(t, "agent", ca, false).write(t, dir, "client")
+
+	// сертификат клиента, подписанный чужим CA
+	otherCA := newCert(t, "other-ca", nil, true)
+	otherCert, otherKey := newCert(t, "agent", otherCA, false).write(t, dir, "other")
+
+	serverCfg, err := tlsconfig.ServerConfig(serverCert, serverKey, caPath)
+	require.NoError(t, err)
+	url := startServer(t, serverCfg)
+
+	clientCfg, err := tlsconfig.ClientConfig(caPath, clientCert, clientKey)
+	require.NoError(t, err)
+	status, err := post(clientCfg, url)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+
+	noCertCfg, err := tlsconfig.ClientConfig(caPath, "", "")
+	require.NoError(t, err)
+	_, err = post(noCertCfg, url)
+	assert.Error(t, err)
+
+	otherCfg, err := tlsconfig.ClientConfig(caPath, otherCert, otherKey)
+	require.NoError(t, err)
+	_, err = post(otherCfg, url)
+	assert.Error(t, err)
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	certPath, keyPath := newCert(t, "127.0.0.1", nil, false).write(t, dir, "server")
+	garbage := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a pem"), 0600))
+
+	_, err := tlsconfig.ServerConfig(certPath, filepath.Join(dir, "missing.key"), "")
+	assert.Error(t, err)
+	_, err = tlsconfig.ServerConfig(certPath, keyPath, garbage)
+	assert.Error(t, err)
+
+	_, err = tlsconfig.ClientConfig(garbage, "", "")
+	assert.Error(t, err)
+	_, err = tlsconfig.ClientConfig("", certPath, "")
+	assert.Error(t, err)
+
+	cfg, err := tlsconfig.ClientConfig("", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, cfg.RootCAs)
+}